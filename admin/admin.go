@@ -0,0 +1,256 @@
+// Package admin serves an HTTP API to inspect and operate the outbox of a store, so that
+// operators can debug stuck messages without querying the repository by hand.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+)
+
+const (
+	// defaultPageSize is the page size of a configuration left zero
+	defaultPageSize = 50
+	// defaultMaxPageSize is the maximum page size of a configuration left zero
+	defaultMaxPageSize = 500
+)
+
+// Config defines the configuration of the admin handler.
+type Config struct {
+	// DefaultPageSize is the number of messages listed when no limit is asked for, 50 when zero.
+	DefaultPageSize int
+	// MaxPageSize bounds the limit asked for by a listing, 500 when zero.
+	MaxPageSize int
+}
+
+type handler struct {
+	// DI attributes
+	store store.IStore
+
+	// inside handler attributes
+	mux *http.ServeMux
+
+	// config handler attributes
+	cfg Config
+}
+
+// NewHandler returns the http.Handler of the admin API of the store. It has no authentication,
+// mount it with http.StripPrefix behind the middlewares of the service:
+//
+//	GET    /messages?state=&driver=&limit=&offset=  lists messages by state and driver
+//	GET    /messages/{id}                           returns a message
+//	POST   /messages/{id}/retry                     delivers a message waiting for a retry right away
+//	POST   /messages/{id}/requeue                   delivers a finished message again with a fresh retry budget
+//	POST   /messages/{id}/cancel                    cancels a pending message
+//	DELETE /messages/{id}                           deletes a finished message
+//	GET    /stats                                   counts the messages by driver and state
+func NewHandler(s store.IStore, cfg Config) http.Handler {
+	if cfg.DefaultPageSize <= 0 {
+		cfg.DefaultPageSize = defaultPageSize
+	}
+	if cfg.MaxPageSize <= 0 {
+		cfg.MaxPageSize = defaultMaxPageSize
+	}
+
+	h := &handler{
+		store: s,
+		mux:   http.NewServeMux(),
+		cfg:   cfg,
+	}
+
+	h.mux.HandleFunc("GET /messages", h.list)
+	h.mux.HandleFunc("GET /messages/{id}", h.get)
+	h.mux.HandleFunc("POST /messages/{id}/retry", h.act("retried", func(ctx context.Context, id int64) (int64, error) {
+		return h.store.RetryMessages(ctx, id)
+	}))
+	h.mux.HandleFunc("POST /messages/{id}/requeue", h.act("requeued", func(ctx context.Context, id int64) (int64, error) {
+		return h.store.RequeueMessages(ctx, dto.FinishedStates(), id)
+	}))
+	h.mux.HandleFunc("POST /messages/{id}/cancel", h.act("canceled", func(ctx context.Context, id int64) (int64, error) {
+		return h.store.CancelMessages(ctx, id)
+	}))
+	h.mux.HandleFunc("DELETE /messages/{id}", h.act("deleted", func(ctx context.Context, id int64) (int64, error) {
+		return h.store.DeleteMessages(ctx, dto.FinishedStates(), id)
+	}))
+	h.mux.HandleFunc("GET /stats", h.stats)
+
+	return h
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mux.ServeHTTP(w, r)
+}
+
+// listResponse is the page of messages returned by a listing
+type listResponse struct {
+	Messages []dto.Outbox `json:"messages"`
+	Limit    int          `json:"limit"`
+	Offset   int          `json:"offset"`
+}
+
+// list lists the messages of the states and driver of the query ordered by id
+func (h *handler) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	filter := dto.MessageFilter{
+		DriverName: query.Get("driver"),
+		Limit:      h.cfg.DefaultPageSize,
+	}
+	for _, state := range query["state"] {
+		if !dto.OutboxStateEnum(state).Valid() {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("unknown state %q", state))
+			return
+		}
+		filter.States = append(filter.States, dto.OutboxStateEnum(state))
+	}
+
+	var err error
+	if filter.Limit, err = intParam(query.Get("limit"), filter.Limit); err != nil || filter.Limit <= 0 {
+		writeError(w, http.StatusBadRequest, errors.New("limit must be a positive number"))
+		return
+	}
+	if filter.Limit > h.cfg.MaxPageSize {
+		filter.Limit = h.cfg.MaxPageSize
+	}
+	if filter.Offset, err = intParam(query.Get("offset"), 0); err != nil || filter.Offset < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("offset must be a positive number"))
+		return
+	}
+
+	messages, err := h.store.ListMessages(r.Context(), filter)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, listResponse{
+		Messages: messages,
+		Limit:    filter.Limit,
+		Offset:   filter.Offset,
+	})
+}
+
+// get returns the message of the path id
+func (h *handler) get(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := h.store.GetMessage(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, message)
+}
+
+// act returns the handler applying the operation to the message of the path id. The message is
+// returned once it is changed, the deleted message is gone. A message the operation does not apply
+// to in its current state is a conflict.
+func (h *handler) act(done string, operation func(ctx context.Context, id int64) (int64, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, ok := pathID(w, r)
+		if !ok {
+			return
+		}
+
+		affected, err := operation(r.Context(), id)
+		if err != nil {
+			writeStoreError(w, err)
+			return
+		}
+
+		if affected > 0 && r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		message, err := h.store.GetMessage(r.Context(), id)
+		switch {
+		case err != nil:
+			writeStoreError(w, err)
+		case affected == 0:
+			writeError(w, http.StatusConflict, fmt.Errorf("message %d is %s, it cannot be %s", id, message.State, done))
+		default:
+			writeJSON(w, http.StatusOK, message)
+		}
+	}
+}
+
+// statsResponse counts the messages by driver and state and their totals by state
+type statsResponse struct {
+	Stats  []dto.MessageStats            `json:"stats"`
+	States map[dto.OutboxStateEnum]int64 `json:"states"`
+	Total  int64                         `json:"total"`
+}
+
+// stats counts the messages by driver and state
+func (h *handler) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.store.Stats(r.Context())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	response := statsResponse{
+		Stats:  stats,
+		States: make(map[dto.OutboxStateEnum]int64),
+	}
+	for _, stat := range stats {
+		response.States[stat.State] += stat.Count
+		response.Total += stat.Count
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// pathID parses the id of the path, a bad request is written when it is not a number
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid message id %q", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+// intParam parses the query parameter, the fallback is returned when it is empty
+func intParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// errorResponse is the body of a failed request
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// writeStoreError writes the error of the store, a missing message is not found
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, constant.ErrMessageNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
+
+// writeError writes the error as a json body with the status
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// writeJSON writes the value as a json body with the status
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
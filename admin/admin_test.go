@@ -0,0 +1,139 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupHandler returns the handler of a memory store holding a pending message of the driver
+// grpc, a succeeded message of the driver grpc and a pending message of the driver kafka
+func setupHandler(t *testing.T) (http.Handler, []dto.Outbox) {
+	t.Helper()
+	ctx := context.Background()
+
+	s, err := store.NewStore(store.NewOutboxMemoryRepository(store.RepoSetting{TableName: "outbox"}), store.Setting{NodeID: 1})
+	assert.NoError(t, err)
+
+	grpc, err := store.AddValues(ctx, s, "grpc", "pending", "succeeded")
+	assert.NoError(t, err)
+	kafka, err := store.AddValues(ctx, s, "kafka", "pending")
+	assert.NoError(t, err)
+	messages := append(grpc.Accepted, kafka.Accepted...)
+
+	fetched, err := s.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, fetched, 2)
+	assert.NoError(t, s.MarkAsProcessed(ctx, messages[1].ID))
+	assert.NoError(t, s.Release(ctx, messages[0].ID))
+
+	return NewHandler(s, Config{DefaultPageSize: 2, MaxPageSize: 10}), messages
+}
+
+// serve sends the request to the handler and decodes the json body of the response into out
+func serve(t *testing.T, h http.Handler, method, target string, out any) int {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+	if out != nil && recorder.Body.Len() > 0 {
+		assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), out))
+	}
+	return recorder.Code
+}
+
+func TestHandler_ListMessages(t *testing.T) {
+	h, messages := setupHandler(t)
+
+	var page listResponse
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/messages", &page))
+	assert.Equal(t, 2, page.Limit)
+	if assert.Len(t, page.Messages, 2) {
+		assert.Equal(t, messages[0].ID, page.Messages[0].ID)
+		assert.Equal(t, messages[1].ID, page.Messages[1].ID)
+	}
+
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/messages?limit=100&offset=1", &page))
+	assert.Equal(t, 10, page.Limit)
+	assert.Len(t, page.Messages, 2)
+
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/messages?state=PENDING&driver=grpc", &page))
+	if assert.Len(t, page.Messages, 1) {
+		assert.Equal(t, messages[0].ID, page.Messages[0].ID)
+	}
+
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/messages?state=SUCCEED&state=FAILED", &page))
+	if assert.Len(t, page.Messages, 1) {
+		assert.Equal(t, messages[1].ID, page.Messages[0].ID)
+	}
+
+	var failure errorResponse
+	assert.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/messages?state=DONE", &failure))
+	assert.Contains(t, failure.Error, "DONE")
+	assert.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/messages?limit=-1", &failure))
+	assert.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/messages?offset=first", &failure))
+}
+
+func TestHandler_GetMessage(t *testing.T) {
+	h, messages := setupHandler(t)
+
+	var message dto.Outbox
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, fmt.Sprintf("/messages/%d", messages[1].ID), &message))
+	assert.Equal(t, messages[1].ID, message.ID)
+	assert.Equal(t, dto.OutboxStateSucceed, message.State)
+
+	var failure errorResponse
+	assert.Equal(t, http.StatusNotFound, serve(t, h, http.MethodGet, "/messages/1", &failure))
+	assert.NotEmpty(t, failure.Error)
+	assert.Equal(t, http.StatusBadRequest, serve(t, h, http.MethodGet, "/messages/first", &failure))
+}
+
+func TestHandler_Operations(t *testing.T) {
+	h, messages := setupHandler(t)
+	pending, succeeded := messages[0].ID, messages[1].ID
+
+	var message dto.Outbox
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodPost, fmt.Sprintf("/messages/%d/requeue", succeeded), &message))
+	assert.Equal(t, dto.OutboxStatePending, message.State)
+
+	var failure errorResponse
+	assert.Equal(t, http.StatusConflict, serve(t, h, http.MethodPost, fmt.Sprintf("/messages/%d/requeue", pending), &failure))
+	assert.Contains(t, failure.Error, "PENDING")
+	assert.Equal(t, http.StatusConflict, serve(t, h, http.MethodPost, fmt.Sprintf("/messages/%d/retry", pending), &failure))
+
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodPost, fmt.Sprintf("/messages/%d/cancel", pending), &message))
+	assert.Equal(t, dto.OutboxStateCanceled, message.State)
+	assert.Equal(t, http.StatusConflict, serve(t, h, http.MethodPost, fmt.Sprintf("/messages/%d/cancel", pending), &failure))
+
+	assert.Equal(t, http.StatusConflict, serve(t, h, http.MethodDelete, fmt.Sprintf("/messages/%d", succeeded), &failure))
+	assert.Equal(t, http.StatusNoContent, serve(t, h, http.MethodDelete, fmt.Sprintf("/messages/%d", pending), nil))
+	assert.Equal(t, http.StatusNotFound, serve(t, h, http.MethodGet, fmt.Sprintf("/messages/%d", pending), &failure))
+	assert.Equal(t, http.StatusNotFound, serve(t, h, http.MethodPost, "/messages/1/retry", &failure))
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(t, h, http.MethodPut, fmt.Sprintf("/messages/%d", succeeded), nil))
+}
+
+func TestHandler_Stats(t *testing.T) {
+	h, _ := setupHandler(t)
+
+	var stats statsResponse
+	assert.Equal(t, http.StatusOK, serve(t, h, http.MethodGet, "/stats", &stats))
+	assert.Equal(t, []dto.MessageStats{
+		{DriverName: "grpc", State: dto.OutboxStatePending, Count: 1},
+		{DriverName: "grpc", State: dto.OutboxStateSucceed, Count: 1},
+		{DriverName: "kafka", State: dto.OutboxStatePending, Count: 1},
+	}, stats.Stats)
+	assert.Equal(t, map[dto.OutboxStateEnum]int64{
+		dto.OutboxStatePending: 2,
+		dto.OutboxStateSucceed: 1,
+	}, stats.States)
+	assert.Equal(t, int64(3), stats.Total)
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+)
+
+const (
+	// defaultListLimit is the number of messages listed without -limit
+	defaultListLimit = 50
+	// defaultBatchSize is the number of messages a bulk operation changes at once without -batch
+	defaultBatchSize = 500
+	// errorWidth truncates the errors of the listed messages
+	errorWidth = 60
+)
+
+// migrateCommand creates the outbox table and its indexes
+func migrateCommand(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: unexpected arguments %v", errUsage, args)
+		}
+		repo, err := c.conn.repository(ctx)
+		if err != nil {
+			return err
+		}
+		if err := store.Migrate(ctx, repo); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "migrated %s\n", repo.GetTableName())
+		return nil
+	}
+}
+
+// statsCommand counts the messages by driver and state
+func statsCommand(fs *flag.FlagSet) runFunc {
+	asJSON := fs.Bool("json", false, "print the counts as json")
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: unexpected arguments %v", errUsage, args)
+		}
+		s, err := c.conn.store(ctx)
+		if err != nil {
+			return err
+		}
+		stats, err := s.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(c.stdout, stats)
+		}
+
+		var total int64
+		table := newTable(c.stdout, "DRIVER", "STATE", "COUNT")
+		for _, stat := range stats {
+			fmt.Fprintf(table, "%s\t%s\t%d\n", stat.DriverName, stat.State, stat.Count)
+			total += stat.Count
+		}
+		fmt.Fprintf(table, "TOTAL\t\t%d\n", total)
+		return table.Flush()
+	}
+}
+
+// listCommand lists messages by state and driver ordered by id
+func listCommand(fs *flag.FlagSet) runFunc {
+	var states stateList
+	fs.Var(&states, "state", "list the messages in the state, repeated or separated by commas (default every state)")
+	driver := fs.String("driver", "", "list the messages of the driver (default every driver)")
+	limit := fs.Int("limit", defaultListLimit, "maximum number of messages listed")
+	offset := fs.Int("offset", 0, "number of messages skipped")
+	asJSON := fs.Bool("json", false, "print the messages as json")
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: unexpected arguments %v", errUsage, args)
+		}
+		if *limit <= 0 || *offset < 0 {
+			return fmt.Errorf("%w: -limit must be positive and -offset must not be negative", errUsage)
+		}
+		s, err := c.conn.store(ctx)
+		if err != nil {
+			return err
+		}
+		messages, err := s.ListMessages(ctx, dto.MessageFilter{
+			States:     states,
+			DriverName: *driver,
+			Limit:      *limit,
+			Offset:     *offset,
+		})
+		if err != nil {
+			return err
+		}
+		if *asJSON {
+			return printJSON(c.stdout, messages)
+		}
+		return printMessages(c.stdout, messages)
+	}
+}
+
+// showCommand prints a message as json
+func showCommand(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("%w: expected a message id", errUsage)
+		}
+		ids, err := parseIDs(args)
+		if err != nil {
+			return err
+		}
+		s, err := c.conn.store(ctx)
+		if err != nil {
+			return err
+		}
+		message, err := s.GetMessage(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		return printJSON(c.stdout, message)
+	}
+}
+
+// tailCommand prints the messages as they are added until it is interrupted. The messages are
+// followed by their snowflake ids, so messages of a custom id generator are not printed.
+func tailCommand(fs *flag.FlagSet) runFunc {
+	driver := fs.String("driver", "", "print the messages of the driver (default every driver)")
+	since := fs.Duration("since", 0, "also print the messages added this long before the start")
+	interval := fs.Duration("interval", time.Second, "how often the backend is polled")
+	asJSON := fs.Bool("json", false, "print every message as a line of json")
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(args) > 0 {
+			return fmt.Errorf("%w: unexpected arguments %v", errUsage, args)
+		}
+		if *interval <= 0 || *since < 0 {
+			return fmt.Errorf("%w: -interval must be positive and -since must not be negative", errUsage)
+		}
+		s, err := c.conn.store(ctx)
+		if err != nil {
+			return err
+		}
+
+		filter := dto.MessageFilter{
+			DriverName: *driver,
+			AfterID:    store.SnowflakeIDAt(time.Now().Add(-*since)) - 1,
+			Limit:      defaultBatchSize,
+		}
+		encoder := json.NewEncoder(c.stdout)
+		for {
+			messages, err := s.ListMessages(ctx, filter)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+
+			for _, message := range messages {
+				if *asJSON {
+					err = encoder.Encode(message)
+				} else {
+					_, err = fmt.Fprintln(c.stdout, messageRow(message, " "))
+				}
+				if err != nil {
+					return err
+				}
+				filter.AfterID = message.ID
+			}
+
+			// a full page is followed by the next one right away
+			if len(messages) == filter.Limit {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(*interval):
+			}
+		}
+	}
+}
+
+// requeueCommand delivers failed, dead-lettered, expired or canceled messages again
+func requeueCommand(fs *flag.FlagSet) runFunc {
+	var states stateList
+	fs.Var(&states, "state", "requeue the messages in the finished state, repeated or separated by commas (default FAILED,DEAD_LETTERED,EXPIRED,CANCELED)")
+	sel := selectionFlags(fs)
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(states) == 0 {
+			states = stateList{dto.OutboxStateFailed, dto.OutboxStateDeadLettered, dto.OutboxStateExpired, dto.OutboxStateCanceled}
+		}
+		return requeue(ctx, c, sel, states, args, "requeued")
+	}
+}
+
+// replayCommand delivers succeeded messages again
+func replayCommand(fs *flag.FlagSet) runFunc {
+	sel := selectionFlags(fs)
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		return requeue(ctx, c, sel, stateList{dto.OutboxStateSucceed}, args, "replayed")
+	}
+}
+
+// requeue returns the selected messages in one of the states to the pending state with a fresh retry budget
+func requeue(ctx context.Context, c *cli, sel *selection, states stateList, args []string, done string) error {
+	return sel.apply(ctx, c, states, args, done, func(ctx context.Context, s store.IStore, ids []int64) (int64, error) {
+		return s.RequeueMessages(ctx, states, ids...)
+	})
+}
+
+// purgeCommand deletes finished messages
+func purgeCommand(fs *flag.FlagSet) runFunc {
+	var states stateList
+	fs.Var(&states, "state", "delete the messages in the finished state, repeated or separated by commas (default every finished state)")
+	sel := selectionFlags(fs)
+	fs.DurationVar(&sel.olderThan, "older-than", 0, "only delete the messages finished longer than this ago")
+
+	return func(ctx context.Context, c *cli, args []string) error {
+		if len(states) == 0 {
+			states = dto.FinishedStates()
+		}
+		return sel.apply(ctx, c, states, args, "deleted", func(ctx context.Context, s store.IStore, ids []int64) (int64, error) {
+			return s.DeleteMessages(ctx, states, ids...)
+		})
+	}
+}
+
+// selection holds the flags selecting the messages of a bulk operation
+type selection struct {
+	driver    string
+	all       bool
+	dryRun    bool
+	batch     int
+	olderThan time.Duration
+}
+
+// selectionFlags registers the flags selecting the messages of a bulk operation
+func selectionFlags(fs *flag.FlagSet) *selection {
+	sel := &selection{}
+	fs.StringVar(&sel.driver, "driver", "", "only select the messages of the driver")
+	fs.BoolVar(&sel.all, "all", false, "select every message in the states instead of the given ids")
+	fs.BoolVar(&sel.dryRun, "dry-run", false, "print the selected messages without changing them")
+	fs.IntVar(&sel.batch, "batch", defaultBatchSize, "number of messages changed at once")
+	return sel
+}
+
+// apply applies the operation to the messages in one of the finished states selected by the ids
+// of the arguments or by -all, batch by batch in id order
+func (sel *selection) apply(
+	ctx context.Context,
+	c *cli,
+	states stateList,
+	args []string,
+	done string,
+	operation func(ctx context.Context, s store.IStore, ids []int64) (int64, error),
+) error {
+	for _, state := range states {
+		if !state.Finished() {
+			return fmt.Errorf("%w: %s is not a finished state", errUsage, state)
+		}
+	}
+	if sel.batch <= 0 {
+		return fmt.Errorf("%w: -batch must be positive", errUsage)
+	}
+	if len(args) == 0 && !sel.all {
+		return fmt.Errorf("%w: no message ids, pass -all to select every message in %s", errUsage, states)
+	}
+	if len(args) > 0 && sel.all {
+		return fmt.Errorf("%w: -all selects every message, it cannot be given with ids", errUsage)
+	}
+	ids, err := parseIDs(args)
+	if err != nil {
+		return err
+	}
+
+	s, err := c.conn.store(ctx)
+	if err != nil {
+		return err
+	}
+
+	filter := dto.MessageFilter{
+		IDs:        ids,
+		States:     states,
+		DriverName: sel.driver,
+		Limit:      sel.batch,
+	}
+	finishedBefore := time.Now().Add(-sel.olderThan)
+
+	var (
+		affected int64
+		selected []dto.Outbox
+	)
+	for {
+		messages, err := s.ListMessages(ctx, filter)
+		if err != nil {
+			return err
+		}
+
+		batch := make([]int64, 0, len(messages))
+		for _, message := range messages {
+			if sel.olderThan > 0 && !message.FinishedAt().Before(finishedBefore) {
+				continue
+			}
+			batch = append(batch, message.ID)
+			if sel.dryRun {
+				selected = append(selected, message)
+			}
+		}
+
+		if !sel.dryRun && len(batch) > 0 {
+			count, err := operation(ctx, s, batch)
+			if err != nil {
+				return fmt.Errorf("%d messages %s before: %w", affected, done, err)
+			}
+			affected += count
+		}
+
+		if len(messages) < filter.Limit {
+			break
+		}
+		filter.AfterID = messages[len(messages)-1].ID
+	}
+
+	if sel.dryRun {
+		if err := printMessages(c.stdout, selected); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "%d messages would be %s\n", len(selected), done)
+		return nil
+	}
+	fmt.Fprintf(c.stdout, "%d messages %s\n", affected, done)
+	return nil
+}
+
+// stateList is a flag of message states, repeated or separated by commas
+type stateList []dto.OutboxStateEnum
+
+func (l *stateList) String() string {
+	if l == nil {
+		return ""
+	}
+	states := make([]string, len(*l))
+	for i, state := range *l {
+		states[i] = string(state)
+	}
+	return strings.Join(states, ",")
+}
+
+func (l *stateList) Set(value string) error {
+	for _, name := range strings.Split(value, ",") {
+		state := dto.OutboxStateEnum(strings.ToUpper(strings.TrimSpace(name)))
+		if !state.Valid() {
+			return fmt.Errorf("unknown state %q", name)
+		}
+		*l = append(*l, state)
+	}
+	return nil
+}
+
+// parseIDs parses the message ids of the arguments
+func parseIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid message id %q", errUsage, arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// newTable returns a writer aligning the tab separated columns of the rows under the header
+func newTable(w io.Writer, header ...string) *tabwriter.Writer {
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, strings.Join(header, "\t"))
+	return table
+}
+
+// printMessages prints the messages as a table
+func printMessages(w io.Writer, messages []dto.Outbox) error {
+	table := newTable(w, "ID", "DRIVER", "STATE", "ATTEMPTS", "CREATED_AT", "NEXT_ATTEMPT_AT", "ERROR")
+	for _, message := range messages {
+		fmt.Fprintln(table, messageRow(message, "\t"))
+	}
+	return table.Flush()
+}
+
+// messageRow returns the columns of the message table joined by the separator
+func messageRow(message dto.Outbox, separator string) string {
+	var (
+		attempts      int64
+		nextAttemptAt = "-"
+		reason        = "-"
+	)
+	if message.NumberOfAttempts != nil {
+		attempts = *message.NumberOfAttempts
+	}
+	if message.NextAttemptAt != nil {
+		nextAttemptAt = message.NextAttemptAt.Format(time.RFC3339)
+	}
+	if message.Error != nil && *message.Error != "" {
+		reason = truncate(strings.Join(strings.Fields(*message.Error), " "), errorWidth)
+	}
+	return strings.Join([]string{
+		strconv.FormatInt(message.ID, 10),
+		message.DriverName,
+		string(message.State),
+		strconv.FormatInt(attempts, 10),
+		message.CreatedAt.Format(time.RFC3339),
+		nextAttemptAt,
+		reason,
+	}, separator)
+}
+
+// truncate shortens the text to width runes
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+// printJSON prints the value as indented json
+func printJSON(w io.Writer, value any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq" // PostgreSQL driver
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	backendPostgres    = "postgres"
+	backendMySQL       = "mysql"
+	backendSqlite      = "sqlite"
+	backendRedis       = "redis"
+	backendRedisStream = "redis-stream"
+	// defaultTable is the outbox table of a run without -table
+	defaultTable = "outbox"
+)
+
+// connection holds the connection flags and connects to the backend they select on first use
+type connection struct {
+	backend string
+	dsn     string
+	table   string
+
+	repo    store.IRepository
+	closers []func() error
+}
+
+// connectionFlags registers the connection flags, their defaults are read from the environment
+func connectionFlags(fs *flag.FlagSet, getenv func(string) string) *connection {
+	conn := &connection{}
+	fs.StringVar(&conn.backend, "backend", envOr(getenv, "GBOX_BACKEND", backendPostgres),
+		"backend of the outbox: postgres, mysql, sqlite, redis or redis-stream (GBOX_BACKEND)")
+	fs.StringVar(&conn.dsn, "dsn", getenv("GBOX_DSN"),
+		"postgres or mysql connection string, sqlite file path or redis url of the backend (GBOX_DSN)")
+	fs.StringVar(&conn.table, "table", envOr(getenv, "GBOX_TABLE", defaultTable),
+		"outbox table, the key prefix of the redis backends (GBOX_TABLE)")
+	return conn
+}
+
+// envOr returns the environment variable, the fallback when it is empty
+func envOr(getenv func(string) string, key, fallback string) string {
+	if value := getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// repository connects to the backend and returns the repository of the outbox table
+func (c *connection) repository(ctx context.Context) (store.IRepository, error) {
+	if c.repo != nil {
+		return c.repo, nil
+	}
+	if c.dsn == "" {
+		return nil, fmt.Errorf("%w: no dsn, set -dsn or GBOX_DSN", errUsage)
+	}
+
+	setting := store.RepoSetting{TableName: c.table}
+	switch c.backend {
+	case backendPostgres:
+		db, err := sql.Open("postgres", c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		c.closers = append(c.closers, db.Close)
+		if err := db.PingContext(ctx); err != nil {
+			return nil, fmt.Errorf("connect to postgres: %w", err)
+		}
+		c.repo = store.NewOutboxSqlRepository(setting, db)
+	case backendMySQL:
+		config, err := mysql.ParseDSN(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		// the mysql dialect scans the timestamps into time.Time
+		config.ParseTime = true
+		db, err := sql.Open("mysql", config.FormatDSN())
+		if err != nil {
+			return nil, err
+		}
+		c.closers = append(c.closers, db.Close)
+		if err := db.PingContext(ctx); err != nil {
+			return nil, fmt.Errorf("connect to mysql: %w", err)
+		}
+		setting.Dialect = store.MySQL
+		c.repo = store.NewOutboxSqlRepository(setting, db)
+	case backendSqlite:
+		db, err := store.OpenSqlite(c.dsn)
+		if err != nil {
+			return nil, fmt.Errorf("open sqlite: %w", err)
+		}
+		c.closers = append(c.closers, db.Close)
+		c.repo = store.NewOutboxSqliteRepository(setting, db)
+	case backendRedis, backendRedisStream:
+		options, err := redis.ParseURL(c.dsn)
+		if err != nil {
+			return nil, err
+		}
+		client := redis.NewClient(options)
+		c.closers = append(c.closers, client.Close)
+		if err := client.Ping(ctx).Err(); err != nil {
+			return nil, fmt.Errorf("connect to redis: %w", err)
+		}
+		if c.backend == backendRedis {
+			c.repo = store.NewOutboxRedisRepository(setting, client)
+		} else {
+			c.repo = store.NewOutboxRedisStreamRepository(setting, client)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown backend %q", errUsage, c.backend)
+	}
+	return c.repo, nil
+}
+
+// store connects to the backend and returns the store of the outbox table
+func (c *connection) store(ctx context.Context) (store.IStore, error) {
+	repo, err := c.repository(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return store.NewStore(repo, store.Setting{})
+}
+
+// close closes the connections opened to the backend
+func (c *connection) close() error {
+	var errs []error
+	for _, closer := range c.closers {
+		errs = append(errs, closer())
+	}
+	c.closers, c.repo = nil, nil
+	return errors.Join(errs...)
+}
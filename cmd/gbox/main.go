@@ -0,0 +1,123 @@
+// Command gbox operates the outbox of a service from the command line, so that on-call engineers
+// can inspect, requeue and purge messages of any supported backend without writing queries.
+//
+//	gbox <command> [flags] [ids]
+//
+// The backend is selected with -backend and -dsn or the GBOX_BACKEND and GBOX_DSN environment
+// variables, run gbox help for the commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	os.Exit(run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv))
+}
+
+// command is a subcommand of the cli
+type command struct {
+	name    string
+	args    string
+	summary string
+	// setup registers the flags of the command besides the connection flags
+	setup func(fs *flag.FlagSet) runFunc
+}
+
+// runFunc runs a command with the arguments left after its flags
+type runFunc func(ctx context.Context, c *cli, args []string) error
+
+// cli holds the streams and the environment of a run
+type cli struct {
+	stdout io.Writer
+	stderr io.Writer
+	conn   *connection
+}
+
+// errUsage is returned by a command called with invalid arguments, its usage is printed
+var errUsage = errors.New("invalid usage")
+
+// commands returns the subcommands of the cli in the order of the help
+func commands() []command {
+	return []command{
+		{name: "migrate", summary: "create the outbox table and its indexes", setup: migrateCommand},
+		{name: "stats", summary: "count the messages by driver and state", setup: statsCommand},
+		{name: "list", summary: "list messages by state and driver", setup: listCommand},
+		{name: "show", args: "id", summary: "print a message", setup: showCommand},
+		{name: "tail", summary: "print the messages as they are added", setup: tailCommand},
+		{name: "requeue", args: "[id ...]", summary: "deliver failed, dead-lettered, expired or canceled messages again", setup: requeueCommand},
+		{name: "replay", args: "[id ...]", summary: "deliver succeeded messages again", setup: replayCommand},
+		{name: "purge", args: "[id ...]", summary: "delete finished messages", setup: purgeCommand},
+	}
+}
+
+// run runs the command of the arguments and returns the exit code of the process
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		printUsage(stderr)
+		if len(args) == 0 {
+			return 2
+		}
+		return 0
+	}
+
+	var cmd *command
+	for _, c := range commands() {
+		if c.name == args[0] {
+			cmd = &c
+			break
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(stderr, "gbox: unknown command %q\n\n", args[0])
+		printUsage(stderr)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("gbox "+cmd.name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: gbox %s [flags] %s\n\n%s.\n\nflags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	conn := connectionFlags(fs, getenv)
+	exec := cmd.setup(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	c := &cli{stdout: stdout, stderr: stderr, conn: conn}
+	defer c.conn.close()
+
+	if err := exec(ctx, c, fs.Args()); err != nil {
+		fmt.Fprintf(stderr, "gbox %s: %v\n", cmd.name, err)
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// printUsage prints the commands of the cli
+func printUsage(w io.Writer) {
+	fmt.Fprint(w, "gbox operates the outbox of a service.\n\nusage: gbox <command> [flags] [ids]\n\ncommands:\n")
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprint(w, "\nrun gbox <command> -h for the flags of a command.\n")
+}
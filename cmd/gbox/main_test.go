@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setupDatabase migrates a sqlite outbox through the cli and adds a pending message of the driver
+// kafka, a dead-lettered and a succeeded message of the driver grpc, it returns the environment
+// of the database and the ids of the messages
+func setupDatabase(t *testing.T) (func(string) string, []int64) {
+	t.Helper()
+	ctx := context.Background()
+
+	env := map[string]string{
+		"GBOX_BACKEND": backendSqlite,
+		"GBOX_DSN":     filepath.Join(t.TempDir(), "outbox.db"),
+	}
+	getenv := func(key string) string { return env[key] }
+
+	stdout, stderr, code := runCLI(t, getenv, "migrate")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "migrated outbox\n", stdout)
+
+	db, err := store.OpenSqlite(env["GBOX_DSN"])
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	s, err := store.NewStore(store.NewOutboxSqliteRepository(store.RepoSetting{TableName: defaultTable}, db), store.Setting{NodeID: 1})
+	assert.NoError(t, err)
+
+	kafka, err := store.AddValues(ctx, s, "kafka", "pending")
+	assert.NoError(t, err)
+	grpc, err := store.AddValues(ctx, s, "grpc", "dead", "succeeded")
+	assert.NoError(t, err)
+	ids := []int64{kafka.Accepted[0].ID, grpc.Accepted[0].ID, grpc.Accepted[1].ID}
+
+	fetched, err := s.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, fetched, 3)
+	assert.NoError(t, s.Release(ctx, ids[0]))
+	assert.NoError(t, s.MarkAsDeadLettered(ctx, ids[1], errors.New("connection\nrefused")))
+	assert.NoError(t, s.MarkAsProcessed(ctx, ids[2]))
+
+	return getenv, ids
+}
+
+// runCLI runs the cli with the arguments and returns its output and exit code
+func runCLI(t *testing.T, getenv func(string) string, args ...string) (string, string, int) {
+	t.Helper()
+	return runCLIContext(context.Background(), getenv, args...)
+}
+
+func runCLIContext(ctx context.Context, getenv func(string) string, args ...string) (string, string, int) {
+	var stdout, stderr bytes.Buffer
+	code := run(ctx, args, &stdout, &stderr, getenv)
+	return stdout.String(), stderr.String(), code
+}
+
+// listedIDs runs list -json with the flags and returns the ids of the listed messages
+func listedIDs(t *testing.T, getenv func(string) string, flags ...string) []int64 {
+	t.Helper()
+	stdout, stderr, code := runCLI(t, getenv, append([]string{"list", "-json"}, flags...)...)
+	assert.Equal(t, 0, code, stderr)
+
+	var messages []dto.Outbox
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &messages))
+	ids := make([]int64, 0, len(messages))
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+func TestRun_Usage(t *testing.T) {
+	getenv := func(string) string { return "" }
+
+	_, stderr, code := runCLI(t, getenv)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "requeue")
+
+	_, stderr, code = runCLI(t, getenv, "help")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stderr, "replay")
+
+	_, stderr, code = runCLI(t, getenv, "drop")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "drop"`)
+
+	_, stderr, code = runCLI(t, getenv, "stats")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "GBOX_DSN")
+
+	_, stderr, code = runCLI(t, getenv, "stats", "-backend", "mongo", "-dsn", "mongodb://localhost")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown backend "mongo"`)
+
+	_, stderr, code = runCLI(t, getenv, "stats", "-backend", "mysql", "-dsn", "localhost:3306")
+	assert.Equal(t, 1, code)
+	assert.Contains(t, stderr, "invalid DSN")
+
+	_, stderr, code = runCLI(t, getenv, "list", "-state", "DONE")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown state "DONE"`)
+}
+
+func TestRun_StatsAndList(t *testing.T) {
+	getenv, ids := setupDatabase(t)
+
+	stdout, stderr, code := runCLI(t, getenv, "stats")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, []string{
+		"DRIVER  STATE          COUNT",
+		"grpc    DEAD_LETTERED  1",
+		"grpc    SUCCEED        1",
+		"kafka   PENDING        1",
+		"TOTAL                  3",
+	}, strings.Split(strings.TrimSpace(stdout), "\n"))
+
+	assert.Equal(t, ids, listedIDs(t, getenv))
+	assert.Equal(t, ids[1:], listedIDs(t, getenv, "-driver", "grpc"))
+	assert.Equal(t, ids[:2], listedIDs(t, getenv, "-state", "pending,DEAD_LETTERED"))
+	assert.Equal(t, ids[1:2], listedIDs(t, getenv, "-limit", "1", "-offset", "1"))
+
+	stdout, stderr, code = runCLI(t, getenv, "list", "-state", "DEAD_LETTERED")
+	assert.Equal(t, 0, code, stderr)
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if assert.Len(t, lines, 2) {
+		assert.True(t, strings.HasPrefix(lines[0], "ID "))
+		assert.Contains(t, lines[1], strconv.FormatInt(ids[1], 10))
+		assert.Contains(t, lines[1], "connection refused")
+	}
+
+	stdout, stderr, code = runCLI(t, getenv, "show", strconv.FormatInt(ids[2], 10))
+	assert.Equal(t, 0, code, stderr)
+	var message dto.Outbox
+	assert.NoError(t, json.Unmarshal([]byte(stdout), &message))
+	assert.Equal(t, dto.OutboxStateSucceed, message.State)
+
+	_, stderr, code = runCLI(t, getenv, "show", "1")
+	assert.Equal(t, 1, code)
+	assert.NotEmpty(t, stderr)
+}
+
+func TestRun_RequeueAndReplay(t *testing.T) {
+	getenv, ids := setupDatabase(t)
+
+	_, stderr, code := runCLI(t, getenv, "requeue")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "-all")
+
+	_, stderr, code = runCLI(t, getenv, "requeue", "-state", "PENDING", "-all")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "PENDING is not a finished state")
+
+	stdout, stderr, code := runCLI(t, getenv, "requeue", "-all", "-dry-run")
+	assert.Equal(t, 0, code, stderr)
+	assert.Contains(t, stdout, "1 messages would be requeued")
+	assert.Equal(t, ids[:2], listedIDs(t, getenv, "-state", "PENDING,DEAD_LETTERED"))
+
+	stdout, stderr, code = runCLI(t, getenv, "requeue", "-batch", "1", "-all")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "1 messages requeued\n", stdout)
+	assert.Equal(t, ids[:2], listedIDs(t, getenv, "-state", "PENDING"))
+
+	// the pending message is not a succeeded message to replay
+	stdout, stderr, code = runCLI(t, getenv, "replay", strconv.FormatInt(ids[0], 10), strconv.FormatInt(ids[2], 10))
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "1 messages replayed\n", stdout)
+	assert.Equal(t, ids, listedIDs(t, getenv, "-state", "PENDING"))
+}
+
+func TestRun_Purge(t *testing.T) {
+	getenv, ids := setupDatabase(t)
+
+	// the messages just finished
+	stdout, stderr, code := runCLI(t, getenv, "purge", "-all", "-older-than", "1h")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "0 messages deleted\n", stdout)
+
+	stdout, stderr, code = runCLI(t, getenv, "purge", "-state", "SUCCEED", "-all")
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "1 messages deleted\n", stdout)
+	assert.Equal(t, ids[:2], listedIDs(t, getenv))
+
+	// the pending message is not finished
+	stdout, stderr, code = runCLI(t, getenv, "purge", strconv.FormatInt(ids[0], 10), strconv.FormatInt(ids[1], 10))
+	assert.Equal(t, 0, code, stderr)
+	assert.Equal(t, "1 messages deleted\n", stdout)
+	assert.Equal(t, ids[:1], listedIDs(t, getenv))
+}
+
+func TestRun_Tail(t *testing.T) {
+	getenv, ids := setupDatabase(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	stdout, stderr, code := runCLIContext(ctx, getenv, "tail", "-json", "-since", "1h", "-interval", "10ms", "-driver", "grpc")
+	assert.Equal(t, 0, code, stderr)
+
+	var tailed []int64
+	decoder := json.NewDecoder(strings.NewReader(stdout))
+	for decoder.More() {
+		var message dto.Outbox
+		assert.NoError(t, decoder.Decode(&message))
+		tailed = append(tailed, message.ID)
+	}
+	assert.Equal(t, ids[1:], tailed)
+
+	stdout, stderr, code = runCLIContext(ctx, getenv, "tail")
+	assert.Equal(t, 0, code, stderr)
+	assert.Empty(t, stdout)
+}
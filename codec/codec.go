@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sync"
+
+	"github.com/ghaninia/gbox/dto"
+)
+
+type ICodec interface {
+	// ContentType returns the media type stored alongside the payloads of the codec
+	ContentType() string
+	// Binary reports whether the encoded payloads are binary, they are stored base64 encoded
+	Binary() bool
+	// Marshal encodes the value
+	Marshal(v any) ([]byte, error)
+	// Unmarshal decodes the data into the value pointed to by v
+	Unmarshal(data []byte, v any) error
+}
+
+var (
+	// JSON encodes values as JSON, it is the default codec
+	JSON ICodec = jsonCodec{}
+	// Protobuf encodes proto.Message values with the protobuf wire format
+	Protobuf ICodec = protobufCodec{}
+	// Msgpack encodes values as MessagePack
+	Msgpack ICodec = msgpackCodec{}
+	// Raw passes []byte and string values through untouched
+	Raw ICodec = rawCodec{}
+)
+
+var (
+	muRegistry sync.RWMutex
+	registry   = map[string]ICodec{
+		JSON.ContentType():     JSON,
+		Protobuf.ContentType(): Protobuf,
+		Msgpack.ContentType():  Msgpack,
+		Raw.ContentType():      Raw,
+	}
+)
+
+// Register makes the codec available to Lookup and Decode under its content type,
+// it replaces a codec registered for the same content type
+func Register(c ICodec) {
+	muRegistry.Lock()
+	defer muRegistry.Unlock()
+	registry[c.ContentType()] = c
+}
+
+// Lookup returns the codec registered for the content type, messages stored without
+// a content type are decoded as JSON
+func Lookup(contentType string) (ICodec, error) {
+	if contentType == "" {
+		return JSON, nil
+	}
+
+	muRegistry.RLock()
+	defer muRegistry.RUnlock()
+	if c, ok := registry[contentType]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("no codec registered for content type %q", contentType)
+}
+
+// EncodePayload encodes the value with the codec into a payload that can be stored as text
+func EncodePayload(c ICodec, v any) (string, error) {
+	data, err := c.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	if c.Binary() {
+		return base64.StdEncoding.EncodeToString(data), nil
+	}
+	return string(data), nil
+}
+
+// DecodePayload decodes a payload stored by EncodePayload into the value pointed to by v
+func DecodePayload(c ICodec, payload string, v any) error {
+	data := []byte(payload)
+	if c.Binary() {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(payload); err != nil {
+			return err
+		}
+	}
+	return c.Unmarshal(data, v)
+}
+
+// Decode decodes the payload of the record with the codec of its content type
+func Decode[T any](record dto.Outbox) (T, error) {
+	var value T
+
+	c, err := Lookup(record.ContentType)
+	if err != nil {
+		return value, err
+	}
+
+	err = DecodePayload(c, record.Payload, &value)
+	return value, err
+}
@@ -0,0 +1,86 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/ghaninia/gbox/dto"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type order struct {
+	ID    int    `json:"id" msgpack:"id"`
+	Total string `json:"total" msgpack:"total"`
+}
+
+// TestCodecs_RoundTrip tests that every builtin codec decodes what it encoded.
+func TestCodecs_RoundTrip(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		payload, err := EncodePayload(JSON, order{ID: 1, Total: "9.90"})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":1,"total":"9.90"}`, payload)
+
+		decoded, err := Decode[order](dto.Outbox{Payload: payload})
+		assert.NoError(t, err)
+		assert.Equal(t, order{ID: 1, Total: "9.90"}, decoded)
+	})
+
+	t.Run("msgpack", func(t *testing.T) {
+		payload, err := EncodePayload(Msgpack, order{ID: 2, Total: "19.90"})
+		assert.NoError(t, err)
+
+		decoded, err := Decode[order](dto.Outbox{Payload: payload, ContentType: Msgpack.ContentType()})
+		assert.NoError(t, err)
+		assert.Equal(t, order{ID: 2, Total: "19.90"}, decoded)
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		payload, err := EncodePayload(Raw, []byte{0x00, 0xff, 0x10})
+		assert.NoError(t, err)
+
+		decoded, err := Decode[[]byte](dto.Outbox{Payload: payload, ContentType: Raw.ContentType()})
+		assert.NoError(t, err)
+		assert.Equal(t, []byte{0x00, 0xff, 0x10}, decoded)
+
+		_, err = EncodePayload(Raw, order{})
+		assert.Error(t, err)
+	})
+
+	t.Run("protobuf", func(t *testing.T) {
+		payload, err := EncodePayload(Protobuf, wrapperspb.String("customer.created"))
+		assert.NoError(t, err)
+
+		decoded, err := Decode[*wrapperspb.StringValue](dto.Outbox{Payload: payload, ContentType: Protobuf.ContentType()})
+		assert.NoError(t, err)
+		assert.Equal(t, "customer.created", decoded.GetValue())
+
+		_, err = EncodePayload(Protobuf, order{})
+		assert.Error(t, err)
+	})
+}
+
+// TestLookup tests resolving codecs by content type.
+func TestLookup(t *testing.T) {
+	c, err := Lookup("")
+	assert.NoError(t, err)
+	assert.Equal(t, JSON, c)
+
+	_, err = Lookup("application/xml")
+	assert.Error(t, err)
+
+	Register(xmlCodec{})
+	c, err = Lookup("application/xml")
+	assert.NoError(t, err)
+	assert.Equal(t, "application/xml", c.ContentType())
+}
+
+type xmlCodec struct{ rawCodec }
+
+func (xmlCodec) ContentType() string {
+	return "application/xml"
+}
+
+func (xmlCodec) Binary() bool {
+	return false
+}
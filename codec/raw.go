@@ -0,0 +1,35 @@
+package codec
+
+import "fmt"
+
+type rawCodec struct{}
+
+func (rawCodec) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (rawCodec) Binary() bool {
+	return true
+}
+
+func (rawCodec) Marshal(v any) ([]byte, error) {
+	switch value := v.(type) {
+	case []byte:
+		return value, nil
+	case string:
+		return []byte(value), nil
+	}
+	return nil, fmt.Errorf("raw codec cannot encode %T, only []byte and string are supported", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v any) error {
+	switch target := v.(type) {
+	case *[]byte:
+		*target = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*target = string(data)
+		return nil
+	}
+	return fmt.Errorf("raw codec cannot decode into %T, only *[]byte and *string are supported", v)
+}
@@ -1,7 +1,19 @@
 package constant
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
-	ErrProviderNotFound = errors.New("provider not found for the given driver name")
+	ErrProviderNotFound     = errors.New("provider not found for the given driver name")
+	ErrMessageNotFound      = errors.New("message not found for the given id")
+	ErrUnsupportedTx        = errors.New("transaction type is not supported by the repository")
+	ErrInvalidNodeID        = errors.New("node id must be between 0 and 1023")
+	ErrMigrationUnsupported = errors.New("repository does not support migrations")
+	ErrInvalidTableName     = errors.New("table name must be a lowercase identifier of letters, digits and underscores")
+
+	// ErrClaimLost is returned by an acknowledgement of a message this node no longer claims, such
+	// as a message reclaimed by the reaper and fetched by another node meanwhile
+	ErrClaimLost = fmt.Errorf("%w or not claimed by this node anymore", ErrMessageNotFound)
 )
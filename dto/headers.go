@@ -0,0 +1,57 @@
+package dto
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Headers are the metadata of a message, such as its content type or correlation id.
+// They are stored as a JSON object by the sql repositories.
+type Headers map[string]string
+
+// Value encodes the headers as a JSON object, empty headers are stored as NULL
+func (h Headers) Value() (driver.Value, error) {
+	if len(h) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(map[string]string(h))
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+// Scan decodes headers stored as a JSON object
+func (h *Headers) Scan(src any) error {
+	var encoded []byte
+	switch value := src.(type) {
+	case nil:
+		*h = nil
+		return nil
+	case string:
+		encoded = []byte(value)
+	case []byte:
+		encoded = value
+	default:
+		return fmt.Errorf("unsupported headers type %T", src)
+	}
+
+	if len(encoded) == 0 {
+		*h = nil
+		return nil
+	}
+	return json.Unmarshal(encoded, (*map[string]string)(h))
+}
+
+// Clone returns a copy of the headers that shares nothing with them
+func (h Headers) Clone() Headers {
+	if h == nil {
+		return nil
+	}
+	cloned := make(Headers, len(h))
+	for key, value := range h {
+		cloned[key] = value
+	}
+	return cloned
+}
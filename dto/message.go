@@ -1,30 +1,86 @@
 package dto
 
 import (
-	"encoding/json"
 	"time"
 )
 
 type NewMessage struct {
+	// Payload is stored as is, codec.EncodePayload or store.NewMessage encode Go values into it
 	Payload string `json:"payload"`
-}
-
-func (m NewMessage) ToString() string {
-	s, _ := json.Marshal(m)
-	return string(s)
+	// ContentType is the media type of the payload, it selects the codec decoding it
+	ContentType string `json:"content_type"`
+	// Key is the routing or partition key of the message
+	Key string `json:"key"`
+	// EventType names the event the message carries
+	EventType string `json:"event_type"`
+	// Headers are the metadata of the message, such as its correlation id
+	Headers Headers `json:"headers"`
+	// OrderingKey delivers the messages sharing it one at a time in the order they were added,
+	// messages without one are delivered in any order
+	OrderingKey string `json:"ordering_key"`
+	// DeduplicationKey drops the message when a stored message was added with the same key,
+	// such as the id of the request a producer retries
+	DeduplicationKey string `json:"deduplication_key"`
+	// DeliverAt holds the message back until the given time, the zero time delivers it right away
+	DeliverAt time.Time `json:"deliver_at"`
+	// Delay holds the message back for the given duration after it is added, DeliverAt wins
+	// when both are set
+	Delay time.Duration `json:"delay"`
+	// ExpiresAt drops the message instead of delivering it once the given time has passed,
+	// the zero time never expires it
+	ExpiresAt time.Time `json:"expires_at"`
+	// TTL expires the message the given duration after it is added, ExpiresAt wins when
+	// both are set
+	TTL time.Duration `json:"ttl"`
+	// Priority delivers the message ahead of the messages of a lower priority, zero by default.
+	// Messages waiting longer than the starvation timeout of the repository are delivered first.
+	Priority int `json:"priority"`
 }
 
 func (m NewMessage) ToOutBox(ID int64, driverName string) Outbox {
+	var deduplicationKey *string
+	if m.DeduplicationKey != "" {
+		deduplicationKey = &m.DeduplicationKey
+	}
+
+	createdAt := time.Now()
+	deliverAt := m.DeliverAt
+	if deliverAt.IsZero() && m.Delay > 0 {
+		deliverAt = createdAt.Add(m.Delay)
+	}
+	var nextAttemptAt *time.Time
+	if deliverAt.After(createdAt) {
+		nextAttemptAt = &deliverAt
+	}
+
+	expiresAt := m.ExpiresAt
+	if expiresAt.IsZero() && m.TTL > 0 {
+		expiresAt = createdAt.Add(m.TTL)
+	}
+	var expiresAtPtr *time.Time
+	if !expiresAt.IsZero() {
+		expiresAtPtr = &expiresAt
+	}
+
 	return Outbox{
 		ID:               ID,
 		DriverName:       driverName,
-		Payload:          m.ToString(),
+		Payload:          m.Payload,
 		State:            OutboxStatePending,
-		CreatedAt:        time.Now(),
+		CreatedAt:        createdAt,
 		LockedAt:         nil,
 		LockedBy:         nil,
 		LastAttemptedAt:  nil,
 		NumberOfAttempts: nil,
 		Error:            nil,
+		NextAttemptAt:    nextAttemptAt,
+		Key:              m.Key,
+		EventType:        m.EventType,
+		Headers:          m.Headers.Clone(),
+		ContentType:      m.ContentType,
+		OrderingKey:      m.OrderingKey,
+		DeduplicationKey: deduplicationKey,
+		ExpiresAt:        expiresAtPtr,
+		Priority:         m.Priority,
 	}
 }
@@ -13,7 +13,44 @@ type Outbox struct {
 	LastAttemptedAt  *time.Time      `gorm:"last_attempted_at" db:"last_attempted_at" json:"last_attempted_at"`
 	NumberOfAttempts *int64          `gorm:"number_of_attempts" db:"number_of_attempts" json:"number_of_attempts"`
 	Error            *string         `gorm:"error" db:"error" json:"error"`
+	// NextAttemptAt holds the record back until the time it is due, the delivery time of a
+	// scheduled message before its first attempt and the time of the next retry after it
+	NextAttemptAt *time.Time `gorm:"next_attempt_at" db:"next_attempt_at" json:"next_attempt_at"`
+	// Key is the routing or partition key of the message
+	Key string `gorm:"column:message_key" db:"message_key" json:"key"`
+	// EventType names the event the message carries
+	EventType string `gorm:"event_type" db:"event_type" json:"event_type"`
+	// Headers are the metadata of the message
+	Headers Headers `gorm:"column:headers;type:text" db:"headers" json:"headers"`
+	// ContentType is the media type of the payload, it selects the codec decoding it
+	ContentType string `gorm:"content_type" db:"content_type" json:"content_type"`
+	// OrderingKey serializes delivery, a message is not fetched while an earlier message
+	// with the same key is pending or in progress
+	OrderingKey string `gorm:"ordering_key" db:"ordering_key" json:"ordering_key"`
+	// DeduplicationKey is unique among the stored messages, a message carrying the key
+	// of a stored message is dropped
+	DeduplicationKey *string `gorm:"deduplication_key" db:"deduplication_key" json:"deduplication_key"`
+	// ExpiresAt is the time after which the message is no longer worth delivering,
+	// it never expires when nil
+	ExpiresAt *time.Time `gorm:"expires_at" db:"expires_at" json:"expires_at"`
+	// Priority orders the delivery, messages of a higher priority are fetched first
+	Priority int `gorm:"priority" db:"priority" json:"priority"`
 }
+
+// Expired reports whether the message expired at the given time
+func (o Outbox) Expired(now time.Time) bool {
+	return o.ExpiresAt != nil && !now.Before(*o.ExpiresAt)
+}
+
+// FinishedAt returns the time the message reached its state, the time of its last attempt
+// or its creation when it was never attempted
+func (o Outbox) FinishedAt() time.Time {
+	if o.LastAttemptedAt != nil {
+		return *o.LastAttemptedAt
+	}
+	return o.CreatedAt
+}
+
 type OutboxStateEnum string
 
 const (
@@ -21,4 +58,31 @@ const (
 	OutboxStateInProgress OutboxStateEnum = "IN_PROGRESS"
 	OutboxStateSucceed    OutboxStateEnum = "SUCCEED"
 	OutboxStateFailed     OutboxStateEnum = "FAILED"
+	// OutboxStateDeadLettered is the terminal state of messages that exhausted their retries
+	OutboxStateDeadLettered OutboxStateEnum = "DEAD_LETTERED"
+	// OutboxStateExpired is the terminal state of messages that expired before they were delivered
+	OutboxStateExpired OutboxStateEnum = "EXPIRED"
+	// OutboxStateCanceled is the terminal state of pending messages canceled by an operator
+	OutboxStateCanceled OutboxStateEnum = "CANCELED"
 )
+
+// FinishedStates returns the states a message is never delivered from again
+func FinishedStates() []OutboxStateEnum {
+	return []OutboxStateEnum{
+		OutboxStateSucceed,
+		OutboxStateFailed,
+		OutboxStateDeadLettered,
+		OutboxStateExpired,
+		OutboxStateCanceled,
+	}
+}
+
+// Finished reports whether the state is one of the finished states
+func (s OutboxStateEnum) Finished() bool {
+	return containsState(FinishedStates(), s)
+}
+
+// Valid reports whether the state is one of the states of a message
+func (s OutboxStateEnum) Valid() bool {
+	return s == OutboxStatePending || s == OutboxStateInProgress || s.Finished()
+}
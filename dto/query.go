@@ -0,0 +1,61 @@
+package dto
+
+// MessageFilter selects the messages listed by the admin queries of the store
+type MessageFilter struct {
+	// IDs keeps the messages of the ids, every message when empty
+	IDs []int64
+	// States keeps the messages in one of the states, every state when empty
+	States []OutboxStateEnum
+	// DriverName keeps the messages of the driver, every driver when empty
+	DriverName string
+	// AfterID keeps the messages with a greater id, so that the messages added since a listing
+	// are listed without an offset
+	AfterID int64
+	// Limit bounds the messages listed in id order, Offset skips the first ones
+	Limit  int
+	Offset int
+}
+
+// Match reports whether the message is selected by the filter, limit and offset aside
+func (f MessageFilter) Match(message Outbox) bool {
+	if f.DriverName != "" && message.DriverName != f.DriverName {
+		return false
+	}
+	if len(f.States) > 0 && !containsState(f.States, message.State) {
+		return false
+	}
+	if message.ID <= f.AfterID {
+		return false
+	}
+	if len(f.IDs) > 0 && !containsID(f.IDs, message.ID) {
+		return false
+	}
+	return true
+}
+
+// MessageStats counts the messages of a driver in a state
+type MessageStats struct {
+	DriverName string          `db:"driver_name" json:"driver_name"`
+	State      OutboxStateEnum `db:"state" json:"state"`
+	Count      int64           `db:"count" json:"count"`
+}
+
+// containsState reports whether the state is one of the states
+func containsState(states []OutboxStateEnum, state OutboxStateEnum) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// containsID reports whether the id is one of the ids
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/ghaninia/gbox/poller"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultNamespace prefixes the metric names of a configuration without a namespace
+	defaultNamespace = "gbox"
+	// fetchSucceeded and fetchFailed are the result labels of the fetches
+	fetchSucceeded = "ok"
+	fetchFailed    = "error"
+)
+
+var (
+	_ store.IMetrics       = (*Collector)(nil)
+	_ poller.IMetrics      = (*Collector)(nil)
+	_ prometheus.Collector = (*Collector)(nil)
+)
+
+// Config defines the configuration of the prometheus collector.
+type Config struct {
+	// Namespace prefixes the metric names, "gbox" when empty.
+	Namespace string
+	// ConstLabels are added to every metric, such as the name of the service.
+	ConstLabels prometheus.Labels
+	// Buckets of the duration histograms in seconds, prometheus.DefBuckets when empty.
+	Buckets []float64
+}
+
+// Collector records the metrics of the store and the poller. It is a prometheus.Collector
+// registered with the registry of the caller, set it as store.Setting.Metrics and
+// poller.WorkerConfig.Metrics.
+type Collector struct {
+	messagesAdded     *prometheus.CounterVec
+	batchesSaved      prometheus.Counter
+	messagesSaved     prometheus.Counter
+	batchSaveDuration prometheus.Histogram
+	saveRetries       prometheus.Counter
+	saveFailures      prometheus.Counter
+	bufferSize        prometheus.Gauge
+
+	fetches         *prometheus.CounterVec
+	fetchedMessages prometheus.Counter
+	fetchDuration   prometheus.Histogram
+	deliveries      *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+	pendingAge      prometheus.Gauge
+}
+
+// NewCollector creates the metrics of the store and the poller, nothing is registered.
+func NewCollector(cfg Config) *Collector {
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultNamespace
+	}
+	if len(cfg.Buckets) == 0 {
+		cfg.Buckets = prometheus.DefBuckets
+	}
+
+	opts := func(subsystem, name, help string) prometheus.Opts {
+		return prometheus.Opts{
+			Namespace:   cfg.Namespace,
+			Subsystem:   subsystem,
+			Name:        name,
+			Help:        help,
+			ConstLabels: cfg.ConstLabels,
+		}
+	}
+	histogramOpts := func(subsystem, name, help string) prometheus.HistogramOpts {
+		o := opts(subsystem, name, help)
+		return prometheus.HistogramOpts{
+			Namespace:   o.Namespace,
+			Subsystem:   o.Subsystem,
+			Name:        o.Name,
+			Help:        o.Help,
+			ConstLabels: o.ConstLabels,
+			Buckets:     cfg.Buckets,
+		}
+	}
+
+	return &Collector{
+		messagesAdded: prometheus.NewCounterVec(prometheus.CounterOpts(opts("store", "messages_added_total",
+			"Messages accepted or buffered by the store.")), []string{"driver"}),
+		batchesSaved: prometheus.NewCounter(prometheus.CounterOpts(opts("store", "batches_saved_total",
+			"Batches of messages written to the repository."))),
+		messagesSaved: prometheus.NewCounter(prometheus.CounterOpts(opts("store", "messages_saved_total",
+			"Messages of the batches written to the repository."))),
+		batchSaveDuration: prometheus.NewHistogram(histogramOpts("store", "batch_save_duration_seconds",
+			"Time taken to write a batch to the repository, retries included.")),
+		saveRetries: prometheus.NewCounter(prometheus.CounterOpts(opts("store", "save_retries_total",
+			"Retries of batch writes that failed."))),
+		saveFailures: prometheus.NewCounter(prometheus.CounterOpts(opts("store", "save_failures_total",
+			"Batches that could not be written to the repository."))),
+		bufferSize: prometheus.NewGauge(prometheus.GaugeOpts(opts("store", "buffer_size",
+			"Messages waiting for the next batch insert."))),
+
+		fetches: prometheus.NewCounterVec(prometheus.CounterOpts(opts("poller", "fetches_total",
+			"Fetches of the store by result.")), []string{"result"}),
+		fetchedMessages: prometheus.NewCounter(prometheus.CounterOpts(opts("poller", "fetched_messages_total",
+			"Messages claimed by the fetches."))),
+		fetchDuration: prometheus.NewHistogram(histogramOpts("poller", "fetch_duration_seconds",
+			"Time taken to fetch a batch of messages.")),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts(opts("poller", "deliveries_total",
+			"Delivery attempts by driver and outcome.")), []string{"driver", "outcome"}),
+		handlerDuration: prometheus.NewHistogramVec(histogramOpts("poller", "handler_duration_seconds",
+			"Time taken by the provider to handle a message."), []string{"driver"}),
+		pendingAge: prometheus.NewGauge(prometheus.GaugeOpts(opts("poller", "pending_age_seconds",
+			"How long the oldest message of the last fetch waited since it was due."))),
+	}
+}
+
+// collectors returns every metric of the collector
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.messagesAdded,
+		c.batchesSaved,
+		c.messagesSaved,
+		c.batchSaveDuration,
+		c.saveRetries,
+		c.saveFailures,
+		c.bufferSize,
+		c.fetches,
+		c.fetchedMessages,
+		c.fetchDuration,
+		c.deliveries,
+		c.handlerDuration,
+		c.pendingAge,
+	}
+}
+
+// Describe sends the descriptors of every metric to the channel
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect sends the current value of every metric to the channel
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+// MessagesAdded counts the messages of the driver accepted or buffered by the store
+func (c *Collector) MessagesAdded(driverName string, count int) {
+	c.messagesAdded.WithLabelValues(driverName).Add(float64(count))
+}
+
+// BatchSaved observes a batch written to the repository
+func (c *Collector) BatchSaved(size int, duration time.Duration) {
+	c.batchesSaved.Inc()
+	c.messagesSaved.Add(float64(size))
+	c.batchSaveDuration.Observe(duration.Seconds())
+}
+
+// SaveRetried counts a retry of a failed batch write
+func (c *Collector) SaveRetried() {
+	c.saveRetries.Inc()
+}
+
+// SaveFailed counts a batch that could not be written
+func (c *Collector) SaveFailed() {
+	c.saveFailures.Inc()
+}
+
+// BufferSize sets the messages waiting for the next batch insert
+func (c *Collector) BufferSize(size int) {
+	c.bufferSize.Set(float64(size))
+}
+
+// Fetched observes a fetch of the store
+func (c *Collector) Fetched(count int, duration time.Duration, err error) {
+	if err != nil {
+		c.fetches.WithLabelValues(fetchFailed).Inc()
+		return
+	}
+	c.fetches.WithLabelValues(fetchSucceeded).Inc()
+	c.fetchedMessages.Add(float64(count))
+	c.fetchDuration.Observe(duration.Seconds())
+}
+
+// Delivered counts a message of the driver that ended with the outcome
+func (c *Collector) Delivered(driverName string, outcome poller.Outcome) {
+	c.deliveries.WithLabelValues(driverName, string(outcome)).Inc()
+}
+
+// Handled observes how long the provider of the driver took to handle a message
+func (c *Collector) Handled(driverName string, duration time.Duration) {
+	c.handlerDuration.WithLabelValues(driverName).Observe(duration.Seconds())
+}
+
+// PendingAge sets how long the oldest message of the last fetch waited since it was due
+func (c *Collector) PendingAge(age time.Duration) {
+	c.pendingAge.Set(age.Seconds())
+}
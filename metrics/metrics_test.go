@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/poller"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyProvider fails the first attempt of every message
+type flakyProvider struct {
+	sync.Mutex
+	attempts map[int64]int
+}
+
+func (p *flakyProvider) DriverName() string {
+	return "grpc"
+}
+
+func (p *flakyProvider) Handle(_ context.Context, record dto.Outbox) error {
+	p.Lock()
+	defer p.Unlock()
+	p.attempts[record.ID]++
+	if p.attempts[record.ID] == 1 {
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func TestCollector_RecordsStoreAndPoller(t *testing.T) {
+	var (
+		ctx       = context.Background()
+		registry  = prometheus.NewRegistry()
+		collector = NewCollector(Config{ConstLabels: prometheus.Labels{"service": "orders"}})
+	)
+	assert.NoError(t, registry.Register(collector))
+
+	s, err := store.NewStore(store.NewOutboxMemoryRepository(store.RepoSetting{TableName: "outbox"}), store.Setting{
+		NodeID:             1,
+		BatchInsertEnabled: true,
+		MaxBatchSize:       2,
+		Metrics:            collector,
+	})
+	assert.NoError(t, err)
+
+	_, err = store.AddValues(ctx, s, "grpc", "first", "second", "buffered")
+	assert.NoError(t, err)
+
+	assert.Equal(t, float64(3), testutil.ToFloat64(collector.messagesAdded.WithLabelValues("grpc")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.batchesSaved))
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector.messagesSaved))
+	assert.Equal(t, float64(1), testutil.ToFloat64(collector.bufferSize))
+
+	pool := poller.NewWorkerPool(poller.NewProviders().AddProvider(&flakyProvider{attempts: make(map[int64]int)}), s, poller.WorkerPoolConfig{
+		CountOfWorkers: 1,
+		Worker: poller.WorkerConfig{
+			BatchSizeProcessing: 10,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: time.Millisecond,
+			Retry:               poller.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 1},
+			Metrics:             collector,
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	succeeded := collector.deliveries.WithLabelValues("grpc", string(poller.OutcomeSucceeded))
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(succeeded) == 2
+	}, 5*time.Second, time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(collector.deliveries.WithLabelValues("grpc", string(poller.OutcomeRetried))))
+	assert.Equal(t, float64(4), testutil.ToFloat64(collector.fetchedMessages))
+	assert.Positive(t, testutil.ToFloat64(collector.fetches.WithLabelValues(fetchSucceeded)))
+
+	count, err := testutil.GatherAndCount(registry, "gbox_poller_handler_duration_seconds", "gbox_store_batch_save_duration_seconds")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
@@ -0,0 +1,164 @@
+package poller
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+)
+
+const (
+	// defaultCleanupInterval is the interval of a cleaner configuration left zero
+	defaultCleanupInterval = time.Hour
+	// defaultRetention is the retention of a cleaner configuration left zero
+	defaultRetention = 7 * 24 * time.Hour
+	// defaultCleanupBatchSize is the batch size of a cleaner configuration left zero
+	defaultCleanupBatchSize = 500
+)
+
+type ICleaner interface {
+	Start(ctx context.Context) error
+	Clean(ctx context.Context) (int64, error)
+	Stop()
+}
+
+// CleanerConfig defines the configuration of the retention cleaner.
+type CleanerConfig struct {
+	// Interval between two cleanups, the cleaner is disabled in a worker pool when zero and
+	// cleans up every hour when started on its own.
+	Interval time.Duration
+	// Retention is how long a finished message is kept after its last attempt, a week when zero.
+	Retention time.Duration
+	// States are the finished states that are cleaned up, succeeded messages only when empty.
+	States []dto.OutboxStateEnum
+	// BatchSize bounds the messages deleted at once, so a cleanup never holds long locks. 500 when zero.
+	BatchSize int
+	// Archive receives every batch before it is deleted, the batch is kept when it fails.
+	Archive func(ctx context.Context, messages []dto.Outbox) error
+	// Logger receives the events of the cleaner, the default slog logger is used when nil.
+	Logger *slog.Logger
+}
+
+type cleaner struct {
+	// DI attributes
+	store store.IStore
+
+	// inside cleaner attributes
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// config cleaner attributes
+	cfg    CleanerConfig
+	logger *slog.Logger
+}
+
+// NewCleaner creates a cleaner that periodically deletes, or archives and deletes, the messages
+// finished longer than the retention ago.
+func NewCleaner(store store.IStore, cfg CleanerConfig) ICleaner {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultCleanupInterval
+	}
+	if cfg.Retention <= 0 {
+		cfg.Retention = defaultRetention
+	}
+	if len(cfg.States) == 0 {
+		cfg.States = []dto.OutboxStateEnum{dto.OutboxStateSucceed}
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultCleanupBatchSize
+	}
+
+	return &cleaner{
+		store:  store,
+		stop:   make(chan struct{}),
+		cfg:    cfg,
+		logger: loggerOrDefault(cfg.Logger).With(logKeyComponent, "cleaner"),
+	}
+}
+
+// Start cleans up finished messages every interval until the context is canceled or Stop is called.
+func (c *cleaner) Start(ctx context.Context) error {
+	c.logger.InfoContext(ctx, "cleaner started")
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.InfoContext(ctx, "context canceled, stopping")
+			return nil
+		case <-c.stop:
+			c.logger.InfoContext(ctx, "cleaner stopped")
+			return nil
+		case <-ticker.C:
+			if _, err := c.Clean(ctx); err != nil {
+				c.logger.ErrorContext(ctx, "failed to clean finished messages", "error", err)
+			}
+		}
+	}
+}
+
+// Clean deletes the messages finished longer than the retention ago batch by batch until none
+// is left, handing every batch to the archive first when one is configured. It reports how many
+// messages were deleted.
+func (c *cleaner) Clean(ctx context.Context) (int64, error) {
+	var deleted int64
+	for {
+		messages, err := c.store.FinishedMessages(ctx, c.cfg.States, c.cfg.Retention, c.cfg.BatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		if len(messages) == 0 {
+			break
+		}
+
+		if c.cfg.Archive != nil {
+			if err := c.cfg.Archive(ctx, messages); err != nil {
+				return deleted, err
+			}
+		}
+
+		ids := make([]int64, 0, len(messages))
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+
+		count, err := c.store.DeleteMessages(ctx, c.cfg.States, ids...)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += count
+
+		if len(messages) < c.cfg.BatchSize || c.stopped(ctx) {
+			break
+		}
+	}
+
+	if deleted > 0 {
+		c.logger.InfoContext(ctx, "deleted finished messages", "count", deleted)
+	}
+
+	return deleted, nil
+}
+
+// stopped reports whether the cleaner was stopped or its context canceled between two batches
+func (c *cleaner) stopped(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return true
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *cleaner) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+}
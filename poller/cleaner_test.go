@@ -0,0 +1,90 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleaner_ArchivesAndDeletes(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.IStore{
+		"sqlite": newSqliteStore,
+		"memory": newMemoryStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testCleanerArchivesAndDeletes(t, newStore(t))
+		})
+	}
+}
+
+func TestNewCleaner_Defaults(t *testing.T) {
+	c := NewCleaner(newMemoryStore(t), CleanerConfig{}).(*cleaner)
+
+	assert.Equal(t, defaultCleanupInterval, c.cfg.Interval)
+	assert.Equal(t, defaultRetention, c.cfg.Retention)
+	assert.Equal(t, defaultCleanupBatchSize, c.cfg.BatchSize)
+	assert.Equal(t, []dto.OutboxStateEnum{dto.OutboxStateSucceed}, c.cfg.States)
+}
+
+func testCleanerArchivesAndDeletes(t *testing.T, s store.IStore) {
+	var (
+		ctx      = context.Background()
+		archived []string
+		failing  = true
+	)
+
+	_, err := store.AddValues(ctx, s, "grpc", "first", "second", "third")
+	assert.NoError(t, err)
+
+	claimed, err := s.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	for _, message := range claimed {
+		assert.NoError(t, s.MarkAsProcessed(ctx, message.ID))
+	}
+
+	_, err = store.AddValues(ctx, s, "grpc", "pending")
+	assert.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	cleaner := NewCleaner(s, CleanerConfig{
+		Retention: 10 * time.Millisecond,
+		BatchSize: 2,
+		Archive: func(_ context.Context, messages []dto.Outbox) error {
+			if failing {
+				return errors.New("archive unavailable")
+			}
+			for _, message := range messages {
+				archived = append(archived, message.Payload)
+			}
+			return nil
+		},
+	})
+
+	// a batch that could not be archived is kept
+	deleted, err := cleaner.Clean(ctx)
+	assert.Error(t, err)
+	assert.Zero(t, deleted)
+
+	failing = false
+	deleted, err = cleaner.Clean(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	assert.ElementsMatch(t, []string{`"first"`, `"second"`, `"third"`}, archived)
+
+	finished, err := s.FinishedMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateSucceed}, 0, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, finished)
+
+	claimed, err = s.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, `"pending"`, claimed[0].Payload)
+	}
+}
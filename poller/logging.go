@@ -0,0 +1,41 @@
+package poller
+
+import (
+	"log/slog"
+
+	"github.com/ghaninia/gbox/dto"
+)
+
+// Keys of the structured fields logged by the poller
+const (
+	logKeyComponent  = "component"
+	logKeyWorkerID   = "worker_id"
+	logKeyMessageID  = "message_id"
+	logKeyDriverName = "driver_name"
+	logKeyAttempt    = "attempt"
+)
+
+// loggerOrDefault returns the logger, or the default slog logger writing to stderr when nil.
+func loggerOrDefault(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
+
+// attemptOf returns the delivery attempt of the message being handled, starting at one.
+func attemptOf(msg dto.Outbox) int64 {
+	if msg.NumberOfAttempts == nil {
+		return 1
+	}
+	return *msg.NumberOfAttempts + 1
+}
+
+// messageLogger returns the logger of the worker with the fields of the message attempt.
+func (w *worker) messageLogger(msg dto.Outbox) *slog.Logger {
+	return w.logger.With(
+		logKeyMessageID, msg.ID,
+		logKeyDriverName, msg.DriverName,
+		logKeyAttempt, attemptOf(msg),
+	)
+}
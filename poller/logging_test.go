@@ -0,0 +1,95 @@
+package poller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of the workers
+type syncBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+// records decodes the json lines written to the buffer
+func (b *syncBuffer) records(t *testing.T) []map[string]any {
+	b.Lock()
+	defer b.Unlock()
+
+	var records []map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for decoder.More() {
+		record := map[string]any{}
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf("failed to decode the log record: %v", err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestWorkerPool_LogsStructuredFields(t *testing.T) {
+	var (
+		ctx      = context.Background()
+		output   = &syncBuffer{}
+		s        = newMemoryStore(t)
+		provider = &recordingProvider{failures: map[string]int{"flaky": 1}}
+	)
+
+	result, err := store.AddValues(ctx, s, "grpc", "flaky")
+	assert.NoError(t, err)
+
+	pool := NewWorkerPool(NewProviders().AddProvider(provider), s, WorkerPoolConfig{
+		CountOfWorkers: 1,
+		Worker: WorkerConfig{
+			BatchSizeProcessing: 10,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: 10 * time.Millisecond,
+			Retry:               RetryPolicy{MaxAttempts: 3, BaseDelay: 10 * time.Millisecond, Multiplier: 1},
+		},
+		Logger: slog.New(slog.NewJSONHandler(output, nil)),
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return provider.count() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	var failed map[string]any
+	for _, record := range output.records(t) {
+		if record["msg"] == "failed to process message" {
+			failed = record
+		}
+	}
+	if assert.NotNil(t, failed) && assert.Len(t, result.Accepted, 1) {
+		assert.Equal(t, "WARN", failed["level"])
+		assert.Equal(t, "worker", failed["component"])
+		assert.Equal(t, float64(0), failed["worker_id"])
+		assert.Equal(t, float64(result.Accepted[0].ID), failed["message_id"])
+		assert.Equal(t, "grpc", failed["driver_name"])
+		assert.Equal(t, float64(1), failed["attempt"])
+		assert.Equal(t, "broker unavailable", failed["error"])
+	}
+}
@@ -2,13 +2,26 @@ package poller
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 
 	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 	"github.com/ghaninia/gbox/store"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// defaultBatchSizeProcessing is the batch size of a worker configuration left zero
+	defaultBatchSizeProcessing = 100
+	// defaultTimeoutPerMessage is the delivery timeout of a worker configuration left zero
+	defaultTimeoutPerMessage = 5 * time.Second
+	// defaultDelayWhenNoMessages is the idle delay of a worker configuration left zero
+	defaultDelayWhenNoMessages = time.Second
 )
 
 type IWorker interface {
@@ -18,11 +31,58 @@ type IWorker interface {
 
 // WorkerConfig defines the configuration for each worker.
 type WorkerConfig struct {
-	BatchSizeProcessing int           `default:"100"`
-	TimeoutPerMessage   time.Duration `default:"5s"`
-	DelayWhenNoMessages time.Duration `default:"1s"`
+	// BatchSizeProcessing bounds the messages claimed by a fetch, 100 when zero.
+	BatchSizeProcessing int
+	// TimeoutPerMessage bounds the delivery of a message, 5s when zero.
+	TimeoutPerMessage time.Duration
+	// DelayWhenNoMessages is how long the worker waits after a fetch without messages, 1s when zero.
+	DelayWhenNoMessages time.Duration
+	// Retry schedules the attempts of the failed messages, DefaultRetryPolicy when zero. A MaxAttempts
+	// of 1 dead-letters a message on its first failure.
+	Retry RetryPolicy
+	// OnExpired is called with the messages of a batch that expired instead of being delivered,
+	// it must not block the worker.
+	OnExpired func(ctx context.Context, messages []dto.Outbox)
+	// Metrics observes the fetches and deliveries of the worker, nothing is recorded when nil.
+	Metrics IMetrics
+	// TracerProvider creates the consumer span around every delivery, the global provider is
+	// used when nil.
+	TracerProvider trace.TracerProvider
+	// Propagator reads the trace context of Add from the message headers, the global propagator
+	// is used when nil.
+	Propagator propagation.TextMapPropagator
+}
+
+// Outcome is how a delivery attempt of a message ended.
+type Outcome string
+
+const (
+	OutcomeSucceeded    Outcome = "succeeded"
+	OutcomeRetried      Outcome = "retried"
+	OutcomeDeadLettered Outcome = "dead_lettered"
+	OutcomeExpired      Outcome = "expired"
+)
+
+// IMetrics observes the workers, the metrics package implements it with prometheus.
+type IMetrics interface {
+	// Fetched observes a fetch of the store, the messages it claimed, how long it took and its error.
+	Fetched(count int, duration time.Duration, err error)
+	// Delivered counts a message of the driver that ended with the outcome.
+	Delivered(driverName string, outcome Outcome)
+	// Handled observes how long the provider of the driver took to handle a message.
+	Handled(driverName string, duration time.Duration)
+	// PendingAge reports how long the oldest message of the last fetch waited since it was due.
+	PendingAge(age time.Duration)
 }
 
+// noopMetrics is the IMetrics of a worker without metrics.
+type noopMetrics struct{}
+
+func (noopMetrics) Fetched(int, time.Duration, error) {}
+func (noopMetrics) Delivered(string, Outcome)         {}
+func (noopMetrics) Handled(string, time.Duration)     {}
+func (noopMetrics) PendingAge(time.Duration)          {}
+
 type worker struct {
 	// DI attributes
 	providers IProviders
@@ -36,7 +96,11 @@ type worker struct {
 	workerID           int
 
 	// config worker attributes
-	cfg WorkerConfig
+	cfg        WorkerConfig
+	logger     *slog.Logger
+	metrics    IMetrics
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
 }
 
 func newWorker(
@@ -44,27 +108,58 @@ func newWorker(
 	store store.IStore,
 	workerID int,
 	cfg WorkerConfig,
+	logger *slog.Logger,
 ) IWorker {
+	if cfg.BatchSizeProcessing <= 0 {
+		cfg.BatchSizeProcessing = defaultBatchSizeProcessing
+	}
+	if cfg.TimeoutPerMessage <= 0 {
+		cfg.TimeoutPerMessage = defaultTimeoutPerMessage
+	}
+	if cfg.DelayWhenNoMessages <= 0 {
+		cfg.DelayWhenNoMessages = defaultDelayWhenNoMessages
+	}
+	if cfg.Retry == (RetryPolicy{}) {
+		cfg.Retry = DefaultRetryPolicy
+	}
+
+	metrics := cfg.Metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	tracerProvider := cfg.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	propagator := cfg.Propagator
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+
 	return &worker{
-		providers: providers,
-		store:     store,
-		workerID:  workerID,
-		cfg:       cfg,
+		providers:  providers,
+		store:      store,
+		workerID:   workerID,
+		cfg:        cfg,
+		logger:     loggerOrDefault(logger).With(logKeyComponent, "worker", logKeyWorkerID, workerID),
+		metrics:    metrics,
+		tracer:     tracerProvider.Tracer(tracerName),
+		propagator: propagator,
 	}
 }
 
 func (w *worker) Start(ctx context.Context) error {
-	log.Printf("[Worker %d] started", w.workerID)
+	w.logger.InfoContext(ctx, "worker started")
 
 	for {
 		// Check if worker is stopped gracefully or has been requested to stop
 		// This is to ensure that the worker can exit cleanly when no messages are left to process.
-		if w.gracefulStop {
+		if w.stopping() {
 			w.Lock()
 			noWork := len(w.inProgressMessages) == 0
 			w.Unlock()
 			if noWork {
-				log.Printf("[Worker %d] graceful stop completed", w.workerID)
+				w.logger.InfoContext(ctx, "graceful stop completed")
 				w.stopped = true
 				return nil
 			}
@@ -72,19 +167,23 @@ func (w *worker) Start(ctx context.Context) error {
 
 		select {
 		case <-ctx.Done():
-			log.Printf("[Worker %d] context canceled, stopping immediately", w.workerID)
+			w.logger.InfoContext(ctx, "context canceled, stopping immediately")
 			w.stopped = true
 			return nil
 		default:
 
 			// Fetch a batch of messages
+			fetchedAt := time.Now()
 			messages, err := w.store.FetchMessages(ctx, w.cfg.BatchSizeProcessing)
+			w.metrics.Fetched(len(messages), time.Since(fetchedAt), err)
 			if err != nil {
-				log.Printf("[Worker %d] fetch error: %v", w.workerID, err)
+				w.logger.ErrorContext(ctx, "failed to fetch messages", "error", err)
 				time.Sleep(w.cfg.DelayWhenNoMessages)
 				continue
 			}
 
+			w.metrics.PendingAge(pendingAge(messages, fetchedAt))
+
 			// If no messages are fetched, wait for a while before retrying
 			// This helps to avoid busy-waiting and allows other workers to process messages.
 			if len(messages) == 0 {
@@ -98,44 +197,147 @@ func (w *worker) Start(ctx context.Context) error {
 			w.Unlock()
 
 			// Process messages
+			var expired []dto.Outbox
 			for _, msg := range messages {
 
 				// Check if worker should stop gracefully
-				if w.gracefulStop && ctx.Err() == nil {
+				if w.stopping() && ctx.Err() == nil {
 					break
 				}
 
+				// Expired messages are not worth delivering anymore
+				if msg.Expired(time.Now()) {
+					if w.expireMessage(ctx, msg) {
+						expired = append(expired, msg)
+					}
+					w.done(msg.ID)
+					continue
+				}
+
 				// Timeout per message processing
 				msgCtx, cancel := context.WithTimeout(ctx, w.cfg.TimeoutPerMessage)
+				msgCtx, span := w.startSpan(msgCtx, msg)
+				handledAt := time.Now()
 				err := w.processMessage(msgCtx, msg)
+				w.metrics.Handled(msg.DriverName, time.Since(handledAt))
+				endSpan(span, err)
 				cancel()
 
 				if err != nil {
-					log.Printf("[Worker %d] failed to process msg %s: %v", w.workerID, msg.ID, err)
-					continue
-				}
-
-				// Acknowledge the message as processed
-				if err := w.store.MarkAsProcessed(ctx, msg.ID); err != nil {
-					log.Printf("[Worker %d] failed to ack msg %s: %v", w.workerID, msg.ID, err)
+					w.messageLogger(msg).WarnContext(ctx, "failed to process message", "error", err)
+					if w.failMessage(ctx, msg, err) {
+						expired = append(expired, msg)
+					}
+				} else {
+					// Acknowledge the message as processed
+					if err := w.store.MarkAsProcessed(ctx, msg.ID); err != nil {
+						w.messageLogger(msg).ErrorContext(ctx, "failed to acknowledge message", "error", err)
+					} else {
+						w.metrics.Delivered(msg.DriverName, OutcomeSucceeded)
+					}
 				}
 
 				// Remove from in-progress list
-				w.Lock()
-				for i, inProg := range w.inProgressMessages {
-					if inProg.ID == msg.ID {
-						w.inProgressMessages = append(w.inProgressMessages[:i], w.inProgressMessages[i+1:]...)
-						break
-					}
-				}
-				w.Unlock()
+				w.done(msg.ID)
 			}
+			w.reportExpired(ctx, expired)
+
+			// Hand the messages left unprocessed by a graceful stop back to the store,
+			// so that they can be claimed again instead of staying locked.
+			w.releaseInProgress(ctx)
+		}
+	}
+}
+
+// failMessage records the failed attempt, the message is scheduled for another attempt
+// by the retry policy or moved to the dead letters once the policy is exhausted. A message
+// that would expire before its next attempt is expired instead, failMessage reports it.
+func (w *worker) failMessage(ctx context.Context, msg dto.Outbox, cause error) bool {
+	attempt := attemptOf(msg)
+	if w.cfg.Retry.Exhausted(attempt) {
+		if err := w.store.MarkAsDeadLettered(ctx, msg.ID, cause); err != nil {
+			w.messageLogger(msg).ErrorContext(ctx, "failed to dead-letter message", "error", err)
+		} else {
+			w.metrics.Delivered(msg.DriverName, OutcomeDeadLettered)
+		}
+		return false
+	}
+
+	nextAttemptAt := w.cfg.Retry.NextAttemptAt(attempt)
+	if msg.Expired(nextAttemptAt) {
+		return w.expireMessage(ctx, msg)
+	}
+	if err := w.store.MarkAsRetry(ctx, msg.ID, cause, nextAttemptAt); err != nil {
+		w.messageLogger(msg).ErrorContext(ctx, "failed to schedule message retry", "error", err)
+	} else {
+		w.metrics.Delivered(msg.DriverName, OutcomeRetried)
+	}
+	return false
+}
+
+// expireMessage moves the message to the expired state, it reports whether it succeeded.
+func (w *worker) expireMessage(ctx context.Context, msg dto.Outbox) bool {
+	if err := w.store.MarkAsExpired(ctx, msg.ID); err != nil {
+		w.messageLogger(msg).ErrorContext(ctx, "failed to expire message", "error", err)
+		return false
+	}
+	w.metrics.Delivered(msg.DriverName, OutcomeExpired)
+	return true
+}
+
+// pendingAge returns how long the oldest of the messages waited at the given time since it was due.
+func pendingAge(messages []dto.Outbox, now time.Time) time.Duration {
+	var age time.Duration
+	for _, msg := range messages {
+		dueAt := msg.CreatedAt
+		if msg.NextAttemptAt != nil {
+			dueAt = *msg.NextAttemptAt
+		}
+		if waited := now.Sub(dueAt); waited > age {
+			age = waited
+		}
+	}
+	return age
+}
+
+// reportExpired hands the messages expired in a batch to the OnExpired hook.
+func (w *worker) reportExpired(ctx context.Context, expired []dto.Outbox) {
+	if len(expired) == 0 {
+		return
+	}
+	w.logger.InfoContext(ctx, "expired messages", "count", len(expired))
+	if w.cfg.OnExpired != nil {
+		w.cfg.OnExpired(ctx, expired)
+	}
+}
+
+// done removes the message from the in-progress list.
+func (w *worker) done(id int64) {
+	w.Lock()
+	defer w.Unlock()
+	for i, inProg := range w.inProgressMessages {
+		if inProg.ID == id {
+			w.inProgressMessages = append(w.inProgressMessages[:i], w.inProgressMessages[i+1:]...)
+			break
+		}
+	}
+}
+
+// releaseInProgress releases the claim of every message still in progress.
+func (w *worker) releaseInProgress(ctx context.Context) {
+	w.Lock()
+	messages := w.inProgressMessages
+	w.inProgressMessages = nil
+	w.Unlock()
+
+	for _, msg := range messages {
+		if err := w.store.Release(ctx, msg.ID); err != nil {
+			w.messageLogger(msg).ErrorContext(ctx, "failed to release message", "error", err)
 		}
 	}
 }
 
 // processMessage processes a single message using the appropriate provider.
-// DLQ Or Retry logic can be implemented here if needed.
 func (w *worker) processMessage(ctx context.Context, msg dto.Outbox) error {
 	provider := w.providers.GetProvider(msg.DriverName)
 	if provider == nil {
@@ -145,6 +347,15 @@ func (w *worker) processMessage(ctx context.Context, msg dto.Outbox) error {
 }
 
 func (w *worker) Stop() {
-	log.Printf("[Worker %d] graceful stop requested", w.workerID)
+	w.logger.Info("graceful stop requested")
+	w.Lock()
 	w.gracefulStop = true
+	w.Unlock()
+}
+
+// stopping reports whether a graceful stop has been requested.
+func (w *worker) stopping() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.gracefulStop
 }
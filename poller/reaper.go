@@ -0,0 +1,110 @@
+package poller
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/ghaninia/gbox/store"
+)
+
+const (
+	// defaultReapInterval is the interval of a reaper configuration left zero
+	defaultReapInterval = 30 * time.Second
+	// defaultVisibilityTimeout is the visibility timeout of a reaper configuration left zero
+	defaultVisibilityTimeout = 5 * time.Minute
+)
+
+type IReaper interface {
+	Start(ctx context.Context) error
+	Reap(ctx context.Context) (int64, error)
+	Stop()
+}
+
+// ReaperConfig defines the configuration of the stale claim reaper.
+type ReaperConfig struct {
+	// Interval between two reaps, the reaper is disabled in a worker pool when zero and
+	// reaps every 30s when started on its own.
+	Interval time.Duration
+	// VisibilityTimeout is how long a message may stay claimed before it is considered
+	// abandoned by a crashed node, it must exceed the time a worker needs for a batch. 5m when zero.
+	VisibilityTimeout time.Duration
+	// Logger receives the events of the reaper, the default slog logger is used when nil.
+	Logger *slog.Logger
+}
+
+type reaper struct {
+	// DI attributes
+	store store.IStore
+
+	// inside reaper attributes
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	// config reaper attributes
+	cfg    ReaperConfig
+	logger *slog.Logger
+}
+
+// NewReaper creates a reaper that periodically returns messages locked by crashed
+// nodes to the pending state.
+func NewReaper(store store.IStore, cfg ReaperConfig) IReaper {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultReapInterval
+	}
+	if cfg.VisibilityTimeout <= 0 {
+		cfg.VisibilityTimeout = defaultVisibilityTimeout
+	}
+
+	return &reaper{
+		store:  store,
+		stop:   make(chan struct{}),
+		cfg:    cfg,
+		logger: loggerOrDefault(cfg.Logger).With(logKeyComponent, "reaper"),
+	}
+}
+
+// Start reaps stale claims every interval until the context is canceled or Stop is called.
+func (r *reaper) Start(ctx context.Context) error {
+	r.logger.InfoContext(ctx, "reaper started")
+
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.InfoContext(ctx, "context canceled, stopping")
+			return nil
+		case <-r.stop:
+			r.logger.InfoContext(ctx, "reaper stopped")
+			return nil
+		case <-ticker.C:
+			if _, err := r.Reap(ctx); err != nil {
+				r.logger.ErrorContext(ctx, "failed to reap stale messages", "error", err)
+			}
+		}
+	}
+}
+
+// Reap releases the messages claimed longer than the visibility timeout ago once and
+// reports how many were recovered.
+func (r *reaper) Reap(ctx context.Context) (int64, error) {
+	recovered, err := r.store.ReleaseStale(ctx, r.cfg.VisibilityTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	if recovered > 0 {
+		r.logger.InfoContext(ctx, "recovered stale messages", "count", recovered)
+	}
+
+	return recovered, nil
+}
+
+func (r *reaper) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
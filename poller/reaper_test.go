@@ -0,0 +1,31 @@
+package poller
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReaper_DefaultsKeepLiveClaims(t *testing.T) {
+	ctx := context.Background()
+	s := newMemoryStore(t)
+
+	_, err := store.AddValues(ctx, s, "grpc", "claimed")
+	assert.NoError(t, err)
+	claimed, err := s.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 1)
+
+	r := NewReaper(s, ReaperConfig{}).(*reaper)
+	assert.Equal(t, defaultReapInterval, r.cfg.Interval)
+	assert.Equal(t, defaultVisibilityTimeout, r.cfg.VisibilityTimeout)
+
+	// a message claimed just now is not taken back from its worker
+	recovered, err := r.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, recovered)
+	assert.NoError(t, s.MarkAsProcessed(ctx, claimed[0].ID))
+}
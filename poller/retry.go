@@ -0,0 +1,56 @@
+package poller
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	// DefaultRetryPolicy attempts a message five times, i.e. retries it four times, waiting
+	// 1s, 2s, 4s and 8s (±20%) between the attempts.
+	DefaultRetryPolicy = RetryPolicy{
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+		MaxDelay:    5 * time.Minute,
+	}
+)
+
+// RetryPolicy defines how often and how late a failed message is attempted again.
+// The zero value never retries.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	Multiplier  float64
+	Jitter      float64
+	MaxDelay    time.Duration
+}
+
+// Exhausted reports whether no attempt is left after the given number of attempts.
+func (p RetryPolicy) Exhausted(attempts int64) bool {
+	return attempts >= int64(p.MaxAttempts)
+}
+
+// Backoff returns the delay before the attempt following the given attempt number,
+// BaseDelay * Multiplier^(attempt-1) spread by ±Jitter and capped at MaxDelay.
+func (p RetryPolicy) Backoff(attempt int64) time.Duration {
+	multiplier := math.Max(p.Multiplier, 1)
+	delay := float64(p.BaseDelay) * math.Pow(multiplier, float64(max(attempt-1, 0)))
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	return time.Duration(math.Max(delay, 0))
+}
+
+// NextAttemptAt returns when the message may be attempted after the given attempt number.
+func (p RetryPolicy) NextAttemptAt(attempt int64) time.Time {
+	return time.Now().Add(p.Backoff(attempt))
+}
@@ -0,0 +1,44 @@
+package poller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryPolicy_Exhausted(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	assert.False(t, policy.Exhausted(1))
+	assert.False(t, policy.Exhausted(2))
+	assert.True(t, policy.Exhausted(3))
+	assert.True(t, RetryPolicy{}.Exhausted(1))
+}
+
+func TestRetryPolicy_Backoff_Exponential(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay:  time.Second,
+		Multiplier: 2,
+		MaxDelay:   5 * time.Second,
+	}
+
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4))
+}
+
+func TestRetryPolicy_Backoff_Jitter(t *testing.T) {
+	policy := RetryPolicy{
+		BaseDelay:  time.Second,
+		Multiplier: 2,
+		Jitter:     0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(2)
+		assert.GreaterOrEqual(t, delay, time.Second)
+		assert.LessOrEqual(t, delay, 3*time.Second)
+	}
+}
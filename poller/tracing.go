@@ -0,0 +1,47 @@
+package poller
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/ghaninia/gbox/dto"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName names the tracer of the worker spans
+const tracerName = "github.com/ghaninia/gbox/poller"
+
+// startSpan starts the consumer span delivering the message, it is linked to the span that
+// added the message when its headers carry a trace context.
+func (w *worker) startSpan(ctx context.Context, msg dto.Outbox) (context.Context, trace.Span) {
+	opts := []trace.SpanStartOption{
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "gbox"),
+			attribute.String("messaging.operation", "process"),
+			attribute.String("messaging.destination.name", msg.DriverName),
+			attribute.String("messaging.message.id", strconv.FormatInt(msg.ID, 10)),
+			attribute.Int64("gbox.attempt", attemptOf(msg)),
+		),
+	}
+
+	producer := trace.SpanContextFromContext(w.propagator.Extract(context.Background(), propagation.MapCarrier(msg.Headers)))
+	if producer.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{SpanContext: producer}))
+	}
+
+	return w.tracer.Start(ctx, msg.DriverName+" process", opts...)
+}
+
+// endSpan records the error of the delivery on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
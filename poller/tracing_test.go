@@ -0,0 +1,81 @@
+package poller
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestWorkerPool_PropagatesTraceContext(t *testing.T) {
+	var (
+		ctx            = context.Background()
+		recorder       = tracetest.NewSpanRecorder()
+		tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		propagator     = propagation.TraceContext{}
+		provider       = &recordingProvider{}
+	)
+
+	s, err := store.NewStore(store.NewOutboxMemoryRepository(store.RepoSetting{TableName: "outbox"}), store.Setting{
+		NodeID:         1,
+		TracerProvider: tracerProvider,
+		Propagator:     propagator,
+	})
+	assert.NoError(t, err)
+
+	publishCtx, publish := tracerProvider.Tracer("test").Start(ctx, "publish")
+	result, err := store.AddValues(publishCtx, s, "grpc", "traced")
+	publish.End()
+	assert.NoError(t, err)
+	if assert.Len(t, result.Accepted, 1) {
+		assert.Contains(t, result.Accepted[0].Headers, "traceparent")
+	}
+
+	pool := NewWorkerPool(NewProviders().AddProvider(provider), s, WorkerPoolConfig{
+		CountOfWorkers: 1,
+		Worker: WorkerConfig{
+			BatchSizeProcessing: 10,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: 10 * time.Millisecond,
+			TracerProvider:      tracerProvider,
+			Propagator:          propagator,
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return provider.count() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	if newRecords, ok := spans["gbox.store.NewRecords"]; assert.True(t, ok) {
+		assert.Equal(t, publish.SpanContext().SpanID(), newRecords.Parent().SpanID())
+	}
+	assert.Contains(t, spans, "gbox.store.FetchMessages")
+
+	if process, ok := spans["grpc process"]; assert.True(t, ok) {
+		assert.Equal(t, trace.SpanKindConsumer, process.SpanKind())
+		if assert.Len(t, process.Links(), 1) {
+			assert.Equal(t, publish.SpanContext().SpanID(), process.Links()[0].SpanContext.SpanID())
+			assert.Equal(t, publish.SpanContext().TraceID(), process.Links()[0].SpanContext.TraceID())
+		}
+	}
+}
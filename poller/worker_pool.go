@@ -2,7 +2,7 @@ package poller
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"sync"
 
 	"github.com/ghaninia/gbox/store"
@@ -12,13 +12,22 @@ import (
 type WorkerPoolConfig struct {
 	CountOfWorkers int
 	Worker         WorkerConfig
+	Reaper         ReaperConfig
+	Cleaner        CleanerConfig
+	// Logger receives the events of the pool and its workers, it is also used by the reaper
+	// and the cleaner when their configuration has no logger. The default slog logger is used
+	// when nil.
+	Logger *slog.Logger
 }
 
 type workerPool struct {
 	// inside worker pool attributes
 	sync.Mutex
 	workers []IWorker
+	reaper  IReaper
+	cleaner ICleaner
 	cancel  context.CancelFunc
+	logger  *slog.Logger
 
 	// DI attributes
 	// This is to ensure that the worker pool can access the necessary providers and store.
@@ -32,11 +41,19 @@ func NewWorkerPool(
 	store store.IStore,
 	cfg WorkerPoolConfig,
 ) *workerPool {
+	if cfg.Reaper.Logger == nil {
+		cfg.Reaper.Logger = cfg.Logger
+	}
+	if cfg.Cleaner.Logger == nil {
+		cfg.Cleaner.Logger = cfg.Logger
+	}
+
 	return &workerPool{
 		providers: providers,
 		store:     store,
 		cfg:       cfg,
 		workers:   make([]IWorker, 0, cfg.CountOfWorkers),
+		logger:    loggerOrDefault(cfg.Logger),
 	}
 }
 
@@ -46,6 +63,32 @@ func (wp *workerPool) StartBlocking(ctx context.Context) error {
 
 	errGroup, gCtx := errgroup.WithContext(ctx)
 
+	// Recover the messages claimed by crashed nodes alongside the workers.
+	if wp.cfg.Reaper.Interval > 0 {
+		reaper := NewReaper(wp.store, wp.cfg.Reaper)
+
+		wp.Lock()
+		wp.reaper = reaper
+		wp.Unlock()
+
+		errGroup.Go(func() error {
+			return reaper.Start(gCtx)
+		})
+	}
+
+	// Delete the messages finished longer than the retention ago alongside the workers.
+	if wp.cfg.Cleaner.Interval > 0 {
+		cleaner := NewCleaner(wp.store, wp.cfg.Cleaner)
+
+		wp.Lock()
+		wp.cleaner = cleaner
+		wp.Unlock()
+
+		errGroup.Go(func() error {
+			return cleaner.Start(gCtx)
+		})
+	}
+
 	for i := 0; i < wp.cfg.CountOfWorkers; i++ {
 
 		// Create a new worker instance for each worker in the pool.
@@ -54,6 +97,7 @@ func (wp *workerPool) StartBlocking(ctx context.Context) error {
 			wp.store,
 			i,
 			wp.cfg.Worker,
+			wp.logger,
 		)
 
 		wp.Lock()
@@ -69,10 +113,16 @@ func (wp *workerPool) StartBlocking(ctx context.Context) error {
 }
 
 func (wp *workerPool) Stop() {
-	log.Println("[Pool] graceful stop requested")
+	wp.logger.Info("graceful stop requested", logKeyComponent, "pool")
 	wp.Lock()
 	for _, w := range wp.workers {
 		w.Stop()
 	}
+	if wp.reaper != nil {
+		wp.reaper.Stop()
+	}
+	if wp.cleaner != nil {
+		wp.cleaner.Stop()
+	}
 	wp.Unlock()
 }
@@ -0,0 +1,314 @@
+package poller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/codec"
+	"github.com/ghaninia/gbox/dto"
+	"github.com/ghaninia/gbox/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingProvider fails the payloads listed in failures as many times as given and records the handled payloads
+type recordingProvider struct {
+	sync.Mutex
+	failures map[string]int
+	handled  []string
+}
+
+func (p *recordingProvider) DriverName() string {
+	return "grpc"
+}
+
+func (p *recordingProvider) Handle(_ context.Context, record dto.Outbox) error {
+	message, err := codec.Decode[string](record)
+	if err != nil {
+		return err
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	if p.failures[message] > 0 {
+		p.failures[message]--
+		return errors.New("broker unavailable")
+	}
+	p.handled = append(p.handled, message)
+	return nil
+}
+
+func (p *recordingProvider) count() int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.handled)
+}
+
+// newSqliteStore returns a store backed by a migrated sqlite database file.
+func newSqliteStore(t *testing.T) store.IStore {
+	db, err := store.OpenSqlite(filepath.Join(t.TempDir(), "outbox.db"))
+	if err != nil {
+		t.Fatalf("failed to open the sqlite database: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	repo := store.NewOutboxSqliteRepository(store.RepoSetting{TableName: "outbox"}, db)
+	if err := store.Migrate(context.Background(), repo); err != nil {
+		t.Fatalf("failed to migrate the sqlite database: %v", err)
+	}
+
+	s, err := store.NewStore(repo, store.Setting{NodeID: 1})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	return s
+}
+
+// newMemoryStore returns a store backed by the in-memory repository.
+func newMemoryStore(t *testing.T) store.IStore {
+	s, err := store.NewStore(store.NewOutboxMemoryRepository(store.RepoSetting{TableName: "outbox"}), store.Setting{NodeID: 1})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	return s
+}
+
+func TestNewWorker_Defaults(t *testing.T) {
+	w := newWorker(NewProviders(), newMemoryStore(t), 1, WorkerConfig{}, nil).(*worker)
+
+	assert.Equal(t, defaultBatchSizeProcessing, w.cfg.BatchSizeProcessing)
+	assert.Equal(t, defaultTimeoutPerMessage, w.cfg.TimeoutPerMessage)
+	assert.Equal(t, defaultDelayWhenNoMessages, w.cfg.DelayWhenNoMessages)
+	assert.Equal(t, DefaultRetryPolicy, w.cfg.Retry)
+}
+
+func TestWorkerPool_DeliversAndRetries(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.IStore{
+		"sqlite": newSqliteStore,
+		"memory": newMemoryStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testWorkerPoolDeliversAndRetries(t, newStore(t))
+		})
+	}
+}
+
+func testWorkerPoolDeliversAndRetries(t *testing.T, s store.IStore) {
+	var (
+		ctx      = context.Background()
+		provider = &recordingProvider{failures: map[string]int{"flaky": 1, "poison": 2}}
+	)
+
+	_, err := store.AddValues(ctx, s, "grpc", "first", "flaky", "poison")
+	assert.NoError(t, err)
+
+	pool := NewWorkerPool(NewProviders().AddProvider(provider), s, WorkerPoolConfig{
+		CountOfWorkers: 2,
+		Worker: WorkerConfig{
+			BatchSizeProcessing: 10,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: 10 * time.Millisecond,
+			Retry:               RetryPolicy{MaxAttempts: 2, BaseDelay: 10 * time.Millisecond, Multiplier: 1},
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		deadLetters, err := s.DeadLetters(ctx, "grpc", 10, 0)
+		return provider.count() == 2 && err == nil && len(deadLetters) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	deadLetters, err := s.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 1) {
+		assert.Equal(t, `"poison"`, deadLetters[0].Payload)
+		if assert.NotNil(t, deadLetters[0].NumberOfAttempts) {
+			assert.Equal(t, int64(2), *deadLetters[0].NumberOfAttempts)
+		}
+	}
+	assert.ElementsMatch(t, []string{"first", "flaky"}, provider.handled)
+}
+
+// orderedProvider records the payloads handled per ordering key and fails the first attempt of every third message
+type orderedProvider struct {
+	sync.Mutex
+	attempts map[string]int
+	handled  map[string][]string
+	handling map[string]bool
+	overlaps int
+}
+
+func (p *orderedProvider) DriverName() string {
+	return "grpc"
+}
+
+func (p *orderedProvider) Handle(_ context.Context, record dto.Outbox) error {
+	p.Lock()
+	if p.handling[record.OrderingKey] {
+		p.overlaps++
+	}
+	p.handling[record.OrderingKey] = true
+	p.attempts[record.Payload]++
+	attempt := p.attempts[record.Payload]
+	p.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	p.Lock()
+	defer p.Unlock()
+	p.handling[record.OrderingKey] = false
+	if attempt == 1 && len(p.attempts)%3 == 0 {
+		return errors.New("broker unavailable")
+	}
+	p.handled[record.OrderingKey] = append(p.handled[record.OrderingKey], record.Payload)
+	return nil
+}
+
+func (p *orderedProvider) count() int {
+	p.Lock()
+	defer p.Unlock()
+	var count int
+	for _, payloads := range p.handled {
+		count += len(payloads)
+	}
+	return count
+}
+
+func TestWorkerPool_PreservesOrderPerKey(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.IStore{
+		"sqlite": newSqliteStore,
+		"memory": newMemoryStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testWorkerPoolPreservesOrderPerKey(t, newStore(t))
+		})
+	}
+}
+
+func testWorkerPoolPreservesOrderPerKey(t *testing.T, s store.IStore) {
+	var (
+		ctx      = context.Background()
+		keys     = []string{"order-1", "order-2", "order-3"}
+		expected = make(map[string][]string)
+		provider = &orderedProvider{
+			attempts: make(map[string]int),
+			handled:  make(map[string][]string),
+			handling: make(map[string]bool),
+		}
+	)
+
+	for i := 0; i < 10; i++ {
+		for _, key := range keys {
+			payload := fmt.Sprintf("%s-%d", key, i)
+			expected[key] = append(expected[key], payload)
+			_, err := s.Add(ctx, "grpc", dto.NewMessage{Payload: payload, OrderingKey: key})
+			assert.NoError(t, err)
+		}
+	}
+
+	pool := NewWorkerPool(NewProviders().AddProvider(provider), s, WorkerPoolConfig{
+		CountOfWorkers: 4,
+		Worker: WorkerConfig{
+			BatchSizeProcessing: 2,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: time.Millisecond,
+			Retry:               RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, Multiplier: 1},
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		return provider.count() == 30
+	}, 10*time.Second, 10*time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, expected, provider.handled)
+	assert.Zero(t, provider.overlaps)
+}
+
+func TestWorkerPool_ExpiresMessages(t *testing.T) {
+	stores := map[string]func(t *testing.T) store.IStore{
+		"sqlite": newSqliteStore,
+		"memory": newMemoryStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testWorkerPoolExpiresMessages(t, newStore(t))
+		})
+	}
+}
+
+func testWorkerPoolExpiresMessages(t *testing.T, s store.IStore) {
+	var (
+		ctx      = context.Background()
+		provider = &recordingProvider{failures: map[string]int{"quote": 1}}
+		mu       sync.Mutex
+		expired  []string
+	)
+
+	for _, message := range []dto.NewMessage{
+		{Payload: `"fresh"`, TTL: time.Hour},
+		{Payload: `"otp"`, ExpiresAt: time.Now().Add(-time.Second)},
+		{Payload: `"quote"`, TTL: time.Minute},
+	} {
+		_, err := s.Add(ctx, "grpc", message)
+		assert.NoError(t, err)
+	}
+
+	pool := NewWorkerPool(NewProviders().AddProvider(provider), s, WorkerPoolConfig{
+		CountOfWorkers: 1,
+		Worker: WorkerConfig{
+			BatchSizeProcessing: 10,
+			TimeoutPerMessage:   time.Second,
+			DelayWhenNoMessages: 10 * time.Millisecond,
+			// the retry of the quote lands after it expired
+			Retry: RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour, Multiplier: 1},
+			OnExpired: func(_ context.Context, messages []dto.Outbox) {
+				mu.Lock()
+				defer mu.Unlock()
+				for _, message := range messages {
+					expired = append(expired, message.Payload)
+				}
+			},
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.StartBlocking(ctx)
+	}()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return provider.count() == 1 && len(expired) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	pool.Stop()
+	assert.NoError(t, <-done)
+
+	assert.Equal(t, []string{"fresh"}, provider.handled)
+	assert.ElementsMatch(t, []string{`"otp"`, `"quote"`}, expired)
+}
@@ -0,0 +1,34 @@
+package store
+
+import (
+	"context"
+
+	"github.com/ghaninia/gbox/codec"
+	"github.com/ghaninia/gbox/dto"
+)
+
+// NewMessage encodes the value with the codec of the driver into a message carrying its content type.
+func NewMessage[T any](s IStore, driverName string, value T) (dto.NewMessage, error) {
+	c := s.Codec(driverName)
+	payload, err := codec.EncodePayload(c, value)
+	if err != nil {
+		return dto.NewMessage{}, err
+	}
+	return dto.NewMessage{
+		Payload:     payload,
+		ContentType: c.ContentType(),
+	}, nil
+}
+
+// AddValues encodes the values with the codec of the driver and adds them to the outbox.
+func AddValues[T any](ctx context.Context, s IStore, driverName string, values ...T) (AddResult, error) {
+	messages := make([]dto.NewMessage, 0, len(values))
+	for _, value := range values {
+		message, err := NewMessage(s, driverName, value)
+		if err != nil {
+			return AddResult{}, err
+		}
+		messages = append(messages, message)
+	}
+	return s.Add(ctx, driverName, messages...)
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type IDialect interface {
+	// Name returns the name of the dialect
+	Name() string
+	// Placeholder returns the bind parameter of the n-th argument, starting at 1
+	Placeholder(n int) string
+	// Quote quotes an identifier, every part of a schema qualified name is quoted
+	Quote(identifier string) string
+	// LockClause returns the clause appended to a select claiming rows, it skips the rows
+	// already locked by other nodes
+	LockClause() string
+	// InsertIgnore returns an insert statement skipping rows that violate a unique constraint
+	InsertIgnore(table, columns, values string) string
+	// Timestamp returns the column type of timestamps
+	Timestamp() string
+	// CreateIndex returns a statement creating an index, limited to the rows matching where
+	// when the database supports partial indexes
+	CreateIndex(name, table, columns, where string) string
+	// DropIndex returns a statement dropping the index of the table, the table name is unquoted
+	DropIndex(name, table string) string
+	// Arg converts a bind argument to the representation stored by the database
+	Arg(value any) any
+	// Lock acquires the named lock for the connection and returns its release
+	Lock(ctx context.Context, conn *sql.Conn, name string) (func() error, error)
+}
+
+var (
+	// Postgres is the dialect of PostgreSQL
+	Postgres IDialect = postgresDialect{}
+	// MySQL is the dialect of MySQL 8, the connection must be opened with parseTime=true
+	MySQL IDialect = mysqlDialect{}
+	// SQLite is the dialect of SQLite 3.8 or later
+	SQLite IDialect = sqliteDialect{}
+)
+
+// dialectByDriverName returns the dialect of a database/sql driver name, it falls back
+// to Postgres for unknown drivers
+func dialectByDriverName(driverName string) IDialect {
+	switch driverName {
+	case "mysql", "nrmysql":
+		return MySQL
+	case "sqlite", "sqlite3", "nrsqlite3":
+		return SQLite
+	}
+	return Postgres
+}
+
+// dialectOrDefault returns the configured dialect or Postgres
+func dialectOrDefault(dialect IDialect) IDialect {
+	if dialect != nil {
+		return dialect
+	}
+	return Postgres
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string {
+	return "postgres"
+}
+
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, `"`)
+}
+
+func (postgresDialect) LockClause() string {
+	return "FOR UPDATE SKIP LOCKED"
+}
+
+func (postgresDialect) InsertIgnore(table, columns, values string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON CONFLICT DO NOTHING", table, columns, values)
+}
+
+func (postgresDialect) Timestamp() string {
+	return "TIMESTAMP"
+}
+
+func (d postgresDialect) CreateIndex(name, table, columns, where string) string {
+	return createPartialIndex(d, name, table, columns, where)
+}
+
+func (d postgresDialect) DropIndex(name, table string) string {
+	return dropSchemaIndex(d, name, table)
+}
+
+func (postgresDialect) Arg(value any) any {
+	return value
+}
+
+func (postgresDialect) Lock(ctx context.Context, conn *sql.Conn, name string) (func() error, error) {
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", name); err != nil {
+		return nil, err
+	}
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", name)
+		return err
+	}, nil
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string {
+	return "mysql"
+}
+
+func (mysqlDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (mysqlDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, "`")
+}
+
+func (mysqlDialect) LockClause() string {
+	return "FOR UPDATE SKIP LOCKED"
+}
+
+// InsertIgnore turns duplicate rows into no-op updates, unlike INSERT IGNORE it keeps failing
+// on the other errors such as truncated values
+func (mysqlDialect) InsertIgnore(table, columns, values string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES %s ON DUPLICATE KEY UPDATE id = id", table, columns, values)
+}
+
+func (mysqlDialect) Timestamp() string {
+	return "DATETIME(6)"
+}
+
+// CreateIndex leads the index with the state column instead, mysql has no partial indexes
+func (d mysqlDialect) CreateIndex(name, table, columns, where string) string {
+	if where != "" {
+		columns = "state, " + columns
+	}
+	return fmt.Sprintf("CREATE INDEX %s ON %s (%s)", d.Quote(name), table, columns)
+}
+
+func (d mysqlDialect) DropIndex(name, table string) string {
+	return fmt.Sprintf("DROP INDEX %s ON %s", d.Quote(name), d.Quote(table))
+}
+
+func (mysqlDialect) Arg(value any) any {
+	return value
+}
+
+func (mysqlDialect) Lock(ctx context.Context, conn *sql.Conn, name string) (func() error, error) {
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, -1)", name).Scan(&acquired); err != nil {
+		return nil, err
+	}
+	if acquired.Int64 != 1 {
+		return nil, fmt.Errorf("failed to acquire the lock %s", name)
+	}
+	return func() error {
+		_, err := conn.ExecContext(context.Background(), "SELECT RELEASE_LOCK(?)", name)
+		return err
+	}, nil
+}
+
+// sqliteTimeFormat is the layout of the timestamps stored by sqlite
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000Z07:00"
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) Placeholder(int) string {
+	return "?"
+}
+
+func (sqliteDialect) Quote(identifier string) string {
+	return quoteIdentifier(identifier, `"`)
+}
+
+// LockClause is empty, sqlite has a single writer and locks the whole database instead of rows
+func (sqliteDialect) LockClause() string {
+	return ""
+}
+
+func (sqliteDialect) InsertIgnore(table, columns, values string) string {
+	return fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES %s", table, columns, values)
+}
+
+func (sqliteDialect) Timestamp() string {
+	return "TIMESTAMP"
+}
+
+func (d sqliteDialect) CreateIndex(name, table, columns, where string) string {
+	return createPartialIndex(d, name, table, columns, where)
+}
+
+func (d sqliteDialect) DropIndex(name, table string) string {
+	return dropSchemaIndex(d, name, table)
+}
+
+// Arg formats times as fixed width utc text, sqlite stores timestamps as text and compares
+// them lexically, so times of different zones or precisions would be misordered
+func (sqliteDialect) Arg(value any) any {
+	switch v := value.(type) {
+	case time.Time:
+		return v.UTC().Format(sqliteTimeFormat)
+	case *time.Time:
+		if v == nil {
+			return nil
+		}
+		return v.UTC().Format(sqliteTimeFormat)
+	}
+	return value
+}
+
+// Lock is a no-op, the migration transaction already serializes writers
+func (sqliteDialect) Lock(context.Context, *sql.Conn, string) (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+// quoteIdentifier quotes every part of a dot separated identifier with the quote character
+func quoteIdentifier(identifier, quote string) string {
+	parts := strings.Split(identifier, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// createPartialIndex returns a create index statement for databases with partial indexes
+func createPartialIndex(d IDialect, name, table, columns, where string) string {
+	statement := fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s)", d.Quote(name), table, columns)
+	if where != "" {
+		statement += " WHERE " + where
+	}
+	return statement
+}
+
+// dropSchemaIndex returns a drop index statement for databases keeping the indexes in the
+// schema of their table
+func dropSchemaIndex(d IDialect, name, table string) string {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		name = table[:i] + "." + name
+	}
+	return "DROP INDEX IF EXISTS " + d.Quote(name)
+}
+
+// bindArgs converts the bind arguments to the representation stored by the database
+func bindArgs(d IDialect, args []any) []any {
+	converted := make([]any, 0, len(args))
+	for _, arg := range args {
+		converted = append(converted, d.Arg(arg))
+	}
+	return converted
+}
+
+// rebind replaces the ? bind parameters of the query with the placeholders of the dialect
+func rebind(d IDialect, query string) string {
+	var (
+		builder strings.Builder
+		n       int
+	)
+	for _, r := range query {
+		if r != '?' {
+			builder.WriteRune(r)
+			continue
+		}
+		n++
+		builder.WriteString(d.Placeholder(n))
+	}
+	return builder.String()
+}
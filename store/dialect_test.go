@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestDialect_Rebind tests that ? bind parameters are replaced with the placeholders of the dialect.
+func TestDialect_Rebind(t *testing.T) {
+	query := "UPDATE outbox SET state = ? WHERE id = ?"
+
+	assert.Equal(t, "UPDATE outbox SET state = $1 WHERE id = $2", rebind(Postgres, query))
+	assert.Equal(t, query, rebind(MySQL, query))
+	assert.Equal(t, query, rebind(SQLite, query))
+}
+
+// TestDialect_Quote tests that every part of a schema qualified table name is quoted.
+func TestDialect_Quote(t *testing.T) {
+	assert.Equal(t, `"public"."outbox"`, Postgres.Quote("public.outbox"))
+	assert.Equal(t, "`app`.`outbox`", MySQL.Quote("app.outbox"))
+	assert.Equal(t, `"out""box"`, SQLite.Quote(`out"box`))
+}
+
+// TestDialect_InsertIgnore tests the clause skipping duplicate rows of every dialect.
+func TestDialect_InsertIgnore(t *testing.T) {
+	assert.Equal(t, `INSERT INTO "outbox" (id) VALUES (?) ON CONFLICT DO NOTHING`, Postgres.InsertIgnore(`"outbox"`, "id", "(?)"))
+	assert.Equal(t, "INSERT INTO `outbox` (id) VALUES (?) ON DUPLICATE KEY UPDATE id = id", MySQL.InsertIgnore("`outbox`", "id", "(?)"))
+	assert.Equal(t, `INSERT OR IGNORE INTO "outbox" (id) VALUES (?)`, SQLite.InsertIgnore(`"outbox"`, "id", "(?)"))
+}
+
+// TestDialect_CreateIndex tests that mysql leads partial indexes with the state column instead.
+func TestDialect_CreateIndex(t *testing.T) {
+	assert.Equal(t, `CREATE INDEX IF NOT EXISTS "outbox_pending" ON "outbox" (created_at) WHERE state = 'PENDING'`,
+		Postgres.CreateIndex("outbox_pending", `"outbox"`, "created_at", "state = 'PENDING'"))
+	assert.Equal(t, "CREATE INDEX `outbox_pending` ON `outbox` (state, created_at)",
+		MySQL.CreateIndex("outbox_pending", "`outbox`", "created_at", "state = 'PENDING'"))
+}
+
+// TestDialect_DropIndex tests that the index of a schema qualified table is dropped from its schema.
+func TestDialect_DropIndex(t *testing.T) {
+	assert.Equal(t, `DROP INDEX IF EXISTS "public"."outbox_pending"`, Postgres.DropIndex("outbox_pending", "public.outbox"))
+	assert.Equal(t, "DROP INDEX `outbox_pending` ON `outbox`", MySQL.DropIndex("outbox_pending", "outbox"))
+	assert.Equal(t, `DROP INDEX IF EXISTS "outbox_pending"`, SQLite.DropIndex("outbox_pending", "outbox"))
+}
+
+// TestDialect_ByDriverName tests that the dialect is detected from the driver name.
+func TestDialect_ByDriverName(t *testing.T) {
+	assert.Equal(t, MySQL, dialectByDriverName("mysql"))
+	assert.Equal(t, SQLite, dialectByDriverName("sqlite"))
+	assert.Equal(t, Postgres, dialectByDriverName("postgres"))
+	assert.Equal(t, Postgres, dialectByDriverName("pgx"))
+}
@@ -2,11 +2,22 @@ package store
 
 import (
 	"context"
+	"errors"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// gormOutboxRow is the inserted row of a record, it holds the deduplication key of the record
+type gormOutboxRow struct {
+	dto.Outbox
+	HeldDeduplicationKey *string `gorm:"column:held_deduplication_key"`
+}
+
 type outboxGormRepository struct {
 	instance *gorm.DB
 	setting  RepoSetting
@@ -24,9 +35,403 @@ func (o outboxGormRepository) GetTableName() string {
 	return o.setting.TableName
 }
 
-// NewRecords insert new records to outbox table
-func (o outboxGormRepository) NewRecords(ctx context.Context, records []dto.Outbox) error {
-	return o.instance.WithContext(ctx).
+// Migrate creates or upgrades the outbox table
+func (o outboxGormRepository) Migrate(ctx context.Context) error {
+	db, err := o.instance.WithContext(ctx).DB()
+	if err != nil {
+		return err
+	}
+	return migrateSQL(ctx, db, o.dialect(), o.GetTableName())
+}
+
+// dialect get the sql dialect of the repository, detected from the gorm dialector when not set
+func (o outboxGormRepository) dialect() IDialect {
+	if o.setting.Dialect != nil {
+		return o.setting.Dialect
+	}
+	return dialectByDriverName(o.instance.Dialector.Name())
+}
+
+// NewRecords insert new records to outbox table, it returns the ids of the records dropped
+// because another record holds their deduplication key
+func (o outboxGormRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
+	var dropped []int64
+	err := o.instance.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		dropped, err = o.insertRecords(tx, records)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dropped, nil
+}
+
+// NewRecordsTx insert new records to outbox table inside the caller's transactional *gorm.DB
+func (o outboxGormRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	t, ok := tx.(*gorm.DB)
+	if !ok {
+		return nil, constant.ErrUnsupportedTx
+	}
+
+	// a *gorm.DB is only transactional once Begin or Transaction swapped its pool for a *sql.Tx
+	if _, ok := t.Statement.ConnPool.(gorm.TxCommitter); !ok {
+		return nil, constant.ErrUnsupportedTx
+	}
+
+	return o.insertRecords(t.WithContext(ctx), records)
+}
+
+// insertRecords insert records using the given transaction, records whose id is already stored
+// or whose deduplication key is held are skipped. It returns the ids of the records dropped because another
+// record holds their deduplication key.
+func (o outboxGormRepository) insertRecords(tx *gorm.DB, records []dto.Outbox) ([]int64, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	keys, ids := deduplicated(records)
+	if window := o.setting.DeduplicationWindow; window > 0 && len(keys) > 0 {
+		if err := tx.Table(o.GetTableName()).
+			Where("created_at < ? AND held_deduplication_key IN ?", time.Now().Add(-window), keys).
+			Update("held_deduplication_key", nil).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	rows := make([]gormOutboxRow, 0, len(records))
+	for _, record := range records {
+		rows = append(rows, gormOutboxRow{Outbox: record, HeldDeduplicationKey: record.DeduplicationKey})
+	}
+
+	if err := tx.Table(o.GetTableName()).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(rows).Error; err != nil {
+		return nil, err
+	}
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var stored []int64
+	if err := tx.Table(o.GetTableName()).Where("id IN ?", ids).Pluck("id", &stored).Error; err != nil {
+		return nil, err
+	}
+
+	return missingIDs(ids, stored), nil
+}
+
+// FetchMessages claims up to limit pending records whose next attempt is due, highest
+// priority first, and marks them as in progress for this node. Rows locked by other nodes are skipped.
+// Records of an ordering key wait until the earlier records of the key are finished.
+func (o outboxGormRepository) FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error) {
+	records := make([]dto.Outbox, 0)
+
+	err := o.instance.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		query := tx.Table(o.GetTableName() + " candidate")
+		if o.dialect().LockClause() != "" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var (
+			now   = time.Now()
+			args  = sqlArgs{}
+			order = fetchOrder(&args, o.setting.starvedBefore(now))
+		)
+		if err := query.
+			Where("state = ?", dto.OutboxStatePending).
+			Where("next_attempt_at IS NULL OR next_attempt_at <= ?", now).
+			Where(orderingFilter(o.dialect().Quote(o.GetTableName()))).
+			Order(clause.OrderBy{Expression: clause.Expr{SQL: order, Vars: args, WithoutParentheses: true}}).
+			Limit(limit).
+			Find(&records).Error; err != nil {
+			return err
+		}
+
+		if len(records) == 0 {
+			return nil
+		}
+
+		var (
+			lockedAt = time.Now()
+			lockedBy = o.setting.lockedBy()
+			ids      = make([]int64, 0, len(records))
+		)
+
+		for i := range records {
+			ids = append(ids, records[i].ID)
+
+			records[i].State = dto.OutboxStateInProgress
+			records[i].LockedAt = &lockedAt
+			records[i].LockedBy = &lockedBy
+		}
+
+		return tx.Table(o.GetTableName()).
+			Where("id IN ?", ids).
+			Updates(map[string]any{
+				"state":     dto.OutboxStateInProgress,
+				"locked_at": lockedAt,
+				"locked_by": lockedBy,
+			}).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and releases its claim
+func (o outboxGormRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	return o.update(ctx, id, map[string]any{
+		"state":              dto.OutboxStateSucceed,
+		"locked_at":          nil,
+		"locked_by":          nil,
+		"last_attempted_at":  time.Now(),
+		"number_of_attempts": gorm.Expr("COALESCE(number_of_attempts, 0) + 1"),
+		"error":              nil,
+	})
+}
+
+// MarkAsFailed marks the record as failed with the given reason and releases its claim
+func (o outboxGormRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, map[string]any{
+		"state":              dto.OutboxStateFailed,
+		"locked_at":          nil,
+		"locked_by":          nil,
+		"last_attempted_at":  time.Now(),
+		"number_of_attempts": gorm.Expr("COALESCE(number_of_attempts, 0) + 1"),
+		"error":              reason,
+	})
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and releases its claim
+func (o outboxGormRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, map[string]any{
+		"state":              dto.OutboxStateDeadLettered,
+		"locked_at":          nil,
+		"locked_by":          nil,
+		"last_attempted_at":  time.Now(),
+		"number_of_attempts": gorm.Expr("COALESCE(number_of_attempts, 0) + 1"),
+		"error":              reason,
+	})
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and releases its claim
+func (o outboxGormRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	return o.update(ctx, id, map[string]any{
+		"state":     dto.OutboxStateExpired,
+		"locked_at": nil,
+		"locked_by": nil,
+	})
+}
+
+// MarkAsRetry records a failed attempt and returns the record to the pending state,
+// it is not fetched again before nextAttemptAt
+func (o outboxGormRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	return o.update(ctx, id, map[string]any{
+		"state":              dto.OutboxStatePending,
+		"locked_at":          nil,
+		"locked_by":          nil,
+		"last_attempted_at":  time.Now(),
+		"number_of_attempts": gorm.Expr("COALESCE(number_of_attempts, 0) + 1"),
+		"error":              reason,
+		"next_attempt_at":    nextAttemptAt,
+	})
+}
+
+// Release returns the record to the pending state and clears its claim
+func (o outboxGormRepository) Release(ctx context.Context, id int64) error {
+	return o.update(ctx, id, map[string]any{
+		"state":     dto.OutboxStatePending,
+		"locked_at": nil,
+		"locked_by": nil,
+	})
+}
+
+// ReleaseStale returns records locked before lockedBefore to the pending state, counting the
+// interrupted attempt. It reports how many records were released.
+func (o outboxGormRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state = ? AND locked_at < ?", dto.OutboxStateInProgress, lockedBefore).
+		Updates(map[string]any{
+			"state":              dto.OutboxStatePending,
+			"locked_at":          nil,
+			"locked_by":          nil,
+			"number_of_attempts": gorm.Expr("COALESCE(number_of_attempts, 0) + 1"),
+		})
+	return result.RowsAffected, result.Error
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o outboxGormRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	records := make([]dto.Outbox, 0)
+	err := o.deadLetters(ctx, driverName, nil).
+		Order("id").
+		Limit(limit).
+		Offset(offset).
+		Find(&records).Error
+	return records, err
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o outboxGormRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	var record dto.Outbox
+	err := o.deadLetters(ctx, "", []int64{id}).Take(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return record, err
+}
+
+// RequeueDeadLetters returns dead-lettered records of the driver to the pending state with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o outboxGormRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	result := o.deadLetters(ctx, driverName, ids).Updates(map[string]any{
+		"state":              dto.OutboxStatePending,
+		"number_of_attempts": nil,
+		"next_attempt_at":    nil,
+		"expires_at":         nil,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o outboxGormRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	result := o.deadLetters(ctx, driverName, ids).Delete(&dto.Outbox{})
+	return result.RowsAffected, result.Error
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o outboxGormRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	records := make([]dto.Outbox, 0)
+	err := o.instance.WithContext(ctx).
 		Table(o.GetTableName()).
-		Create(records).Error
+		Where("state IN ? AND COALESCE(last_attempted_at, created_at) < ?", states, finishedBefore).
+		Order("id").
+		Limit(limit).
+		Find(&records).Error
+	return records, err
+}
+
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o outboxGormRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state IN ? AND id IN ?", states, ids).
+		Delete(&dto.Outbox{})
+	return result.RowsAffected, result.Error
+}
+
+// ListMessages lists the records selected by the filter ordered by id
+func (o outboxGormRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	query := o.instance.WithContext(ctx).Table(o.GetTableName())
+	if len(filter.IDs) > 0 {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if len(filter.States) > 0 {
+		query = query.Where("state IN ?", filter.States)
+	}
+	if filter.DriverName != "" {
+		query = query.Where("driver_name = ?", filter.DriverName)
+	}
+	if filter.AfterID > 0 {
+		query = query.Where("id > ?", filter.AfterID)
+	}
+
+	records := make([]dto.Outbox, 0)
+	err := query.
+		Order("id").
+		Limit(filter.Limit).
+		Offset(filter.Offset).
+		Find(&records).Error
+	return records, err
+}
+
+// Stats counts the records of every driver and state
+func (o outboxGormRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	stats := make([]dto.MessageStats, 0)
+	err := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Select(statsColumns).
+		Group("driver_name, state").
+		Order("driver_name, state").
+		Scan(&stats).Error
+	return stats, err
+}
+
+// RetryMessages makes the pending records of the ids waiting for their next attempt due right away.
+// It reports how many records were rescheduled.
+func (o outboxGormRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state = ? AND next_attempt_at > ? AND id IN ?", dto.OutboxStatePending, time.Now(), ids).
+		Update("next_attempt_at", nil)
+	return result.RowsAffected, result.Error
+}
+
+// RequeueMessages returns the records of the ids that are in one of the states to the pending state
+// with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o outboxGormRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state IN ? AND id IN ?", states, ids).
+		Updates(map[string]any{
+			"state":              dto.OutboxStatePending,
+			"locked_at":          nil,
+			"locked_by":          nil,
+			"number_of_attempts": nil,
+			"next_attempt_at":    nil,
+			"expires_at":         nil,
+		})
+	return result.RowsAffected, result.Error
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, so they are never delivered.
+// It reports how many records were canceled.
+func (o outboxGormRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state = ? AND id IN ?", dto.OutboxStatePending, ids).
+		Update("state", dto.OutboxStateCanceled)
+	return result.RowsAffected, result.Error
+}
+
+// deadLetters scopes a query to dead letters of the driver and ids
+func (o outboxGormRepository) deadLetters(ctx context.Context, driverName string, ids []int64) *gorm.DB {
+	query := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("state = ?", dto.OutboxStateDeadLettered)
+	if driverName != "" {
+		query = query.Where("driver_name = ?", driverName)
+	}
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	return query
+}
+
+// update applies the columns to the record with the given id while it is still claimed by this
+// node, so that a record reclaimed and fetched by another node meanwhile is left to its new owner
+func (o outboxGormRepository) update(ctx context.Context, id int64, columns map[string]any) error {
+	result := o.instance.WithContext(ctx).
+		Table(o.GetTableName()).
+		Where("id = ? AND state = ? AND locked_by = ?", id, dto.OutboxStateInProgress, o.setting.lockedBy()).
+		Updates(columns)
+
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return constant.ErrClaimLost
+	}
+
+	return nil
 }
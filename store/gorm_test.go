@@ -1,12 +1,16 @@
+//go:build integration
+
 package store
 
 import (
 	"context"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
 )
 
 // newDBGormInstance returns a new instance of GormStore.
@@ -48,6 +52,418 @@ func TestOutboxGormRepository_NewRecords(t *testing.T) {
 		},
 	}
 
-	err = repo.NewRecords(context.Background(), records)
+	_, err = repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+}
+
+// TestOutboxGormRepository_FetchMessages tests the method FetchMessages of OutboxGormRepository.
+func TestOutboxGormRepository_FetchMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	repo := NewOutboxGormRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, gormClient)
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+
+	_, err := repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(context.Background(), 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxGormRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxGormRepository_FetchMessages_OrderingKey(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesOrderingKey(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxGormRepository_FetchMessages_Scheduled(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesScheduled(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxGormRepository_MarkAsExpired(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMarkAsExpired(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_MessageMetadata tests that the key, event type and headers of the records are kept.
+func TestOutboxGormRepository_MessageMetadata(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMessageMetadata(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxGormRepository_FetchMessages_Priority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesPriority(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, gormClient))
+}
+
+// TestOutboxGormRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxGormRepository_FetchMessages_Starvation(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvation(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, gormClient))
+}
+
+// TestOutboxGormRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxGormRepository_FetchMessages_StarvedPriority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvedPriority(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, gormClient))
+}
+
+// TestOutboxGormRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxGormRepository_FinishedMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFinishedMessages(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxGormRepository_AdminQueries(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAdminQueries(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxGormRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testRequeueDeadLettersExpiry(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxGormRepository_AcknowledgeClaimLost(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeClaimLost(t,
+		NewOutboxGormRepository(RepoSetting{TableName: "outbox", NodeID: "node-1"}, gormClient),
+		NewOutboxGormRepository(RepoSetting{TableName: "outbox", NodeID: "node-2"}, gormClient),
+	)
+}
+
+// TestOutboxGormRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxGormRepository_NewRecords_Deduplication(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplication(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox"}, gormClient))
+}
+
+// TestOutboxGormRepository_NewRecords_DeduplicationWindow tests that expired deduplication keys are taken over.
+func TestOutboxGormRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplicationWindow(t, NewOutboxGormRepository(RepoSetting{TableName: "outbox", DeduplicationWindow: time.Hour}, gormClient))
+}
+
+// TestOutboxGormRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed and Release of OutboxGormRepository.
+func TestOutboxGormRepository_Acknowledge(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxGormRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, gormClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	succeed := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStateSucceed, succeed.State)
+	assert.Nil(t, succeed.LockedBy)
+	assert.NotNil(t, succeed.LastAttemptedAt)
+	if assert.NotNil(t, succeed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *succeed.NumberOfAttempts)
+	}
+
+	failed := findSqlRecord(t, 2)
+	assert.Equal(t, dto.OutboxStateFailed, failed.State)
+	assert.Nil(t, failed.LockedBy)
+	assert.NotNil(t, failed.LastAttemptedAt)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, "connection refused", *failed.Error)
+	}
+	if assert.NotNil(t, failed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *failed.NumberOfAttempts)
+	}
+
+	released := findSqlRecord(t, 3)
+	assert.Equal(t, dto.OutboxStatePending, released.State)
+	assert.Nil(t, released.LockedAt)
+	assert.Nil(t, released.LockedBy)
+	assert.Nil(t, released.NumberOfAttempts)
+
+	// only the released record can be claimed again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxGormRepository_NewRecordsTx tests that records follow the outcome of the caller's transaction.
+func TestOutboxGormRepository_NewRecordsTx(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo, err := newDBGormInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of GormStore: %v", err)
+		return
+	}
+
+	tx := gormClient.Begin()
+	assert.NoError(t, tx.Error)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback().Error)
+	assert.Equal(t, 0, countSqlRecords(t))
+
+	err = gormClient.Transaction(func(tx *gorm.DB) error {
+		_, err := repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+		return err
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, countSqlRecords(t))
+
+	_, err = repo.NewRecordsTx(ctx, gormClient, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxGormRepository_MarkAsRetry tests that retried records are only fetched once their next attempt is due.
+func TestOutboxGormRepository_MarkAsRetry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxGormRepository(RepoSetting{
+		TableName: "outbox",
+	}, gormClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second)))
+
+	retried := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, retried.State)
+	assert.Nil(t, retried.LockedBy)
+	assert.NotNil(t, retried.NextAttemptAt)
+	assert.NotNil(t, retried.LastAttemptedAt)
+	if assert.NotNil(t, retried.Error) {
+		assert.Equal(t, "timeout", *retried.Error)
+	}
+	if assert.NotNil(t, retried.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *retried.NumberOfAttempts)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+	}
+}
+
+// TestOutboxGormRepository_DeadLetters tests listing, inspecting, requeueing and purging dead letters of OutboxGormRepository.
+func TestOutboxGormRepository_DeadLetters(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxGormRepository(RepoSetting{
+		TableName: "outbox",
+	}, gormClient)
+
+	records := newPendingRecords(4)
+	records[3].DriverName = "http"
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 4)
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 1, "poison"))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 2, "poison"))
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 4, "poison"))
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 2, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, int64(2), deadLetters[0].ID)
+		assert.Equal(t, int64(4), deadLetters[1].ID)
+	}
+
+	deadLetter, err := repo.DeadLetter(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.OutboxStateDeadLettered, deadLetter.State)
+	if assert.NotNil(t, deadLetter.Error) {
+		assert.Equal(t, "poison", *deadLetter.Error)
+	}
+	if assert.NotNil(t, deadLetter.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *deadLetter.NumberOfAttempts)
+	}
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	claimed, err = repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+// TestOutboxGormRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxGormRepository_ReleaseStale(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxGormRepository(RepoSetting{
+		TableName: "outbox",
+	}, gormClient)
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		crashedBy = "crashed-node"
+		lockedBy  = "live-node"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &crashedBy
+	}
+	records[2].LockedAt, records[2].LockedBy = &lockedAt, &lockedBy
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	recovered := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, recovered.State)
+	assert.Nil(t, recovered.LockedBy)
+	if assert.NotNil(t, recovered.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *recovered.NumberOfAttempts)
+	}
+
+	assert.Equal(t, dto.OutboxStateInProgress, findSqlRecord(t, 3).State)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
 	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
 }
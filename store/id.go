@@ -0,0 +1,57 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+
+	"github.com/bwmarrin/snowflake"
+)
+
+type IIDGenerator interface {
+	NextID() int64
+}
+
+var (
+	// snowflakeNodes holds the node of every node id in use, the generators of a node id share it
+	// so that stores of the same node never generate an id twice
+	snowflakeNodes   = make(map[int]*snowflake.Node)
+	snowflakeNodesMu sync.Mutex
+)
+
+type snowflakeGenerator struct {
+	node *snowflake.Node
+}
+
+// NewSnowflakeGenerator creates a snowflake id generator for the given node, the ids are
+// unique per node and roughly sortable by their creation time. The generators of a node id
+// in a process share its sequence, so several stores of one node generate unique ids.
+func NewSnowflakeGenerator(nodeID int) (IIDGenerator, error) {
+	snowflakeNodesMu.Lock()
+	defer snowflakeNodesMu.Unlock()
+
+	node, ok := snowflakeNodes[nodeID]
+	if !ok {
+		var err error
+		if node, err = snowflake.NewNode(int64(nodeID)); err != nil {
+			return nil, fmt.Errorf("%w: %d", constant.ErrInvalidNodeID, nodeID)
+		}
+		snowflakeNodes[nodeID] = node
+	}
+	return &snowflakeGenerator{
+		node: node,
+	}, nil
+}
+
+// NextID returns the next snowflake id
+func (g *snowflakeGenerator) NextID() int64 {
+	return g.node.Generate().Int64()
+}
+
+// SnowflakeIDAt returns the smallest snowflake id generated at the time, so that the messages
+// added since then by the default generator can be listed with dto.MessageFilter.AfterID.
+func SnowflakeIDAt(t time.Time) int64 {
+	return (t.UnixMilli() - snowflake.Epoch) << (snowflake.NodeBits + snowflake.StepBits)
+}
@@ -0,0 +1,20 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnowflakeIDAt(t *testing.T) {
+	generator, err := NewSnowflakeGenerator(1023)
+	assert.NoError(t, err)
+
+	before := SnowflakeIDAt(time.Now())
+	id := generator.NextID()
+	after := SnowflakeIDAt(time.Now().Add(time.Millisecond))
+
+	assert.Greater(t, id, before)
+	assert.Less(t, id, after)
+}
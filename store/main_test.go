@@ -1,16 +1,22 @@
+//go:build integration
+
 package store
 
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/ghaninia/gbox/dto"
 	"gorm.io/driver/postgres"
 
 	"github.com/jmoiron/sqlx"
@@ -35,28 +41,11 @@ var (
 	onceRedis   sync.Once
 )
 
-// seeders returns the list of seeders
-func upSeeder() []string {
-	return []string{
-		`CREATE TABLE outbox (
-			id BIGINT PRIMARY KEY,
-			driver_name VARCHAR(255) NOT NULL,
-			payload TEXT NOT NULL,
-			state VARCHAR(255) NOT NULL,
-			created_at TIMESTAMP NOT NULL,
-			locked_at TIMESTAMP,
-			locked_by VARCHAR(255),
-			last_attempted_at TIMESTAMP,
-			number_of_attempts INTEGER,
-			error TEXT
-		);`,
-	}
-}
-
 // seeders returns the list of seeders
 func downSeeder() []string {
 	return []string{
 		"DROP TABLE IF EXISTS outbox;",
+		"DROP TABLE IF EXISTS outbox_migrations;",
 	}
 }
 
@@ -188,15 +177,16 @@ func TestMain(m *testing.M) {
 	}
 
 	// Run the migrations for the test suite
-	var seeders = []string{}
-	seeders = append(seeders, downSeeder()...)
-	seeders = append(seeders, upSeeder()...)
-	for _, statement := range seeders {
+	for _, statement := range downSeeder() {
 		if _, err := sqlClient.Exec(statement); err != nil {
 			panic(errors.Join(err, errors.New("failed to run the migration")))
 		}
 	}
 
+	if err := Migrate(context.Background(), NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient)); err != nil {
+		panic(errors.Join(err, errors.New("failed to run the migration")))
+	}
+
 	redisClient, err = newRedisTestContainerClient()
 	if err != nil {
 		panic(errors.Join(err, errors.New("failed to create a new instance of Redis client")))
@@ -225,5 +215,47 @@ func setupSuite(tb testing.TB) func(tb testing.TB) {
 				tb.Fatalf("failed to run the migration: %v", err)
 			}
 		}
+
+		// fresh the redis keys after the test
+		if err := redisClient.FlushDB(context.Background()).Err(); err != nil {
+			tb.Fatalf("failed to flush redis: %v", err)
+		}
+	}
+}
+
+// findSqlRecord returns the record with the given id straight from the outbox table
+func findSqlRecord(tb testing.TB, id int64) dto.Outbox {
+	rows, err := sqlClient.Query(fmt.Sprintf("SELECT %s FROM outbox WHERE id = $1", outboxColumns), id)
+	if err != nil {
+		tb.Fatalf("failed to query the record: %v", err)
+	}
+
+	records, err := scanOutboxRows(rows)
+	if err != nil || len(records) != 1 {
+		tb.Fatalf("failed to find the record %d: %v", id, err)
+	}
+	return records[0]
+}
+
+// countSqlRecords returns the number of records in the outbox table
+func countSqlRecords(tb testing.TB) int {
+	var count int
+	if err := sqlClient.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count); err != nil {
+		tb.Fatalf("failed to count the records: %v", err)
+	}
+	return count
+}
+
+// findRedisRecord returns the record with the given id straight from the outbox hash
+func findRedisRecord(tb testing.TB, id int64) dto.Outbox {
+	jRecord, err := redisClient.HGet(context.Background(), "outbox", strconv.FormatInt(id, 10)).Result()
+	if err != nil {
+		tb.Fatalf("failed to find the record %d: %v", id, err)
+	}
+
+	var record dto.Outbox
+	if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+		tb.Fatalf("failed to decode the record %d: %v", id, err)
 	}
+	return record
 }
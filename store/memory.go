@@ -0,0 +1,485 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+)
+
+type outboxMemoryRepository struct {
+	sync.Mutex
+	records map[int64]*dto.Outbox
+	// keys maps the deduplication keys to the id of the record holding them
+	keys    map[string]int64
+	setting RepoSetting
+}
+
+// NewOutboxMemoryRepository returns a repository keeping the outbox records in memory. It
+// claims, acknowledges and retries records like the sql repositories and is safe for
+// concurrent use, the records are lost with the process so it suits tests and local development.
+func NewOutboxMemoryRepository(setting RepoSetting) IRepository {
+	return &outboxMemoryRepository{
+		records: make(map[int64]*dto.Outbox),
+		keys:    make(map[string]int64),
+		setting: setting,
+	}
+}
+
+// GetTableName get a table name
+func (o *outboxMemoryRepository) GetTableName() string {
+	return o.setting.TableName
+}
+
+// Migrate is a no-op, the records are kept in memory
+func (o *outboxMemoryRepository) Migrate(context.Context) error {
+	return nil
+}
+
+// NewRecords insert new records, records whose id already exists are skipped. It returns the
+// ids of the records dropped because another record holds their deduplication key.
+func (o *outboxMemoryRepository) NewRecords(_ context.Context, records []dto.Outbox) ([]int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	var dropped []int64
+	for _, record := range records {
+		if _, exists := o.records[record.ID]; exists {
+			continue
+		}
+		if record.DeduplicationKey != nil {
+			if o.holdsKey(*record.DeduplicationKey) {
+				dropped = append(dropped, record.ID)
+				continue
+			}
+			o.keys[*record.DeduplicationKey] = record.ID
+		}
+		stored := cloneOutbox(record)
+		o.records[record.ID] = &stored
+	}
+	return dropped, nil
+}
+
+// NewRecordsTx is not supported, the memory repository has no transactions
+func (o *outboxMemoryRepository) NewRecordsTx(context.Context, any, []dto.Outbox) ([]int64, error) {
+	return nil, constant.ErrUnsupportedTx
+}
+
+// holdsKey reports whether a stored record holds the deduplication key, a record created before
+// the deduplication window releases it but keeps its key
+func (o *outboxMemoryRepository) holdsKey(key string) bool {
+	holder, ok := o.records[o.keys[key]]
+	if !ok {
+		return false
+	}
+	window := o.setting.DeduplicationWindow
+	return window <= 0 || !holder.CreatedAt.Before(time.Now().Add(-window))
+}
+
+// FetchMessages claims up to limit pending records whose next attempt is due, highest
+// priority first, and marks them as in progress for this node. A record with an ordering key is
+// skipped while an earlier record of its key is pending or in progress.
+func (o *outboxMemoryRepository) FetchMessages(_ context.Context, limit int) ([]dto.Outbox, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	active := make([]*dto.Outbox, 0)
+	for _, record := range o.records {
+		if record.State == dto.OutboxStatePending || record.State == dto.OutboxStateInProgress {
+			active = append(active, record)
+		}
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		if !active[i].CreatedAt.Equal(active[j].CreatedAt) {
+			return active[i].CreatedAt.Before(active[j].CreatedAt)
+		}
+		return active[i].ID < active[j].ID
+	})
+
+	var (
+		now     = time.Now()
+		due     = make([]*dto.Outbox, 0)
+		ordered = make(map[string]struct{})
+	)
+	for _, record := range active {
+		// only the earliest active record of an ordering key can be delivered
+		if record.OrderingKey != "" {
+			if _, ok := ordered[record.OrderingKey]; ok {
+				continue
+			}
+			ordered[record.OrderingKey] = struct{}{}
+		}
+		if record.State != dto.OutboxStatePending {
+			continue
+		}
+		if record.NextAttemptAt != nil && record.NextAttemptAt.After(now) {
+			continue
+		}
+		due = append(due, record)
+	}
+
+	// higher priorities first, starved records ahead of them longest waiting first
+	starvedBefore := o.setting.starvedBefore(now)
+	dueAt := func(record *dto.Outbox) time.Time {
+		if record.NextAttemptAt != nil {
+			return *record.NextAttemptAt
+		}
+		return record.CreatedAt
+	}
+	starved := func(record *dto.Outbox) bool {
+		return !starvedBefore.IsZero() && !dueAt(record).After(starvedBefore)
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		if starved(due[i]) != starved(due[j]) {
+			return starved(due[i])
+		}
+		if starved(due[i]) {
+			if !dueAt(due[i]).Equal(dueAt(due[j])) {
+				return dueAt(due[i]).Before(dueAt(due[j]))
+			}
+			return due[i].ID < due[j].ID
+		}
+		return due[i].Priority > due[j].Priority
+	})
+
+	if limit >= 0 && limit < len(due) {
+		due = due[:limit]
+	}
+
+	lockedBy := o.setting.lockedBy()
+	records := make([]dto.Outbox, 0, len(due))
+	for _, record := range due {
+		lockedAt := now
+		record.State = dto.OutboxStateInProgress
+		record.LockedAt = &lockedAt
+		record.LockedBy = &lockedBy
+		records = append(records, cloneOutbox(*record))
+	}
+
+	return records, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and releases its claim
+func (o *outboxMemoryRepository) MarkAsProcessed(_ context.Context, id int64) error {
+	return o.update(id, func(record *dto.Outbox) {
+		finishAttempt(record, dto.OutboxStateSucceed, nil)
+	})
+}
+
+// MarkAsFailed marks the record as failed with the given reason and releases its claim
+func (o *outboxMemoryRepository) MarkAsFailed(_ context.Context, id int64, reason string) error {
+	return o.update(id, func(record *dto.Outbox) {
+		finishAttempt(record, dto.OutboxStateFailed, &reason)
+	})
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and releases its claim
+func (o *outboxMemoryRepository) MarkAsDeadLettered(_ context.Context, id int64, reason string) error {
+	return o.update(id, func(record *dto.Outbox) {
+		finishAttempt(record, dto.OutboxStateDeadLettered, &reason)
+	})
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and releases its claim
+func (o *outboxMemoryRepository) MarkAsExpired(_ context.Context, id int64) error {
+	return o.update(id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStateExpired
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// MarkAsRetry records a failed attempt and returns the record to the pending state,
+// it is not fetched again before nextAttemptAt
+func (o *outboxMemoryRepository) MarkAsRetry(_ context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	return o.update(id, func(record *dto.Outbox) {
+		finishAttempt(record, dto.OutboxStatePending, &reason)
+		record.NextAttemptAt = &nextAttemptAt
+	})
+}
+
+// Release returns the record to the pending state and clears its claim
+func (o *outboxMemoryRepository) Release(_ context.Context, id int64) error {
+	return o.update(id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// ReleaseStale returns records locked before lockedBefore to the pending state, counting the
+// interrupted attempt. It reports how many records were released.
+func (o *outboxMemoryRepository) ReleaseStale(_ context.Context, lockedBefore time.Time) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	var released int64
+	for _, record := range o.records {
+		if record.State != dto.OutboxStateInProgress || record.LockedAt == nil || !record.LockedAt.Before(lockedBefore) {
+			continue
+		}
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		released++
+	}
+	return released, nil
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o *outboxMemoryRepository) DeadLetters(_ context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	deadLetters := o.deadLetters(driverName, nil)
+	records := make([]dto.Outbox, 0, len(deadLetters))
+	for _, record := range deadLetters {
+		records = append(records, cloneOutbox(*record))
+	}
+	return paginate(records, limit, offset), nil
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o *outboxMemoryRepository) DeadLetter(_ context.Context, id int64) (dto.Outbox, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	record, exists := o.records[id]
+	if !exists || record.State != dto.OutboxStateDeadLettered {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return cloneOutbox(*record), nil
+}
+
+// RequeueDeadLetters returns dead-lettered records of the driver to the pending state with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o *outboxMemoryRepository) RequeueDeadLetters(_ context.Context, driverName string, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	deadLetters := o.deadLetters(driverName, ids)
+	for _, record := range deadLetters {
+		requeue(record)
+	}
+	return int64(len(deadLetters)), nil
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o *outboxMemoryRepository) PurgeDeadLetters(_ context.Context, driverName string, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	deadLetters := o.deadLetters(driverName, ids)
+	for _, record := range deadLetters {
+		delete(o.records, record.ID)
+	}
+	return int64(len(deadLetters)), nil
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o *outboxMemoryRepository) FinishedMessages(_ context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	records := make([]dto.Outbox, 0)
+	for _, record := range o.records {
+		if inStates(record.State, states) && record.FinishedAt().Before(finishedBefore) {
+			records = append(records, cloneOutbox(*record))
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return paginate(records, limit, 0), nil
+}
+
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o *outboxMemoryRepository) DeleteMessages(_ context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	var deleted int64
+	for _, id := range ids {
+		if record, exists := o.records[id]; exists && inStates(record.State, states) {
+			delete(o.records, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ListMessages lists the records selected by the filter ordered by id
+func (o *outboxMemoryRepository) ListMessages(_ context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	records := make([]dto.Outbox, 0)
+	for _, record := range o.records {
+		if filter.Match(*record) {
+			records = append(records, cloneOutbox(*record))
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return paginate(records, filter.Limit, filter.Offset), nil
+}
+
+// Stats counts the records of every driver and state
+func (o *outboxMemoryRepository) Stats(context.Context) ([]dto.MessageStats, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	records := make([]dto.Outbox, 0, len(o.records))
+	for _, record := range o.records {
+		records = append(records, *record)
+	}
+	return countMessages(records), nil
+}
+
+// RetryMessages makes the pending records of the ids waiting for their next attempt due right away.
+// It reports how many records were rescheduled.
+func (o *outboxMemoryRepository) RetryMessages(_ context.Context, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	now := time.Now()
+	return o.updateMany(ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending || record.NextAttemptAt == nil || !record.NextAttemptAt.After(now) {
+			return false
+		}
+		record.NextAttemptAt = nil
+		return true
+	}), nil
+}
+
+// RequeueMessages returns the records of the ids that are in one of the states to the pending state
+// with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o *outboxMemoryRepository) RequeueMessages(_ context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.updateMany(ids, func(record *dto.Outbox) bool {
+		if !inStates(record.State, states) {
+			return false
+		}
+		requeue(record)
+		return true
+	}), nil
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, so they are never delivered.
+// It reports how many records were canceled.
+func (o *outboxMemoryRepository) CancelMessages(_ context.Context, ids []int64) (int64, error) {
+	o.Lock()
+	defer o.Unlock()
+
+	return o.updateMany(ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending {
+			return false
+		}
+		record.State = dto.OutboxStateCanceled
+		return true
+	}), nil
+}
+
+// updateMany applies fn to the stored records of the ids and counts the records it changed,
+// the caller must hold the lock
+func (o *outboxMemoryRepository) updateMany(ids []int64, fn func(record *dto.Outbox) bool) int64 {
+	var updated int64
+	for _, id := range ids {
+		if record, exists := o.records[id]; exists && fn(record) {
+			updated++
+		}
+	}
+	return updated
+}
+
+// deadLetters returns the dead-lettered records of the driver and ids sorted by id,
+// the caller must hold the lock
+func (o *outboxMemoryRepository) deadLetters(driverName string, ids []int64) []*dto.Outbox {
+	var wanted map[int64]bool
+	if len(ids) > 0 {
+		wanted = make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			wanted[id] = true
+		}
+	}
+
+	records := make([]*dto.Outbox, 0)
+	for _, record := range o.records {
+		if record.State != dto.OutboxStateDeadLettered {
+			continue
+		}
+		if driverName != "" && record.DriverName != driverName {
+			continue
+		}
+		if wanted != nil && !wanted[record.ID] {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// update applies fn to the record with the given id while it is still claimed by this node, so
+// that a record reclaimed and fetched by another node meanwhile is left to its new owner
+func (o *outboxMemoryRepository) update(id int64, fn func(record *dto.Outbox)) error {
+	o.Lock()
+	defer o.Unlock()
+
+	record, exists := o.records[id]
+	if !exists || !claimedBy(*record, o.setting.lockedBy()) {
+		return constant.ErrClaimLost
+	}
+	fn(record)
+	return nil
+}
+
+// finishAttempt records a finished attempt of the record in the given state and releases its claim
+func finishAttempt(record *dto.Outbox, state dto.OutboxStateEnum, reason *string) {
+	attemptedAt := time.Now()
+	record.State = state
+	record.LockedAt = nil
+	record.LockedBy = nil
+	record.LastAttemptedAt = &attemptedAt
+	record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+	record.Error = reason
+}
+
+// cloneOutbox returns a copy of the record that shares no pointers with it
+func cloneOutbox(record dto.Outbox) dto.Outbox {
+	record.LockedAt = clonePtr(record.LockedAt)
+	record.LockedBy = clonePtr(record.LockedBy)
+	record.LastAttemptedAt = clonePtr(record.LastAttemptedAt)
+	record.NumberOfAttempts = clonePtr(record.NumberOfAttempts)
+	record.Error = clonePtr(record.Error)
+	record.NextAttemptAt = clonePtr(record.NextAttemptAt)
+	record.Headers = record.Headers.Clone()
+	record.DeduplicationKey = clonePtr(record.DeduplicationKey)
+	record.ExpiresAt = clonePtr(record.ExpiresAt)
+	return record
+}
+
+// clonePtr returns a pointer to a copy of the value, or nil
+func clonePtr[T any](value *T) *T {
+	if value == nil {
+		return nil
+	}
+	copied := *value
+	return &copied
+}
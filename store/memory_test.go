@@ -0,0 +1,316 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newMemoryInstance returns a new instance of OutboxMemoryRepository.
+func newMemoryInstance(nodeID string) IRepository {
+	return NewOutboxMemoryRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    nodeID,
+	})
+}
+
+// TestOutboxMemoryRepository_FetchMessages tests the method FetchMessages of OutboxMemoryRepository.
+func TestOutboxMemoryRepository_FetchMessages(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("node-1")
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+	_, err = repo.NewRecords(ctx, records[:1])
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxMemoryRepository_FetchMessages_Concurrent tests that concurrent pollers never claim the same record.
+func TestOutboxMemoryRepository_FetchMessages_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(100))
+	assert.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[int64]int)
+	)
+
+	for poller := 0; poller < 4; poller++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				records, err := repo.FetchMessages(ctx, 7)
+				if !assert.NoError(t, err) || len(records) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, record := range records {
+					claimed[record.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 100)
+	for id, count := range claimed {
+		assert.Equalf(t, 1, count, "record %d claimed more than once", id)
+	}
+}
+
+// TestOutboxMemoryRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxMemoryRepository_FetchMessages_OrderingKey(t *testing.T) {
+	testFetchMessagesOrderingKey(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxMemoryRepository_FetchMessages_Scheduled(t *testing.T) {
+	testFetchMessagesScheduled(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxMemoryRepository_MarkAsExpired(t *testing.T) {
+	testMarkAsExpired(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_ReturnsCopies tests that changing a returned record leaves the stored record unchanged.
+func TestOutboxMemoryRepository_ReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("")
+
+	var (
+		expiresAt = time.Now().Add(time.Hour)
+		stored    = expiresAt
+		records   = newPendingRecords(1)
+	)
+	records[0].ExpiresAt = &stored
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		*claimed[0].ExpiresAt = time.Time{}
+		*claimed[0].LockedBy = "another-node"
+	}
+
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{1}, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, expiresAt, *listed[0].ExpiresAt)
+		assert.NotEqual(t, "another-node", *listed[0].LockedBy)
+	}
+}
+
+// TestOutboxMemoryRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxMemoryRepository_FetchMessages_Priority(t *testing.T) {
+	testFetchMessagesPriority(t, NewOutboxMemoryRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}))
+}
+
+// TestOutboxMemoryRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxMemoryRepository_FetchMessages_Starvation(t *testing.T) {
+	testFetchMessagesStarvation(t, NewOutboxMemoryRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}))
+}
+
+// TestOutboxMemoryRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxMemoryRepository_FetchMessages_StarvedPriority(t *testing.T) {
+	testFetchMessagesStarvedPriority(t, NewOutboxMemoryRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}))
+}
+
+// TestOutboxMemoryRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxMemoryRepository_FinishedMessages(t *testing.T) {
+	testFinishedMessages(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxMemoryRepository_AdminQueries(t *testing.T) {
+	testAdminQueries(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxMemoryRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+	testRequeueDeadLettersExpiry(t, newMemoryInstance(""))
+}
+
+// TestOutboxMemoryRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxMemoryRepository_AcknowledgeClaimLost(t *testing.T) {
+	repo := newMemoryInstance("node-1").(*outboxMemoryRepository)
+	peer := &outboxMemoryRepository{
+		records: repo.records,
+		keys:    repo.keys,
+		setting: RepoSetting{TableName: "outbox", NodeID: "node-2"},
+	}
+	testAcknowledgeClaimLost(t, repo, peer)
+}
+
+// TestOutboxMemoryRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxMemoryRepository_NewRecords_Deduplication(t *testing.T) {
+	testNewRecordsDeduplication(t, newMemoryInstance(""))
+	testNewRecordsDeduplicationWindow(t, NewOutboxMemoryRepository(RepoSetting{
+		TableName:           "outbox",
+		DeduplicationWindow: time.Hour,
+	}))
+}
+
+// TestOutboxMemoryRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed, MarkAsRetry and Release of OutboxMemoryRepository.
+func TestOutboxMemoryRepository_Acknowledge(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("node-1")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(5))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 5)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 5)
+
+	// the claimed copies are not shared with the repository
+	claimed[0].State = dto.OutboxStateFailed
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 4, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 5, "timeout", time.Now().Add(-time.Second)))
+
+	claimed, err = repo.FetchMessages(ctx, 5)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+
+		assert.Equal(t, int64(5), claimed[1].ID)
+		if assert.NotNil(t, claimed[1].NumberOfAttempts) {
+			assert.Equal(t, int64(1), *claimed[1].NumberOfAttempts)
+		}
+		if assert.NotNil(t, claimed[1].Error) {
+			assert.Equal(t, "timeout", *claimed[1].Error)
+		}
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+	_, err = repo.NewRecordsTx(ctx, nil, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxMemoryRepository_DeadLetters tests listing, inspecting, requeueing and purging dead letters of OutboxMemoryRepository.
+func TestOutboxMemoryRepository_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("")
+
+	records := newPendingRecords(4)
+	records[3].DriverName = "http"
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 4)
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 1, "poison"))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 2, "poison"))
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 4, "poison"))
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 2, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, int64(2), deadLetters[0].ID)
+		assert.Equal(t, int64(4), deadLetters[1].ID)
+	}
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	claimed, err = repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+}
+
+// TestOutboxMemoryRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxMemoryRepository_ReleaseStale(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemoryInstance("")
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		lockedBy  = "node-1"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &lockedBy
+	}
+	records[2].LockedAt = &lockedAt
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+}
@@ -0,0 +1,292 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+)
+
+const (
+	// migrationsTableSuffix names the table tracking the applied migrations of an outbox table
+	migrationsTableSuffix = "_migrations"
+)
+
+// tableNamePattern matches the table names the migrations accept, the first migrations use
+// the table name unquoted
+var tableNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// migration is a versioned schema change of an outbox table
+type migration struct {
+	version     int
+	description string
+	up          func(d IDialect, table string) []string
+}
+
+// migrations are applied in order, a released migration must never be edited,
+// schema changes are appended as new versions
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create outbox table",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+					id BIGINT PRIMARY KEY,
+					driver_name VARCHAR(255) NOT NULL,
+					payload TEXT NOT NULL,
+					state VARCHAR(32) NOT NULL,
+					created_at TIMESTAMP NOT NULL,
+					locked_at TIMESTAMP,
+					locked_by VARCHAR(255),
+					last_attempted_at TIMESTAMP,
+					number_of_attempts INTEGER,
+					error TEXT,
+					next_attempt_at TIMESTAMP
+				)`, table),
+			}
+		},
+	},
+	{
+		version:     2,
+		description: "create fetch, claim and dead letter indexes",
+		up: func(d IDialect, table string) []string {
+			// shipped before mysql was supported, which has no partial indexes, version 11
+			// creates the indexes of mysql tables
+			if d.Name() == MySQL.Name() {
+				return nil
+			}
+			return []string{
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (created_at, id) WHERE state = 'PENDING'`, indexName(table, "pending"), table),
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (locked_at) WHERE state = 'IN_PROGRESS'`, indexName(table, "in_progress"), table),
+				fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %s ON %s (state, driver_name)`, indexName(table, "state_driver"), table),
+			}
+		},
+	},
+	{
+		version:     3,
+		description: "add message key, event type and headers",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN message_key VARCHAR(255) NOT NULL DEFAULT ''", d.Quote(table)),
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN event_type VARCHAR(255) NOT NULL DEFAULT ''", d.Quote(table)),
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN headers TEXT NULL", d.Quote(table)),
+			}
+		},
+	},
+	{
+		version:     4,
+		description: "add payload content type",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN content_type VARCHAR(255) NOT NULL DEFAULT ''", d.Quote(table)),
+			}
+		},
+	},
+	{
+		version:     5,
+		description: "add ordering key",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN ordering_key VARCHAR(255) NOT NULL DEFAULT ''", d.Quote(table)),
+				d.CreateIndex(indexName(table, "ordering"), d.Quote(table), "ordering_key, created_at, id", "state IN ('PENDING', 'IN_PROGRESS')"),
+			}
+		},
+	},
+	{
+		version:     6,
+		description: "add deduplication key",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN deduplication_key VARCHAR(255) NULL", d.Quote(table)),
+				fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (deduplication_key)", d.Quote(indexName(table, "deduplication")), d.Quote(table)),
+			}
+		},
+	},
+	{
+		version:     7,
+		description: "index scheduled messages",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				d.CreateIndex(indexName(table, "scheduled"), d.Quote(table), "next_attempt_at", "state = 'PENDING'"),
+			}
+		},
+	},
+	{
+		version:     8,
+		description: "add expires at",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN expires_at %s NULL", d.Quote(table), d.Timestamp()),
+			}
+		},
+	},
+	{
+		version:     9,
+		description: "add priority",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN priority INTEGER NOT NULL DEFAULT 0", d.Quote(table)),
+				d.CreateIndex(indexName(table, "priority"), d.Quote(table), "priority DESC, created_at, id", "state = 'PENDING'"),
+			}
+		},
+	},
+	{
+		version:     10,
+		description: "index finished messages",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				d.CreateIndex(indexName(table, "finished"), d.Quote(table), "state, last_attempted_at, created_at", ""),
+			}
+		},
+	},
+	{
+		version:     11,
+		description: "use the timestamp type and indexes of the dialect",
+		up: func(d IDialect, table string) []string {
+			// the tables of the other dialects got both from versions 1 and 2
+			if d.Name() != MySQL.Name() {
+				return nil
+			}
+			return []string{
+				fmt.Sprintf(`ALTER TABLE %[1]s
+					MODIFY created_at %[2]s NOT NULL,
+					MODIFY locked_at %[2]s NULL,
+					MODIFY last_attempted_at %[2]s NULL,
+					MODIFY next_attempt_at %[2]s NULL`, d.Quote(table), d.Timestamp()),
+				d.CreateIndex(indexName(table, "pending"), d.Quote(table), "created_at, id", "state = 'PENDING'"),
+				d.CreateIndex(indexName(table, "in_progress"), d.Quote(table), "locked_at", "state = 'IN_PROGRESS'"),
+				d.CreateIndex(indexName(table, "state_driver"), d.Quote(table), "state, driver_name", ""),
+			}
+		},
+	},
+	{
+		version:     12,
+		description: "hold deduplication keys in a separate column",
+		up: func(d IDialect, table string) []string {
+			return []string{
+				fmt.Sprintf("ALTER TABLE %s ADD COLUMN held_deduplication_key VARCHAR(255) NULL", d.Quote(table)),
+				fmt.Sprintf("UPDATE %s SET held_deduplication_key = deduplication_key", d.Quote(table)),
+				d.DropIndex(indexName(table, "deduplication"), table),
+				fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (held_deduplication_key)", d.Quote(indexName(table, "held_deduplication")), d.Quote(table)),
+			}
+		},
+	},
+}
+
+type IMigrator interface {
+	Migrate(ctx context.Context) error
+}
+
+// Migrate creates the outbox table of the repository and upgrades it to the latest version.
+// It is idempotent and safe to call from several nodes starting at the same time. The sql
+// repositories return constant.ErrInvalidTableName unless the table name is a plain lowercase
+// identifier.
+func Migrate(ctx context.Context, repo IRepository) error {
+	migrator, ok := repo.(IMigrator)
+	if !ok {
+		return constant.ErrMigrationUnsupported
+	}
+	return migrator.Migrate(ctx)
+}
+
+// migrateSQL applies the pending migrations of the table and records them in its migrations
+// table. A named lock of the dialect keyed by the table name is held meanwhile, so concurrently
+// starting nodes migrate one after another. Dialects with transactional DDL apply every
+// migration atomically. A table name the first migrations cannot use unquoted is refused.
+func migrateSQL(ctx context.Context, db *sql.DB, d IDialect, table string) (err error) {
+
+	if !tableNamePattern.MatchString(table) {
+		return constant.ErrInvalidTableName
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	unlock, err := d.Lock(ctx, conn, table+migrationsTableSuffix)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if unlockErr := unlock(); err == nil {
+			err = unlockErr
+		}
+	}()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	metaTable := d.Quote(table + migrationsTableSuffix)
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		version INTEGER PRIMARY KEY,
+		description VARCHAR(255) NOT NULL,
+		applied_at %s NOT NULL
+	)`, metaTable, d.Timestamp())); err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, tx, metaTable)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+
+		for _, statement := range m.up(d, table) {
+			if _, err = tx.ExecContext(ctx, statement); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+			}
+		}
+
+		statement := fmt.Sprintf("INSERT INTO %s (version, description, applied_at) VALUES (?, ?, ?)", metaTable)
+		if _, err = tx.ExecContext(ctx, rebind(d, statement), bindArgs(d, []any{m.version, m.description, time.Now()})...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// appliedVersions returns the versions recorded in the migrations table
+func appliedVersions(ctx context.Context, tx *sql.Tx, metaTable string) (map[int]bool, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT version FROM %s", metaTable))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+
+	return applied, rows.Err()
+}
+
+// indexName returns the name of an index of the table, a schema qualified table
+// name is flattened since index names can not be qualified
+func indexName(table, suffix string) string {
+	return strings.ReplaceAll(table, ".", "_") + "_" + suffix + "_idx"
+}
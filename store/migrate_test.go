@@ -0,0 +1,79 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMigrate_Idempotent tests that migrating an up to date table changes nothing.
+func TestMigrate_Idempotent(t *testing.T) {
+	repo, err := newDBSqlInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of OutboxSqlRepository")
+		return
+	}
+
+	assert.NoError(t, Migrate(context.Background(), repo))
+	assert.NoError(t, Migrate(context.Background(), repo))
+
+	var count int
+	err = sqlClient.QueryRow("SELECT COUNT(*) FROM outbox_migrations").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), count)
+}
+
+// TestMigrate_Concurrent tests that nodes starting at the same time migrate a fresh table once.
+func TestMigrate_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox_concurrent",
+	}, sqlxClient)
+
+	defer func() {
+		_, _ = sqlClient.Exec("DROP TABLE IF EXISTS outbox_concurrent")
+		_, _ = sqlClient.Exec("DROP TABLE IF EXISTS outbox_concurrent_migrations")
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, Migrate(ctx, repo))
+		}()
+	}
+	wg.Wait()
+
+	var count int
+	err := sqlClient.QueryRow("SELECT COUNT(*) FROM outbox_concurrent_migrations").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), count)
+
+	_, err = repo.NewRecords(ctx, newPendingRecords(1))
+	assert.NoError(t, err)
+}
+
+// TestMigrate_Gorm tests that the gorm repository migrates through its underlying connection.
+func TestMigrate_Gorm(t *testing.T) {
+	repo, err := newDBGormInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of GormStore: %v", err)
+		return
+	}
+	assert.NoError(t, Migrate(context.Background(), repo))
+}
+
+// TestMigrate_Redis tests that migrating a redis repository is a no-op.
+func TestMigrate_Redis(t *testing.T) {
+	repo, err := newOutboxRedisRepoInstance()
+	if err != nil {
+		assert.FailNowf(t, "failed to create new instance of OutboxRedisRepository", "%v", err)
+		return
+	}
+	assert.NoError(t, Migrate(context.Background(), repo))
+}
@@ -0,0 +1,148 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	testContainerMySQL "github.com/testcontainers/testcontainers-go/modules/mysql"
+)
+
+var (
+	mysqlClient *sql.DB
+	onceMySQL   sync.Once
+)
+
+// newMySQLTestContainerClient returns a client of a mysql container holding a migrated outbox table.
+func newMySQLTestContainerClient() (_ *sql.DB, err error) {
+	onceMySQL.Do(func() {
+		var (
+			ctx              = context.Background()
+			conn             *testContainerMySQL.MySQLContainer
+			connectionString string
+		)
+
+		conn, err = testContainerMySQL.Run(ctx, "mysql:8.0",
+			testContainerMySQL.WithDatabase("outbox"),
+			testContainerMySQL.WithUsername("outbox"),
+			testContainerMySQL.WithPassword("password"),
+		)
+		if err != nil {
+			return
+		}
+
+		connectionString, err = conn.ConnectionString(ctx, "parseTime=true")
+		if err != nil {
+			return
+		}
+
+		mysqlClient, err = sql.Open("mysql", connectionString)
+		if err != nil {
+			return
+		}
+
+		err = Migrate(ctx, NewOutboxSqlRepository(RepoSetting{TableName: "outbox", Dialect: MySQL}, mysqlClient))
+	})
+	return mysqlClient, err
+}
+
+// newMySQLInstance returns an OutboxSqlRepository of the mysql outbox table, the table is emptied after the test.
+func newMySQLInstance(tb testing.TB, setting RepoSetting) IRepository {
+	client, err := newMySQLTestContainerClient()
+	if err != nil || client == nil {
+		tb.Fatalf("failed to create a new instance of the mysql client: %v", err)
+	}
+	tb.Cleanup(func() {
+		if _, err := client.Exec("DELETE FROM outbox"); err != nil {
+			tb.Fatalf("failed to empty the mysql outbox table: %v", err)
+		}
+	})
+
+	setting.TableName = "outbox"
+	setting.Dialect = MySQL
+	return NewOutboxSqlRepository(setting, client)
+}
+
+// TestOutboxMySQLRepository_Migrate tests that migrating an up to date mysql table changes nothing.
+func TestOutboxMySQLRepository_Migrate(t *testing.T) {
+	repo := newMySQLInstance(t, RepoSetting{})
+	assert.NoError(t, Migrate(context.Background(), repo))
+
+	var count int
+	assert.NoError(t, mysqlClient.QueryRow("SELECT COUNT(*) FROM outbox_migrations").Scan(&count))
+	assert.Equal(t, len(migrations), count)
+}
+
+// TestOutboxMySQLRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxMySQLRepository_FetchMessages_OrderingKey(t *testing.T) {
+	testFetchMessagesOrderingKey(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxMySQLRepository_FetchMessages_Scheduled(t *testing.T) {
+	testFetchMessagesScheduled(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxMySQLRepository_MarkAsExpired(t *testing.T) {
+	testMarkAsExpired(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_MessageMetadata tests that the key, event type and headers of the records are kept.
+func TestOutboxMySQLRepository_MessageMetadata(t *testing.T) {
+	testMessageMetadata(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxMySQLRepository_FetchMessages_Priority(t *testing.T) {
+	testFetchMessagesPriority(t, newMySQLInstance(t, RepoSetting{StarvationTimeout: -1}))
+}
+
+// TestOutboxMySQLRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxMySQLRepository_FetchMessages_Starvation(t *testing.T) {
+	testFetchMessagesStarvation(t, newMySQLInstance(t, RepoSetting{StarvationTimeout: 100 * time.Millisecond}))
+}
+
+// TestOutboxMySQLRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxMySQLRepository_FetchMessages_StarvedPriority(t *testing.T) {
+	testFetchMessagesStarvedPriority(t, newMySQLInstance(t, RepoSetting{StarvationTimeout: 100 * time.Millisecond}))
+}
+
+// TestOutboxMySQLRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxMySQLRepository_FinishedMessages(t *testing.T) {
+	testFinishedMessages(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxMySQLRepository_AdminQueries(t *testing.T) {
+	testAdminQueries(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxMySQLRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+	testRequeueDeadLettersExpiry(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxMySQLRepository_AcknowledgeClaimLost(t *testing.T) {
+	testAcknowledgeClaimLost(t,
+		newMySQLInstance(t, RepoSetting{NodeID: "node-1"}),
+		newMySQLInstance(t, RepoSetting{NodeID: "node-2"}),
+	)
+}
+
+// TestOutboxMySQLRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxMySQLRepository_NewRecords_Deduplication(t *testing.T) {
+	testNewRecordsDeduplication(t, newMySQLInstance(t, RepoSetting{}))
+}
+
+// TestOutboxMySQLRepository_NewRecords_DeduplicationWindow tests that deduplication keys are released after the window.
+func TestOutboxMySQLRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+	testNewRecordsDeduplicationWindow(t, newMySQLInstance(t, RepoSetting{DeduplicationWindow: time.Hour}))
+}
@@ -3,15 +3,261 @@ package store
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	ttlKeyExpired = -1
+	// maxWatchRetries bounds the optimistic transactions retried after a concurrent write
+	maxWatchRetries = 10
+	// scanCount is the number of hash fields asked for by every HSCAN call
+	scanCount = 500
+)
+
+const (
+	// pendingKeySuffix names the sorted set of claimable record ids scored by
+	// the unix milliseconds they become eligible at, their next attempt or creation time.
+	// Records of a non-zero priority are indexed in the set suffixed with ":<priority>".
+	pendingKeySuffix = ":pending"
+	// prioritiesKeySuffix names the sorted set of the priorities records are pending with
+	prioritiesKeySuffix = ":priorities"
+	// inProgressKeySuffix names the sorted set of claimed record ids scored by
+	// the unix milliseconds they were locked at
+	inProgressKeySuffix = ":in_progress"
+	// deadLetteredKeySuffix names the sorted set of dead-lettered record ids scored by
+	// the unix milliseconds of their last attempt
+	deadLetteredKeySuffix = ":dead_lettered"
+	// finishedKeySuffix prefixes the sorted sets of the succeeded, failed and expired record ids
+	// of a state scored by the unix milliseconds they finished at
+	finishedKeySuffix = ":finished:"
+	// orderingKeySuffix prefixes the sorted sets of the pending and in-progress record ids
+	// of an ordering key scored by the unix milliseconds they were created at
+	orderingKeySuffix = ":ordering:"
+	// parkedKeySuffix names the sorted set of pending record ids waiting for an earlier record
+	// of their ordering key scored by the unix milliseconds they are due at
+	parkedKeySuffix = ":parked"
+	// deduplicationKeySuffix prefixes the keys holding the id of the record that took a
+	// deduplication key, they expire with the deduplication window
+	deduplicationKeySuffix = ":deduplication:"
 )
 
+// priorityKeyLua defines the lua function priorityKey, it returns the key suffixed with the
+// priority of the record id stored in the hash, or the key itself for the zero priority, and
+// the priority.
+const priorityKeyLua = `
+local function priorityKey(hash, id, key)
+	local jRecord = redis.call('HGET', hash, id)
+	local priority = jRecord and tonumber(cjson.decode(jRecord).priority) or 0
+	if priority == 0 then
+		return key, priority
+	end
+	return key .. ':' .. priority, priority
+end
+`
+
+// claimScript atomically moves up to ARGV[2] eligible ids from the pending sets of the
+// priorities (KEYS[1]) to the in-progress set (KEYS[2]) and returns their records from the
+// hash (KEYS[3]). ARGV[1] is the current time in unix milliseconds and ARGV[4] the pending set
+// of the zero priority, the others are suffixed with their priority. Ids eligible since ARGV[3]
+// are starved and claimed first, the others by descending priority. ARGV[3] is empty when
+// priorities are strict.
+var claimScript = redis.NewScript(`
+local limit = tonumber(ARGV[2])
+local claimed = {}
+local function pendingKey(priority)
+	if tonumber(priority) == 0 then
+		return ARGV[4]
+	end
+	return ARGV[4] .. ':' .. priority
+end
+local function claim(key, id)
+	redis.call('ZREM', key, id)
+	redis.call('ZADD', KEYS[2], ARGV[1], id)
+	claimed[#claimed + 1] = id
+end
+redis.call('ZADD', KEYS[1], 0, '0')
+local priorities = redis.call('ZREVRANGE', KEYS[1], 0, -1)
+if ARGV[3] ~= '' then
+	local starved = {}
+	for _, priority in ipairs(priorities) do
+		local key = pendingKey(priority)
+		local found = redis.call('ZRANGEBYSCORE', key, '-inf', ARGV[3], 'WITHSCORES', 'LIMIT', 0, limit)
+		for i = 1, #found, 2 do
+			starved[#starved + 1] = {key = key, id = found[i], score = tonumber(found[i + 1])}
+		end
+	end
+	table.sort(starved, function(a, b)
+		return a.score < b.score
+	end)
+	for i = 1, math.min(#starved, limit) do
+		claim(starved[i].key, starved[i].id)
+	end
+end
+for _, priority in ipairs(priorities) do
+	local key = pendingKey(priority)
+	if #claimed < limit then
+		for _, id in ipairs(redis.call('ZRANGEBYSCORE', key, '-inf', ARGV[1], 'LIMIT', 0, limit - #claimed)) do
+			claim(key, id)
+		end
+	end
+	if priority ~= '0' and redis.call('ZCARD', key) == 0 then
+		redis.call('ZREM', KEYS[1], priority)
+	end
+end
+if #claimed == 0 then
+	return {}
+end
+return redis.call('HMGET', KEYS[3], unpack(claimed))
+`)
+
+// orderLua adds the record ARGV[1] created at ARGV[2] to its ordering set (KEYS[1]) and
+// indexes it in the pending set (KEYS[3]) with the score ARGV[3] when it is the earliest
+// record of the set, it is parked (KEYS[2]) with the same score otherwise.
+const orderLua = `
+redis.call('ZADD', KEYS[1], 'NX', ARGV[2], ARGV[1])
+local head = redis.call('ZRANGE', KEYS[1], 0, 0)
+if head[1] ~= ARGV[1] then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+	return 0
+end
+redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+return 1
+`
+
+// unorderLua removes the finished record ARGV[1] from its ordering set (KEYS[1]) and moves
+// the next record of the set from the parked set (KEYS[2]) to the pending set of its priority,
+// keeping the time it is due at. The priority is read from the record in the hash (KEYS[4]) and
+// registered in the priorities set (KEYS[3]), ARGV[2] is the pending set of the zero priority.
+// It needs priorityKeyLua.
+const unorderLua = `
+redis.call('ZREM', KEYS[1], ARGV[1])
+local head = redis.call('ZRANGE', KEYS[1], 0, 0)
+if not head[1] then
+	return 0
+end
+local dueAt = redis.call('ZSCORE', KEYS[2], head[1])
+if not dueAt then
+	return 0
+end
+redis.call('ZREM', KEYS[2], head[1])
+local key, priority = priorityKey(KEYS[4], head[1], ARGV[2])
+redis.call('ZADD', KEYS[3], priority, priority)
+redis.call('ZADD', key, dueAt, head[1])
+return 0
+`
+
+var (
+	// orderScript runs orderLua
+	orderScript = redis.NewScript(orderLua)
+	// unorderScript runs unorderLua
+	unorderScript = redis.NewScript(priorityKeyLua + unorderLua)
+)
+
+// indexScripts are the scripts index commands run by name, see commands
+var indexScripts = map[string]*redis.Script{
+	"order":          orderScript,
+	"unorder":        unorderScript,
+	"stream_order":   streamOrderScript,
+	"stream_unorder": streamUnorderScript,
+}
+
+// casScript overwrites the record ARGV[1] of the hash (KEYS[1]) with ARGV[3] when it is still
+// stored as ARGV[2], the record the caller read, and runs the index commands following them. A
+// record claimed again or changed meanwhile is left as it is and 0 returned. Every command is
+// preceded by its number of arguments, a command naming one of indexScripts runs its script with
+// the number of keys, keys and arguments following the name.
+var casScript = redis.NewScript(priorityKeyLua + `
+local scripts = {
+	order = function(KEYS, ARGV)` + orderLua + `end,
+	unorder = function(KEYS, ARGV)` + unorderLua + `end,
+	stream_order = function(KEYS, ARGV)` + streamOrderLua + `end,
+	stream_unorder = function(KEYS, ARGV)` + streamUnorderLua + `end,
+}
+if redis.call('HGET', KEYS[1], ARGV[1]) ~= ARGV[2] then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+local i = 4
+while i <= #ARGV do
+	local command = {unpack(ARGV, i + 1, i + tonumber(ARGV[i]))}
+	local script = scripts[command[1]]
+	if script then
+		local keys = tonumber(command[2])
+		script({unpack(command, 3, 2 + keys)}, {unpack(command, 3 + keys)})
+	else
+		redis.call(unpack(command))
+	end
+	i = i + tonumber(ARGV[i]) + 1
+end
+return 1
+`)
+
+// commands queues the redis commands changing the indexes of a record, either on a pipeline
+// or as the arguments of casScript
+type commands interface {
+	// call queues a redis command
+	call(ctx context.Context, args ...any)
+	// run queues the index script of the name, see indexScripts
+	run(ctx context.Context, name string, keys []string, args ...any)
+}
+
+// pipeCommands queues the commands on a pipeline
+type pipeCommands struct {
+	pipe redis.Pipeliner
+}
+
+func (c pipeCommands) call(ctx context.Context, args ...any) {
+	c.pipe.Do(ctx, args...)
+}
+
+func (c pipeCommands) run(ctx context.Context, name string, keys []string, args ...any) {
+	indexScripts[name].Eval(ctx, c.pipe, keys, args...)
+}
+
+// scriptCommands collects the commands as arguments of casScript
+type scriptCommands []any
+
+func (c *scriptCommands) call(_ context.Context, args ...any) {
+	*c = append(append(*c, len(args)), args...)
+}
+
+func (c *scriptCommands) run(ctx context.Context, name string, keys []string, args ...any) {
+	command := make([]any, 0, len(keys)+len(args)+2)
+	command = append(command, name, len(keys))
+	for _, key := range keys {
+		command = append(command, key)
+	}
+	c.call(ctx, append(command, args...)...)
+}
+
+// compareAndSet overwrites the record of the hash member read as previous with record and runs
+// the commands in a single casScript, it returns constant.ErrClaimLost when the stored record
+// changed since it was read
+func compareAndSet(ctx context.Context, client *redis.Client, hash, member, previous string, record dto.Outbox, commands scriptCommands) error {
+	jRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	args := append([]any{member, previous, string(jRecord)}, commands...)
+	stored, err := casScript.Run(ctx, client, []string{hash}, args...).Int()
+	if err != nil {
+		return err
+	}
+	if stored == 0 {
+		return constant.ErrClaimLost
+	}
+	return nil
+}
+
 type outboxRedisRepository struct {
 	instance *redis.Client
 	setting  RepoSetting
@@ -29,30 +275,944 @@ func (o outboxRedisRepository) GetTableName() string {
 	return o.setting.TableName
 }
 
-// NewRecords insert new records to outbox table
-func (o outboxRedisRepository) NewRecords(ctx context.Context, records []dto.Outbox) error {
+// pendingKey get a key name for the pending index of a priority
+func (o outboxRedisRepository) pendingKey(priority int) string {
+	if priority == 0 {
+		return o.GetTableName() + pendingKeySuffix
+	}
+	return o.GetTableName() + pendingKeySuffix + ":" + strconv.Itoa(priority)
+}
+
+// prioritiesKey get a key name for the index of the pending priorities
+func (o outboxRedisRepository) prioritiesKey() string {
+	return o.GetTableName() + prioritiesKeySuffix
+}
+
+// inProgressKey get a key name for the in-progress index
+func (o outboxRedisRepository) inProgressKey() string {
+	return o.GetTableName() + inProgressKeySuffix
+}
+
+// deadLetteredKey get a key name for the dead letters index
+func (o outboxRedisRepository) deadLetteredKey() string {
+	return o.GetTableName() + deadLetteredKeySuffix
+}
+
+// finishedKey get the key name of the sorted set indexing the finished records of the state,
+// dead-lettered records are indexed by the dead letters set
+func (o outboxRedisRepository) finishedKey(state dto.OutboxStateEnum) string {
+	if state == dto.OutboxStateDeadLettered {
+		return o.deadLetteredKey()
+	}
+	return o.GetTableName() + finishedKeySuffix + strings.ToLower(string(state))
+}
+
+// orderingKey get a key name for the index of an ordering key
+func (o outboxRedisRepository) orderingKey(key string) string {
+	return o.GetTableName() + orderingKeySuffix + key
+}
+
+// parkedKey get a key name for the parked records index
+func (o outboxRedisRepository) parkedKey() string {
+	return o.GetTableName() + parkedKeySuffix
+}
+
+// deduplicationKey get a key name for the holder of a deduplication key
+func (o outboxRedisRepository) deduplicationKey(key string) string {
+	return o.GetTableName() + deduplicationKeySuffix + key
+}
+
+// indexKeys get the key names of every index a record of the priority can be in
+func (o outboxRedisRepository) indexKeys(priority int) []string {
+	return []string{o.pendingKey(priority), o.inProgressKey(), o.deadLetteredKey()}
+}
+
+// Migrate is a no-op, redis keys need no schema
+func (o outboxRedisRepository) Migrate(ctx context.Context) error {
+	return nil
+}
+
+// NewRecords insert new records to outbox table, it returns the ids of the records dropped
+// because another record holds their deduplication key. The keys are watched, so two nodes
+// adding the same key concurrently never both store it.
+func (o outboxRedisRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
+	var dropped []int64
+
+	err := watch(ctx, o.instance, func(tx *redis.Tx) error {
+		accepted, skipped, err := deduplicate(ctx, tx, o.GetTableName(), o.deduplicationKey, records)
+		if err != nil {
+			return err
+		}
+		dropped = skipped
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return o.insertRecords(ctx, pipe, accepted)
+		})
+		return err
+	}, deduplicationKeys(o.deduplicationKey, records)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return dropped, nil
+}
+
+// NewRecordsTx queue new records on the caller's redis.Pipeliner, they are written
+// when the caller executes its MULTI/EXEC pipeline. Records whose deduplication key is
+// held when they are queued are dropped and their ids returned.
+func (o outboxRedisRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	pipe, ok := tx.(redis.Pipeliner)
+	if !ok {
+		return nil, constant.ErrUnsupportedTx
+	}
+
+	accepted, dropped, err := deduplicate(ctx, o.instance, o.GetTableName(), o.deduplicationKey, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return dropped, o.insertRecords(ctx, pipe, accepted)
+}
+
+// insertRecords queue records, their indexes and deduplication keys on the given pipeline
+func (o outboxRedisRepository) insertRecords(ctx context.Context, pipe redis.Pipeliner, records []dto.Outbox) error {
+	for _, record := range records {
+		jRecord, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		if err := pipe.HSet(ctx, o.GetTableName(), record.ID, string(jRecord)).Err(); err != nil {
+			return err
+		}
+
+		o.index(ctx, pipeCommands{pipe}, record)
+
+		if record.DeduplicationKey != nil {
+			reserveKey(ctx, pipe, o.deduplicationKey(*record.DeduplicationKey), record, o.setting.DeduplicationWindow)
+		}
+	}
+	return nil
+}
 
-	// Start transaction to insert multiple records
-	if _, err := o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+// FetchMessages claims up to limit pending records whose next attempt is due, highest
+// priority first, and marks them as in progress for this node. The claim itself is a single atomic script, so
+// concurrent nodes never receive the same record.
+func (o outboxRedisRepository) FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error) {
+
+	lockedAt := time.Now()
+	lockedBy := o.setting.lockedBy()
+
+	var starvedBefore string
+	if at := o.setting.starvedBefore(lockedAt); !at.IsZero() {
+		starvedBefore = strconv.FormatInt(at.UnixMilli(), 10)
+	}
+
+	values, err := claimScript.Run(ctx, o.instance,
+		[]string{o.prioritiesKey(), o.inProgressKey(), o.GetTableName()},
+		lockedAt.UnixMilli(), limit, starvedBefore, o.pendingKey(0),
+	).Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(values))
+	for _, value := range values {
+		// the record was removed from the hash while still indexed
+		jRecord, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var record dto.Outbox
+		if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+			return nil, err
+		}
+
+		record.State = dto.OutboxStateInProgress
+		record.LockedAt = &lockedAt
+		record.LockedBy = &lockedBy
+		records = append(records, record)
+	}
+
+	if len(records) == 0 {
+		return records, nil
+	}
+
+	if err := o.save(ctx, records...); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and releases its claim
+func (o outboxRedisRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateSucceed
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = nil
+	})
+}
+
+// MarkAsFailed marks the record as failed with the given reason and releases its claim
+func (o outboxRedisRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateFailed
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+	})
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and releases its claim
+func (o outboxRedisRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateDeadLettered
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+	})
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and releases its claim
+func (o outboxRedisRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStateExpired
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// MarkAsRetry records a failed attempt and returns the record to the pending state,
+// it is not fetched again before nextAttemptAt
+func (o outboxRedisRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+		record.NextAttemptAt = &nextAttemptAt
+	})
+}
+
+// Release returns the record to the pending state and clears its claim
+func (o outboxRedisRepository) Release(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// update applies fn to the stored record and moves it to the index of its new state. The record
+// is compared and set in a single script, so that a record reclaimed and fetched by another node
+// meanwhile is left to its new owner.
+func (o outboxRedisRepository) update(ctx context.Context, id int64, fn func(record *dto.Outbox)) error {
+	member := strconv.FormatInt(id, 10)
+
+	jRecord, err := o.instance.HGet(ctx, o.GetTableName(), member).Result()
+	if errors.Is(err, redis.Nil) {
+		return constant.ErrClaimLost
+	}
+	if err != nil {
+		return err
+	}
+
+	var record dto.Outbox
+	if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+		return err
+	}
+	if !claimedBy(record, o.setting.lockedBy()) {
+		return constant.ErrClaimLost
+	}
+
+	fn(&record)
+
+	var commands scriptCommands
+	o.reindex(ctx, &commands, record)
+	return compareAndSet(ctx, o.instance, o.GetTableName(), member, jRecord, record, commands)
+}
+
+// ReleaseStale returns records locked before lockedBefore to the pending state, counting the
+// interrupted attempt. Ids claimed before lockedBefore whose record was never saved as in progress
+// are indexed as pending again. It reports how many records were released. The records are rewritten
+// in an optimistic transaction, so a node acknowledging a record meanwhile is never overwritten.
+func (o outboxRedisRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	var released int64
+
+	release := func(tx *redis.Tx) error {
+		members, err := tx.ZRangeByScore(ctx, o.inProgressKey(), &redis.ZRangeBy{
+			Min: "-inf",
+			Max: "(" + strconv.FormatInt(lockedBefore.UnixMilli(), 10),
+		}).Result()
+		if err != nil {
+			return err
+		}
+
+		records, err := o.load(ctx, tx, members)
+		if err != nil {
+			return err
+		}
+
+		stale := make([]dto.Outbox, 0, len(records))
 		for _, record := range records {
+			switch {
+			case record.State == dto.OutboxStatePending:
+				// the node crashed between claiming the id and saving the record,
+				// it is indexed as pending again without counting an attempt
+				stale = append(stale, record)
+			case record.State == dto.OutboxStateInProgress && record.LockedAt != nil && record.LockedAt.Before(lockedBefore):
+				record.State = dto.OutboxStatePending
+				record.LockedAt = nil
+				record.LockedBy = nil
+				record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+				stale = append(stale, record)
+			}
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, record := range stale {
+				jRecord, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+
+				pipe.HSet(ctx, o.GetTableName(), strconv.FormatInt(record.ID, 10), string(jRecord))
+				o.reindex(ctx, pipeCommands{pipe}, record)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		released = int64(len(stale))
+		return nil
+	}
+
+	if err := watch(ctx, o.instance, release, o.GetTableName(), o.inProgressKey()); err != nil {
+		return 0, err
+	}
+	return released, nil
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o outboxRedisRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	records, err := o.deadLetters(ctx, driverName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(records, limit, offset), nil
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o outboxRedisRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	records, err := o.deadLetters(ctx, "", []int64{id})
+	if err != nil {
+		return dto.Outbox{}, err
+	}
+	if len(records) == 0 {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return records[0], nil
+}
+
+// RequeueDeadLetters returns dead-lettered records of the driver to the pending state with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o outboxRedisRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	records, err := o.deadLetters(ctx, driverName, ids)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, record := range records {
+			requeue(&record)
+
 			jRecord, err := json.Marshal(record)
 			if err != nil {
 				return err
 			}
 
-			if err := pipe.HSet(ctx, o.GetTableName(), record.ID, string(jRecord)).Err(); err != nil {
-				return err
+			pipe.HSet(ctx, o.GetTableName(), strconv.FormatInt(record.ID, 10), string(jRecord))
+			o.reindex(ctx, pipeCommands{pipe}, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(records)), nil
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o outboxRedisRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	records, err := o.deadLetters(ctx, driverName, ids)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	members := make([]string, 0, len(records))
+	for _, record := range records {
+		members = append(members, strconv.FormatInt(record.ID, 10))
+	}
+
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, o.GetTableName(), members...)
+		pipe.ZRem(ctx, o.deadLetteredKey(), members)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(records)), nil
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o outboxRedisRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	keys := make([]string, 0, len(states))
+	for _, state := range states {
+		keys = append(keys, o.finishedKey(state))
+	}
+
+	members, err := finishedMembers(ctx, o.instance, keys, finishedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return nil, err
+	}
+	return finishedRecords(records, states, finishedBefore, limit), nil
+}
+
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o outboxRedisRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, strconv.FormatInt(id, 10))
+	}
+
+	records, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, record := range records {
+			if !inStates(record.State, states) {
+				continue
 			}
+			member := strconv.FormatInt(record.ID, 10)
+			pipe.HDel(ctx, o.GetTableName(), member)
+			pipe.ZRem(ctx, o.finishedKey(record.State), member)
+			deleted++
 		}
 		return nil
-	}); err != nil {
-		return err
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	// Set expiration for the key to -1
-	if err := o.instance.Expire(ctx, o.GetTableName(), ttlKeyExpired).Err(); err != nil {
+	return deleted, nil
+}
+
+// ListMessages lists the records selected by the filter ordered by id. Without ids the whole
+// hash is scanned, so it suits the occasional inspection by an operator.
+func (o outboxRedisRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	if len(filter.IDs) == 0 {
+		records, err := scanRecords(ctx, o.instance, o.GetTableName(), filter)
+		if err != nil {
+			return nil, err
+		}
+		return paginate(records, filter.Limit, filter.Offset), nil
+	}
+
+	records, err := o.load(ctx, o.instance, idMembers(filter.IDs))
+	if err != nil {
+		return nil, err
+	}
+	return paginate(matchRecords(records, filter), filter.Limit, filter.Offset), nil
+}
+
+// Stats counts the records of every driver and state, the whole hash is scanned
+func (o outboxRedisRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	records, err := scanRecords(ctx, o.instance, o.GetTableName(), dto.MessageFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return countMessages(records), nil
+}
+
+// RetryMessages makes the pending records of the ids waiting for their next attempt due right away.
+// It reports how many records were rescheduled.
+func (o outboxRedisRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	now := time.Now()
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending || record.NextAttemptAt == nil || !record.NextAttemptAt.After(now) {
+			return false
+		}
+		record.NextAttemptAt = nil
+		return true
+	})
+}
+
+// RequeueMessages returns the records of the ids that are in one of the states to the pending state
+// with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o outboxRedisRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if !inStates(record.State, states) {
+			return false
+		}
+		requeue(record)
+		return true
+	})
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, so they are never delivered.
+// It reports how many records were canceled.
+func (o outboxRedisRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending {
+			return false
+		}
+		record.State = dto.OutboxStateCanceled
+		return true
+	})
+}
+
+// transition applies fn to the stored records of the ids and moves the records it changed from
+// the index of their previous state to the one of their new state. The records are rewritten in
+// an optimistic transaction, so a node claiming a record meanwhile is never overwritten. It
+// reports how many records were changed.
+func (o outboxRedisRepository) transition(ctx context.Context, ids []int64, fn func(record *dto.Outbox) bool) (int64, error) {
+	var changed int64
+
+	apply := func(tx *redis.Tx) error {
+		records, err := o.load(ctx, tx, idMembers(ids))
+		if err != nil {
+			return err
+		}
+
+		changed = 0
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, record := range records {
+				previous := record.State
+				if !fn(&record) {
+					continue
+				}
+
+				jRecord, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+
+				member := strconv.FormatInt(record.ID, 10)
+				pipe.HSet(ctx, o.GetTableName(), member, string(jRecord))
+				pipe.ZRem(ctx, o.parkedKey(), member)
+				if isFinished(previous) {
+					pipe.ZRem(ctx, o.finishedKey(previous), member)
+				}
+				o.reindex(ctx, pipeCommands{pipe}, record)
+				changed++
+			}
+			return nil
+		})
 		return err
 	}
 
-	return nil
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := watch(ctx, o.instance, apply, o.GetTableName()); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// deadLetters loads dead-lettered records of the driver and ids ordered by id,
+// every dead letter is loaded when no ids are given
+func (o outboxRedisRepository) deadLetters(ctx context.Context, driverName string, ids []int64) ([]dto.Outbox, error) {
+	var members []string
+	if len(ids) > 0 {
+		for _, id := range ids {
+			members = append(members, strconv.FormatInt(id, 10))
+		}
+	} else {
+		var err error
+		if members, err = o.instance.ZRange(ctx, o.deadLetteredKey(), 0, -1).Result(); err != nil {
+			return nil, err
+		}
+	}
+
+	records, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := make([]dto.Outbox, 0, len(records))
+	for _, record := range records {
+		if record.State != dto.OutboxStateDeadLettered {
+			continue
+		}
+		if driverName != "" && record.DriverName != driverName {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+
+	sort.Slice(filtered, func(i, j int) bool {
+		return filtered[i].ID < filtered[j].ID
+	})
+
+	return filtered, nil
+}
+
+// load returns the stored records of the given ids, missing ids are skipped
+func (o outboxRedisRepository) load(ctx context.Context, client redis.Cmdable, members []string) ([]dto.Outbox, error) {
+	if len(members) == 0 {
+		return nil, nil
+	}
+
+	values, err := client.HMGet(ctx, o.GetTableName(), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(values))
+	for _, value := range values {
+		jRecord, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var record dto.Outbox
+		if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// save overwrites the stored records without touching their indexes
+func (o outboxRedisRepository) save(ctx context.Context, records ...dto.Outbox) error {
+	values := make([]any, 0, len(records)*2)
+	for _, record := range records {
+		jRecord, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		values = append(values, strconv.FormatInt(record.ID, 10), string(jRecord))
+	}
+	return o.instance.HSet(ctx, o.GetTableName(), values...).Err()
+}
+
+// reindex removes the record id from every index and adds it to the one matching its state
+func (o outboxRedisRepository) reindex(ctx context.Context, c commands, record dto.Outbox) {
+	member := strconv.FormatInt(record.ID, 10)
+	for _, key := range o.indexKeys(record.Priority) {
+		c.call(ctx, "ZREM", key, member)
+	}
+	o.index(ctx, c, record)
+}
+
+// index adds the record id to the sorted set matching its state, a pending record of an
+// ordering key is parked until the earlier records of the key are finished
+func (o outboxRedisRepository) index(ctx context.Context, c commands, record dto.Outbox) {
+	member := strconv.FormatInt(record.ID, 10)
+
+	switch record.State {
+	case dto.OutboxStatePending:
+		eligibleAt := record.CreatedAt
+		if record.NextAttemptAt != nil {
+			eligibleAt = *record.NextAttemptAt
+		}
+		c.call(ctx, "ZADD", o.prioritiesKey(), record.Priority, record.Priority)
+		if record.OrderingKey != "" {
+			c.run(ctx, "order",
+				[]string{o.orderingKey(record.OrderingKey), o.parkedKey(), o.pendingKey(record.Priority)},
+				member, record.CreatedAt.UnixMilli(), eligibleAt.UnixMilli(),
+			)
+			return
+		}
+		c.call(ctx, "ZADD", o.pendingKey(record.Priority), eligibleAt.UnixMilli(), member)
+		return
+	case dto.OutboxStateInProgress:
+		lockedAt := record.CreatedAt
+		if record.LockedAt != nil {
+			lockedAt = *record.LockedAt
+		}
+		if record.OrderingKey != "" {
+			c.call(ctx, "ZADD", o.orderingKey(record.OrderingKey), "NX", record.CreatedAt.UnixMilli(), member)
+		}
+		c.call(ctx, "ZADD", o.inProgressKey(), lockedAt.UnixMilli(), member)
+		return
+	}
+
+	// the record is finished, the next record of its ordering key becomes claimable
+	if record.OrderingKey != "" {
+		c.run(ctx, "unorder",
+			[]string{o.orderingKey(record.OrderingKey), o.parkedKey(), o.prioritiesKey(), o.GetTableName()},
+			member, o.pendingKey(0),
+		)
+	}
+
+	c.call(ctx, "ZADD", o.finishedKey(record.State), record.FinishedAt().UnixMilli(), member)
+}
+
+// watch runs fn in an optimistic transaction watching the keys, it is retried while a
+// concurrent write to the keys fails it
+func watch(ctx context.Context, client *redis.Client, fn func(tx *redis.Tx) error, keys ...string) error {
+	for i := 0; i < maxWatchRetries; i++ {
+		err := client.Watch(ctx, fn, keys...)
+		if !errors.Is(err, redis.TxFailedErr) {
+			return err
+		}
+	}
+	return redis.TxFailedErr
+}
+
+// deduplicationKeys returns the key names of the deduplication keys of the records
+func deduplicationKeys(keyName func(key string) string, records []dto.Outbox) []string {
+	var keys []string
+	for _, record := range records {
+		if record.DeduplicationKey != nil {
+			keys = append(keys, keyName(*record.DeduplicationKey))
+		}
+	}
+	return keys
+}
+
+// reserveKey queues taking over the deduplication key for the record, the key expires once
+// the deduplication window passed since the record was created
+func reserveKey(ctx context.Context, pipe redis.Pipeliner, key string, record dto.Outbox, window time.Duration) {
+	args := redis.SetArgs{}
+	if window > 0 {
+		args.ExpireAt = record.CreatedAt.Add(window)
+	}
+	pipe.SetArgs(ctx, key, record.ID, args)
+}
+
+// deduplicate splits the records into the ones to insert and the ids of the ones dropped because
+// a record stored in the hash holds their deduplication key. Records holding their key are already
+// stored and skipped, a key held by a record that is gone is taken over.
+func deduplicate(ctx context.Context, client redis.Cmdable, hash string, keyName func(key string) string, records []dto.Outbox) ([]dto.Outbox, []int64, error) {
+	var (
+		accepted = make([]dto.Outbox, 0, len(records))
+		dropped  []int64
+		taken    = make(map[string]struct{})
+	)
+
+	for _, record := range records {
+		if record.DeduplicationKey == nil {
+			accepted = append(accepted, record)
+			continue
+		}
+
+		key := *record.DeduplicationKey
+		if _, ok := taken[key]; ok {
+			dropped = append(dropped, record.ID)
+			continue
+		}
+
+		holder, err := client.Get(ctx, keyName(key)).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, nil, err
+		}
+		if holder != "" {
+			stored, err := client.HExists(ctx, hash, holder).Result()
+			if err != nil {
+				return nil, nil, err
+			}
+			if stored && holder == strconv.FormatInt(record.ID, 10) {
+				continue
+			}
+			if stored {
+				dropped = append(dropped, record.ID)
+				continue
+			}
+		}
+
+		taken[key] = struct{}{}
+		accepted = append(accepted, record)
+	}
+
+	return accepted, dropped, nil
+}
+
+// incrementAttempts returns a new attempt counter one above the given one
+func incrementAttempts(attempts *int64) *int64 {
+	next := int64(1)
+	if attempts != nil {
+		next = *attempts + 1
+	}
+	return &next
+}
+
+// finishedMembers returns up to limit ids of each finished index of the keys that finished
+// before finishedBefore
+func finishedMembers(ctx context.Context, client redis.Cmdable, keys []string, finishedBefore time.Time, limit int) ([]string, error) {
+	var members []string
+	for _, key := range keys {
+		found, err := client.ZRangeByScore(ctx, key, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   "(" + strconv.FormatInt(finishedBefore.UnixMilli(), 10),
+			Count: int64(limit),
+		}).Result()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, found...)
+	}
+	return members, nil
+}
+
+// finishedRecords returns up to limit of the records in one of the states that finished before
+// finishedBefore ordered by id
+func finishedRecords(records []dto.Outbox, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) []dto.Outbox {
+	finished := make([]dto.Outbox, 0, len(records))
+	for _, record := range records {
+		if inStates(record.State, states) && record.FinishedAt().Before(finishedBefore) {
+			finished = append(finished, record)
+		}
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].ID < finished[j].ID
+	})
+	return paginate(finished, limit, 0)
+}
+
+// requeue returns the record to the pending state with a fresh retry budget and no expiry
+func requeue(record *dto.Outbox) {
+	record.State = dto.OutboxStatePending
+	record.LockedAt = nil
+	record.LockedBy = nil
+	record.NumberOfAttempts = nil
+	record.NextAttemptAt = nil
+	record.ExpiresAt = nil
+}
+
+// countMessages counts the records of every driver and state ordered by driver and state
+func countMessages(records []dto.Outbox) []dto.MessageStats {
+	counts := make(map[dto.MessageStats]int64)
+	for _, record := range records {
+		counts[dto.MessageStats{DriverName: record.DriverName, State: record.State}]++
+	}
+
+	stats := make([]dto.MessageStats, 0, len(counts))
+	for stat, count := range counts {
+		stat.Count = count
+		stats = append(stats, stat)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].DriverName != stats[j].DriverName {
+			return stats[i].DriverName < stats[j].DriverName
+		}
+		return stats[i].State < stats[j].State
+	})
+	return stats
+}
+
+// scanRecords returns every record stored in the hash that matches the filter ordered by id,
+// the hash is scanned in chunks so the server is never blocked by a large outbox
+func scanRecords(ctx context.Context, client redis.Cmdable, hash string, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	records := make([]dto.Outbox, 0)
+	iter := client.HScan(ctx, hash, 0, "", scanCount).Iterator()
+	for iter.Next(ctx) {
+		// the iterator yields the fields and values in turn
+		if !iter.Next(ctx) {
+			break
+		}
+
+		var record dto.Outbox
+		if err := json.Unmarshal([]byte(iter.Val()), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return matchRecords(records, filter), nil
+}
+
+// matchRecords returns the records selected by the filter ordered by id
+func matchRecords(records []dto.Outbox, filter dto.MessageFilter) []dto.Outbox {
+	matched := make([]dto.Outbox, 0, len(records))
+	for _, record := range records {
+		if filter.Match(record) {
+			matched = append(matched, record)
+		}
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].ID < matched[j].ID
+	})
+	return matched
+}
+
+// idMembers returns the sorted set members of the ids
+func idMembers(ids []int64) []string {
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, strconv.FormatInt(id, 10))
+	}
+	return members
+}
+
+// claimedBy reports whether the record is in progress under the claim of the node
+func claimedBy(record dto.Outbox, lockedBy string) bool {
+	return record.State == dto.OutboxStateInProgress && record.LockedBy != nil && *record.LockedBy == lockedBy
+}
+
+// isFinished reports whether the state is one a record is never delivered from again
+func isFinished(state dto.OutboxStateEnum) bool {
+	return state != dto.OutboxStatePending && state != dto.OutboxStateInProgress
+}
+
+// inStates reports whether the state is one of the states
+func inStates(state dto.OutboxStateEnum, states []dto.OutboxStateEnum) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// paginate returns the page of records selected by limit and offset
+func paginate(records []dto.Outbox, limit, offset int) []dto.Outbox {
+	if offset >= len(records) {
+		return []dto.Outbox{}
+	}
+	records = records[offset:]
+	if limit >= 0 && limit < len(records) {
+		records = records[:limit]
+	}
+	return records
 }
@@ -0,0 +1,1079 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// streamGroup is the consumer group every gbox node reads the stream with
+	streamGroup = "gbox"
+	// streamIDField is the stream entry field holding the record id
+	streamIDField = "id"
+	// streamReclaimBatch bounds the stale entries claimed by a single XAUTOCLAIM call
+	streamReclaimBatch = 100
+)
+
+const (
+	// streamKeySuffix names the stream of claimable record ids, records of a non-zero priority
+	// are published to the stream suffixed with ":<priority>"
+	streamKeySuffix = ":stream"
+	// recordsKeySuffix names the hash of records keyed by their id
+	recordsKeySuffix = ":records"
+	// entriesKeySuffix names the hash of the stream entry id each claimed record was delivered with
+	entriesKeySuffix = ":entries"
+	// delayedKeySuffix names the sorted set of record ids waiting for their next attempt,
+	// scored by its unix milliseconds
+	delayedKeySuffix = ":delayed"
+)
+
+// promoteScript atomically moves up to ARGV[2] ids whose next attempt is due from the
+// delayed set (KEYS[1]) to the stream of their priority. ARGV[1] is the current time in unix
+// milliseconds, ARGV[3] the entry field of the id and ARGV[4] the stream of the zero priority.
+// The priority is read from the record in the hash (KEYS[2]) and registered in the priorities
+// set (KEYS[3]).
+var promoteScript = redis.NewScript(priorityKeyLua + `
+local ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'LIMIT', 0, tonumber(ARGV[2]))
+for _, id in ipairs(ids) do
+	local key, priority = priorityKey(KEYS[2], id, ARGV[4])
+	redis.call('ZREM', KEYS[1], id)
+	redis.call('ZADD', KEYS[3], priority, priority)
+	redis.call('XADD', key, '*', ARGV[3], id)
+end
+return #ids
+`)
+
+// streamOrderLua adds the record ARGV[1] created at ARGV[2] to its ordering set (KEYS[1])
+// and, when it is the earliest record of the set, publishes it to the stream (KEYS[3]) or delays
+// it (KEYS[4]) until ARGV[3] when it is not zero. It is parked (KEYS[2]) scored by ARGV[3]
+// otherwise. ARGV[4] is the entry field of the id.
+const streamOrderLua = `
+redis.call('ZADD', KEYS[1], 'NX', ARGV[2], ARGV[1])
+local head = redis.call('ZRANGE', KEYS[1], 0, 0)
+if head[1] ~= ARGV[1] then
+	redis.call('ZADD', KEYS[2], ARGV[3], ARGV[1])
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call('ZADD', KEYS[4], ARGV[3], ARGV[1])
+else
+	redis.call('XADD', KEYS[3], '*', ARGV[4], ARGV[1])
+end
+return 1
+`
+
+// streamUnorderLua removes the finished record ARGV[1] from its ordering set (KEYS[1]) and
+// takes the next record of the set from the parked set (KEYS[2]), it is published to the stream
+// of its priority or delayed (KEYS[3]) when it is due after ARGV[3], the current time in unix
+// milliseconds. ARGV[2] is the entry field of the id and ARGV[4] the stream of the zero priority,
+// the priority is read from the record in the hash (KEYS[4]) and registered in the priorities
+// set (KEYS[5]). It needs priorityKeyLua.
+const streamUnorderLua = `
+redis.call('ZREM', KEYS[1], ARGV[1])
+local head = redis.call('ZRANGE', KEYS[1], 0, 0)
+if not head[1] then
+	return 0
+end
+local dueAt = redis.call('ZSCORE', KEYS[2], head[1])
+if not dueAt then
+	return 0
+end
+redis.call('ZREM', KEYS[2], head[1])
+if tonumber(dueAt) > tonumber(ARGV[3]) then
+	redis.call('ZADD', KEYS[3], dueAt, head[1])
+	return 0
+end
+local key, priority = priorityKey(KEYS[4], head[1], ARGV[4])
+redis.call('ZADD', KEYS[5], priority, priority)
+redis.call('XADD', key, '*', ARGV[2], head[1])
+return 0
+`
+
+var (
+	// streamOrderScript runs streamOrderLua
+	streamOrderScript = redis.NewScript(streamOrderLua)
+	// streamUnorderScript runs streamUnorderLua
+	streamUnorderScript = redis.NewScript(priorityKeyLua + streamUnorderLua)
+)
+
+type outboxRedisStreamRepository struct {
+	instance *redis.Client
+	setting  RepoSetting
+}
+
+// NewOutboxRedisStreamRepository returns a repository delivering records through a redis stream
+// read by the consumer group of every gbox node. A claimed record stays in the pending entries
+// of its node until it is acknowledged, so records of crashed nodes are recovered by ReleaseStale.
+func NewOutboxRedisStreamRepository(setting RepoSetting, instance *redis.Client) IRepository {
+	return &outboxRedisStreamRepository{
+		instance: instance,
+		setting:  setting,
+	}
+}
+
+// GetTableName get a key prefix for the redis keys
+func (o outboxRedisStreamRepository) GetTableName() string {
+	return o.setting.TableName
+}
+
+// streamKey get a key name for the stream of a priority
+func (o outboxRedisStreamRepository) streamKey(priority int) string {
+	if priority == 0 {
+		return o.GetTableName() + streamKeySuffix
+	}
+	return o.GetTableName() + streamKeySuffix + ":" + strconv.Itoa(priority)
+}
+
+// prioritiesKey get a key name for the index of the published priorities
+func (o outboxRedisStreamRepository) prioritiesKey() string {
+	return o.GetTableName() + prioritiesKeySuffix
+}
+
+// recordsKey get a key name for the records hash
+func (o outboxRedisStreamRepository) recordsKey() string {
+	return o.GetTableName() + recordsKeySuffix
+}
+
+// entriesKey get a key name for the claimed entries hash
+func (o outboxRedisStreamRepository) entriesKey() string {
+	return o.GetTableName() + entriesKeySuffix
+}
+
+// delayedKey get a key name for the delayed records index
+func (o outboxRedisStreamRepository) delayedKey() string {
+	return o.GetTableName() + delayedKeySuffix
+}
+
+// deadLetteredKey get a key name for the dead letters index
+func (o outboxRedisStreamRepository) deadLetteredKey() string {
+	return o.GetTableName() + deadLetteredKeySuffix
+}
+
+// finishedKey get the key name of the sorted set indexing the finished records of the state,
+// dead-lettered records are indexed by the dead letters set
+func (o outboxRedisStreamRepository) finishedKey(state dto.OutboxStateEnum) string {
+	if state == dto.OutboxStateDeadLettered {
+		return o.deadLetteredKey()
+	}
+	return o.GetTableName() + finishedKeySuffix + strings.ToLower(string(state))
+}
+
+// orderingKey get a key name for the index of an ordering key
+func (o outboxRedisStreamRepository) orderingKey(key string) string {
+	return o.GetTableName() + orderingKeySuffix + key
+}
+
+// parkedKey get a key name for the parked records index
+func (o outboxRedisStreamRepository) parkedKey() string {
+	return o.GetTableName() + parkedKeySuffix
+}
+
+// deduplicationKey get a key name for the holder of a deduplication key
+func (o outboxRedisStreamRepository) deduplicationKey(key string) string {
+	return o.GetTableName() + deduplicationKeySuffix + key
+}
+
+// Migrate creates the streams and their consumer group
+func (o outboxRedisStreamRepository) Migrate(ctx context.Context) error {
+	streams, err := o.streamKeys(ctx)
+	if err != nil {
+		return err
+	}
+	for _, stream := range streams {
+		if err := o.createGroup(ctx, stream); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createGroup creates the stream and its consumer group unless they exist
+func (o outboxRedisStreamRepository) createGroup(ctx context.Context, stream string) error {
+	err := o.instance.XGroupCreateMkStream(ctx, stream, streamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	return nil
+}
+
+// streamKeys get the key names of the streams records are published to, highest priority first
+func (o outboxRedisStreamRepository) streamKeys(ctx context.Context) ([]string, error) {
+	priorities, err := o.instance.ZRevRangeWithScores(ctx, o.prioritiesKey(), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		streams = make([]string, 0, len(priorities)+1)
+		zero    = false
+	)
+	for _, priority := range priorities {
+		// the stream of the zero priority is read even before a record is published to it
+		if !zero && priority.Score <= 0 {
+			streams = append(streams, o.streamKey(0))
+			zero = true
+		}
+		if priority.Score != 0 {
+			streams = append(streams, o.streamKey(int(priority.Score)))
+		}
+	}
+	if !zero {
+		streams = append(streams, o.streamKey(0))
+	}
+	return streams, nil
+}
+
+// NewRecords insert new records and publish the claimable ones to the stream, it returns the ids
+// of the records dropped because another record holds their deduplication key
+func (o outboxRedisStreamRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
+	var dropped []int64
+
+	err := watch(ctx, o.instance, func(tx *redis.Tx) error {
+		accepted, skipped, err := deduplicate(ctx, tx, o.recordsKey(), o.deduplicationKey, records)
+		if err != nil {
+			return err
+		}
+		dropped = skipped
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			return o.insertRecords(ctx, pipe, accepted)
+		})
+		return err
+	}, deduplicationKeys(o.deduplicationKey, records)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return dropped, nil
+}
+
+// NewRecordsTx queue new records on the caller's redis.Pipeliner, they are written
+// when the caller executes its MULTI/EXEC pipeline. Records whose deduplication key is
+// held when they are queued are dropped and their ids returned.
+func (o outboxRedisStreamRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	pipe, ok := tx.(redis.Pipeliner)
+	if !ok {
+		return nil, constant.ErrUnsupportedTx
+	}
+
+	accepted, dropped, err := deduplicate(ctx, o.instance, o.recordsKey(), o.deduplicationKey, records)
+	if err != nil {
+		return nil, err
+	}
+
+	return dropped, o.insertRecords(ctx, pipe, accepted)
+}
+
+// insertRecords queue records, their stream entries and deduplication keys on the given pipeline
+func (o outboxRedisStreamRepository) insertRecords(ctx context.Context, pipe redis.Pipeliner, records []dto.Outbox) error {
+	for _, record := range records {
+		jRecord, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		pipe.HSet(ctx, o.recordsKey(), strconv.FormatInt(record.ID, 10), string(jRecord))
+		o.place(ctx, pipeCommands{pipe}, record)
+
+		if record.DeduplicationKey != nil {
+			reserveKey(ctx, pipe, o.deduplicationKey(*record.DeduplicationKey), record, o.setting.DeduplicationWindow)
+		}
+	}
+	return nil
+}
+
+// FetchMessages claims up to limit pending records whose next attempt is due, highest priority
+// first, and marks them as in progress for this node. The records are read with XREADGROUP, so every
+// stream entry is delivered to a single node, and retried records join the stream once their next
+// attempt is due. Entries published before the starvation timeout are read ahead of the priorities.
+func (o outboxRedisStreamRepository) FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error) {
+	lockedAt := time.Now()
+	lockedBy := o.setting.lockedBy()
+
+	if err := promoteScript.Run(ctx, o.instance,
+		[]string{o.delayedKey(), o.recordsKey(), o.prioritiesKey()},
+		lockedAt.UnixMilli(), limit, streamIDField, o.streamKey(0),
+	).Err(); err != nil {
+		return nil, err
+	}
+
+	streams, err := o.streamKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// the starved entries are read first, then the streams by descending priority
+	counts, err := o.starved(ctx, streams, o.setting.starvedBefore(lockedAt), limit)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for _, stream := range streams {
+		if counts[stream] == 0 {
+			continue
+		}
+		read, err := o.readGroup(ctx, lockedBy, stream, counts[stream])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, read...)
+	}
+	for _, stream := range streams {
+		if len(entries) >= limit {
+			break
+		}
+		read, err := o.readGroup(ctx, lockedBy, stream, limit-len(entries))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, read...)
+	}
+
+	members := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		members = append(members, entry.member)
+	}
+
+	loaded, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(entries))
+	claimed := make(map[string]struct{}, len(entries))
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, entry := range entries {
+			record, ok := loaded[entry.member]
+
+			// the entry outlived its record or was superseded by a newer one
+			if !ok || record.State != dto.OutboxStatePending {
+				o.ack(ctx, pipeCommands{pipe}, entry.stream, entry.member, entry.id)
+				continue
+			}
+
+			// a record published again before its first entry was read is claimed once
+			if _, ok := claimed[entry.member]; ok {
+				pipe.XAck(ctx, entry.stream, streamGroup, entry.id)
+				pipe.XDel(ctx, entry.stream, entry.id)
+				continue
+			}
+			claimed[entry.member] = struct{}{}
+
+			record.State = dto.OutboxStateInProgress
+			record.LockedAt = &lockedAt
+			record.LockedBy = &lockedBy
+
+			jRecord, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+
+			pipe.HSet(ctx, o.recordsKey(), entry.member, string(jRecord))
+			pipe.HSet(ctx, o.entriesKey(), entry.member, entry.id)
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// streamEntry is a stream entry read for a record
+type streamEntry struct {
+	stream string
+	member string
+	id     string
+}
+
+// starved counts the entries of each stream published before starvedBefore and not yet
+// delivered to the group, the oldest limit entries of all streams are counted. Nothing is
+// starved when starvedBefore is the zero time.
+func (o outboxRedisStreamRepository) starved(ctx context.Context, streams []string, starvedBefore time.Time, limit int) (map[string]int, error) {
+	counts := make(map[string]int, len(streams))
+	if starvedBefore.IsZero() {
+		return counts, nil
+	}
+
+	var starved []streamEntry
+	for _, stream := range streams {
+		groups, err := o.instance.XInfoGroups(ctx, stream).Result()
+		if err != nil && strings.Contains(err.Error(), "no such key") {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		lastDeliveredID := "0-0"
+		for _, group := range groups {
+			if group.Name == streamGroup {
+				lastDeliveredID = group.LastDeliveredID
+			}
+		}
+
+		messages, err := o.instance.XRangeN(ctx, stream, lastDeliveredID, strconv.FormatInt(starvedBefore.UnixMilli(), 10), int64(limit)+1).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			if message.ID != lastDeliveredID {
+				member, _ := message.Values[streamIDField].(string)
+				starved = append(starved, streamEntry{stream: stream, member: member, id: message.ID})
+			}
+		}
+	}
+
+	// entries of the same millisecond are taken in the order of their records
+	sort.SliceStable(starved, func(i, j int) bool {
+		if entryTime(starved[i].id) != entryTime(starved[j].id) {
+			return entryTime(starved[i].id) < entryTime(starved[j].id)
+		}
+		return memberID(starved[i].member) < memberID(starved[j].member)
+	})
+	for i := 0; i < len(starved) && i < limit; i++ {
+		counts[starved[i].stream]++
+	}
+	return counts, nil
+}
+
+// entryTime returns the unix milliseconds a stream entry id was generated at
+func entryTime(id string) int64 {
+	milliseconds, _, _ := strings.Cut(id, "-")
+	value, _ := strconv.ParseInt(milliseconds, 10, 64)
+	return value
+}
+
+// memberID returns the record id of a hash field or stream entry value
+func memberID(member string) int64 {
+	id, _ := strconv.ParseInt(member, 10, 64)
+	return id
+}
+
+// readGroup reads up to count new entries of the stream for the consumer, the consumer group
+// is created when the stream was never migrated
+func (o outboxRedisStreamRepository) readGroup(ctx context.Context, consumer, stream string, count int) ([]streamEntry, error) {
+	args := &redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: consumer,
+		Streams:  []string{stream, ">"},
+		Count:    int64(count),
+		Block:    -1,
+	}
+
+	streams, err := o.instance.XReadGroup(ctx, args).Result()
+	if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+		if err := o.createGroup(ctx, stream); err != nil {
+			return nil, err
+		}
+		streams, err = o.instance.XReadGroup(ctx, args).Result()
+	}
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []streamEntry
+	for _, read := range streams {
+		for _, message := range read.Messages {
+			member, _ := message.Values[streamIDField].(string)
+			entries = append(entries, streamEntry{stream: stream, member: member, id: message.ID})
+		}
+	}
+	return entries, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and acknowledges its stream entry
+func (o outboxRedisStreamRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateSucceed
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = nil
+	})
+}
+
+// MarkAsFailed marks the record as failed with the given reason and acknowledges its stream entry
+func (o outboxRedisStreamRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateFailed
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+	})
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and acknowledges its stream entry
+func (o outboxRedisStreamRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStateDeadLettered
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+	})
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and acknowledges its stream entry
+func (o outboxRedisStreamRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStateExpired
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// MarkAsRetry records a failed attempt and acknowledges its stream entry, the record
+// is published to the stream again once nextAttemptAt is due
+func (o outboxRedisStreamRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		now := time.Now()
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+		record.LastAttemptedAt = &now
+		record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+		record.Error = &reason
+		record.NextAttemptAt = &nextAttemptAt
+	})
+}
+
+// Release returns the record to the pending state and publishes it to the stream again
+func (o outboxRedisStreamRepository) Release(ctx context.Context, id int64) error {
+	return o.update(ctx, id, func(record *dto.Outbox) {
+		record.State = dto.OutboxStatePending
+		record.LockedAt = nil
+		record.LockedBy = nil
+	})
+}
+
+// update applies fn to the stored record, acknowledges its stream entry and places it
+// where its new state is served from. The record is compared and set in a single script, so
+// that a record reclaimed and fetched by another node meanwhile is left to its new owner.
+func (o outboxRedisStreamRepository) update(ctx context.Context, id int64, fn func(record *dto.Outbox)) error {
+	member := strconv.FormatInt(id, 10)
+
+	jRecord, err := o.instance.HGet(ctx, o.recordsKey(), member).Result()
+	if errors.Is(err, redis.Nil) {
+		return constant.ErrClaimLost
+	}
+	if err != nil {
+		return err
+	}
+
+	var record dto.Outbox
+	if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+		return err
+	}
+	if !claimedBy(record, o.setting.lockedBy()) {
+		return constant.ErrClaimLost
+	}
+
+	entryID, err := o.instance.HGet(ctx, o.entriesKey(), member).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+
+	fn(&record)
+
+	var commands scriptCommands
+	o.move(ctx, &commands, record, entryID)
+	return compareAndSet(ctx, o.instance, o.recordsKey(), member, jRecord, record, commands)
+}
+
+// replace overwrites the stored record, acknowledges the stream entry it was claimed with
+// and places it where its state is served from
+func (o outboxRedisStreamRepository) replace(ctx context.Context, client redis.Cmdable, record dto.Outbox, entryID string) error {
+	jRecord, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	member := strconv.FormatInt(record.ID, 10)
+	_, err = client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, o.recordsKey(), member, string(jRecord))
+		o.move(ctx, pipeCommands{pipe}, record, entryID)
+		return nil
+	})
+	return err
+}
+
+// move acknowledges the stream entry the record was claimed with and places it where its
+// state is served from
+func (o outboxRedisStreamRepository) move(ctx context.Context, c commands, record dto.Outbox, entryID string) {
+	member := strconv.FormatInt(record.ID, 10)
+	o.ack(ctx, c, o.streamKey(record.Priority), member, entryID)
+	c.call(ctx, "ZREM", o.delayedKey(), member)
+	c.call(ctx, "ZREM", o.deadLetteredKey(), member)
+	o.place(ctx, c, record)
+}
+
+// ReleaseStale takes over the stream entries delivered before lockedBefore and never
+// acknowledged with XAUTOCLAIM, and returns their records to the pending state counting the
+// interrupted attempt. Records a node read but never claimed are published again. It reports
+// how many records were released.
+func (o outboxRedisStreamRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	streams, err := o.streamKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var released int64
+	for _, stream := range streams {
+		count, err := o.releaseStale(ctx, stream, lockedBefore)
+		released += count
+		if err != nil {
+			return released, err
+		}
+	}
+	return released, nil
+}
+
+// releaseStale releases the stale entries of a stream, it reports how many records were released
+func (o outboxRedisStreamRepository) releaseStale(ctx context.Context, stream string, lockedBefore time.Time) (int64, error) {
+	var (
+		released int64
+		start    = "0-0"
+		minIdle  = max(time.Since(lockedBefore), 0)
+	)
+
+	for {
+		messages, next, err := o.instance.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   stream,
+			Group:    streamGroup,
+			MinIdle:  minIdle,
+			Start:    start,
+			Count:    streamReclaimBatch,
+			Consumer: o.setting.lockedBy(),
+		}).Result()
+		if err != nil && strings.HasPrefix(err.Error(), "NOGROUP") {
+			return released, nil
+		}
+		if err != nil {
+			return released, err
+		}
+
+		for _, message := range messages {
+			member, _ := message.Values[streamIDField].(string)
+
+			loaded, err := o.load(ctx, o.instance, []string{member})
+			if err != nil {
+				return released, err
+			}
+
+			record, ok := loaded[member]
+			switch {
+			case !ok || isFinished(record.State):
+				if _, err := o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+					o.ack(ctx, pipeCommands{pipe}, stream, member, message.ID)
+					return nil
+				}); err != nil {
+					return released, err
+				}
+			case record.State == dto.OutboxStatePending:
+				// the node crashed between reading the entry and claiming its record,
+				// the record is published again without counting an attempt
+				if err := o.replace(ctx, o.instance, record, message.ID); err != nil {
+					return released, err
+				}
+				released++
+			case record.LockedAt != nil && record.LockedAt.Before(lockedBefore):
+				record.State = dto.OutboxStatePending
+				record.LockedAt = nil
+				record.LockedBy = nil
+				record.NumberOfAttempts = incrementAttempts(record.NumberOfAttempts)
+				if err := o.replace(ctx, o.instance, record, message.ID); err != nil {
+					return released, err
+				}
+				released++
+			}
+		}
+
+		if next == "0-0" || next == "" {
+			return released, nil
+		}
+		start = next
+	}
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o outboxRedisStreamRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	records, err := o.deadLetters(ctx, driverName, nil)
+	if err != nil {
+		return nil, err
+	}
+	return paginate(records, limit, offset), nil
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o outboxRedisStreamRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	records, err := o.deadLetters(ctx, "", []int64{id})
+	if err != nil {
+		return dto.Outbox{}, err
+	}
+	if len(records) == 0 {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return records[0], nil
+}
+
+// RequeueDeadLetters publishes dead-lettered records of the driver to the stream again with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o outboxRedisStreamRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	records, err := o.deadLetters(ctx, driverName, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, record := range records {
+		requeue(&record)
+		if err := o.replace(ctx, o.instance, record, ""); err != nil {
+			return 0, err
+		}
+	}
+
+	return int64(len(records)), nil
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o outboxRedisStreamRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	records, err := o.deadLetters(ctx, driverName, ids)
+	if err != nil || len(records) == 0 {
+		return 0, err
+	}
+
+	members := make([]string, 0, len(records))
+	for _, record := range records {
+		members = append(members, strconv.FormatInt(record.ID, 10))
+	}
+
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, o.recordsKey(), members...)
+		pipe.ZRem(ctx, o.deadLetteredKey(), members)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(records)), nil
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o outboxRedisStreamRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	keys := make([]string, 0, len(states))
+	for _, state := range states {
+		keys = append(keys, o.finishedKey(state))
+	}
+
+	members, err := finishedMembers(ctx, o.instance, keys, finishedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	loaded, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(loaded))
+	for _, record := range loaded {
+		records = append(records, record)
+	}
+	return finishedRecords(records, states, finishedBefore, limit), nil
+}
+
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o outboxRedisStreamRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	members := make([]string, 0, len(ids))
+	for _, id := range ids {
+		members = append(members, strconv.FormatInt(id, 10))
+	}
+
+	records, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	_, err = o.instance.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for member, record := range records {
+			if !inStates(record.State, states) {
+				continue
+			}
+			pipe.HDel(ctx, o.recordsKey(), member)
+			pipe.ZRem(ctx, o.finishedKey(record.State), member)
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// ListMessages lists the records selected by the filter ordered by id. Without ids the whole
+// hash is scanned, so it suits the occasional inspection by an operator.
+func (o outboxRedisStreamRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	if len(filter.IDs) == 0 {
+		records, err := scanRecords(ctx, o.instance, o.recordsKey(), filter)
+		if err != nil {
+			return nil, err
+		}
+		return paginate(records, filter.Limit, filter.Offset), nil
+	}
+
+	loaded, err := o.load(ctx, o.instance, idMembers(filter.IDs))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(loaded))
+	for _, record := range loaded {
+		records = append(records, record)
+	}
+	return paginate(matchRecords(records, filter), filter.Limit, filter.Offset), nil
+}
+
+// Stats counts the records of every driver and state, the whole hash is scanned
+func (o outboxRedisStreamRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	records, err := scanRecords(ctx, o.instance, o.recordsKey(), dto.MessageFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return countMessages(records), nil
+}
+
+// RetryMessages publishes the pending records of the ids waiting for their next attempt to the
+// stream right away. It reports how many records were rescheduled.
+func (o outboxRedisStreamRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	now := time.Now()
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending || record.NextAttemptAt == nil || !record.NextAttemptAt.After(now) {
+			return false
+		}
+		record.NextAttemptAt = nil
+		return true
+	})
+}
+
+// RequeueMessages publishes the records of the ids that are in one of the states to the stream
+// again with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o outboxRedisStreamRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if !inStates(record.State, states) {
+			return false
+		}
+		requeue(record)
+		return true
+	})
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, their stream entries
+// are skipped when they are read. It reports how many records were canceled.
+func (o outboxRedisStreamRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	return o.transition(ctx, ids, func(record *dto.Outbox) bool {
+		if record.State != dto.OutboxStatePending {
+			return false
+		}
+		record.State = dto.OutboxStateCanceled
+		return true
+	})
+}
+
+// transition applies fn to the stored records of the ids and places the records it changed where
+// their new state is served from. The records are rewritten in an optimistic transaction, so a
+// node claiming a record meanwhile is never overwritten. It reports how many records were changed.
+func (o outboxRedisStreamRepository) transition(ctx context.Context, ids []int64, fn func(record *dto.Outbox) bool) (int64, error) {
+	var changed int64
+
+	apply := func(tx *redis.Tx) error {
+		records, err := o.load(ctx, tx, idMembers(ids))
+		if err != nil {
+			return err
+		}
+
+		changed = 0
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for member, record := range records {
+				previous := record.State
+				if !fn(&record) {
+					continue
+				}
+
+				jRecord, err := json.Marshal(record)
+				if err != nil {
+					return err
+				}
+
+				pipe.HSet(ctx, o.recordsKey(), member, string(jRecord))
+				pipe.ZRem(ctx, o.delayedKey(), member)
+				pipe.ZRem(ctx, o.parkedKey(), member)
+				if isFinished(previous) {
+					pipe.ZRem(ctx, o.finishedKey(previous), member)
+				}
+				o.place(ctx, pipeCommands{pipe}, record)
+				changed++
+			}
+			return nil
+		})
+		return err
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := watch(ctx, o.instance, apply, o.recordsKey()); err != nil {
+		return 0, err
+	}
+	return changed, nil
+}
+
+// deadLetters loads dead-lettered records of the driver and ids ordered by id,
+// every dead letter is loaded when no ids are given
+func (o outboxRedisStreamRepository) deadLetters(ctx context.Context, driverName string, ids []int64) ([]dto.Outbox, error) {
+	var members []string
+	if len(ids) > 0 {
+		for _, id := range ids {
+			members = append(members, strconv.FormatInt(id, 10))
+		}
+	} else {
+		var err error
+		if members, err = o.instance.ZRange(ctx, o.deadLetteredKey(), 0, -1).Result(); err != nil {
+			return nil, err
+		}
+	}
+
+	loaded, err := o.load(ctx, o.instance, members)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0, len(loaded))
+	for _, record := range loaded {
+		if record.State != dto.OutboxStateDeadLettered {
+			continue
+		}
+		if driverName != "" && record.DriverName != driverName {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+
+	return records, nil
+}
+
+// load returns the stored records of the given ids keyed by id, missing ids are skipped
+func (o outboxRedisStreamRepository) load(ctx context.Context, client redis.Cmdable, members []string) (map[string]dto.Outbox, error) {
+	records := make(map[string]dto.Outbox, len(members))
+	if len(members) == 0 {
+		return records, nil
+	}
+
+	values, err := client.HMGet(ctx, o.recordsKey(), members...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		jRecord, ok := value.(string)
+		if !ok {
+			continue
+		}
+
+		var record dto.Outbox
+		if err := json.Unmarshal([]byte(jRecord), &record); err != nil {
+			return nil, err
+		}
+		records[members[i]] = record
+	}
+
+	return records, nil
+}
+
+// ack acknowledges and deletes the stream entry a record was claimed with
+func (o outboxRedisStreamRepository) ack(ctx context.Context, c commands, stream, member, entryID string) {
+	c.call(ctx, "HDEL", o.entriesKey(), member)
+	if entryID == "" {
+		return
+	}
+	c.call(ctx, "XACK", stream, streamGroup, entryID)
+	c.call(ctx, "XDEL", stream, entryID)
+}
+
+// place publishes a pending record to the stream, or delays it until its next attempt,
+// and indexes a finished record. A pending record of an ordering key is parked until
+// the earlier records of the key are finished.
+func (o outboxRedisStreamRepository) place(ctx context.Context, c commands, record dto.Outbox) {
+	member := strconv.FormatInt(record.ID, 10)
+
+	switch record.State {
+	case dto.OutboxStatePending:
+		c.call(ctx, "ZADD", o.prioritiesKey(), record.Priority, record.Priority)
+		delayed := record.NextAttemptAt != nil && record.NextAttemptAt.After(time.Now())
+		if record.OrderingKey != "" {
+			var delayedUntil int64
+			if delayed {
+				delayedUntil = record.NextAttemptAt.UnixMilli()
+			}
+			c.run(ctx, "stream_order",
+				[]string{o.orderingKey(record.OrderingKey), o.parkedKey(), o.streamKey(record.Priority), o.delayedKey()},
+				member, record.CreatedAt.UnixMilli(), delayedUntil, streamIDField,
+			)
+			return
+		}
+		if delayed {
+			c.call(ctx, "ZADD", o.delayedKey(), record.NextAttemptAt.UnixMilli(), member)
+			return
+		}
+		c.call(ctx, "XADD", o.streamKey(record.Priority), "*", streamIDField, member)
+		return
+	case dto.OutboxStateInProgress:
+		if record.OrderingKey != "" {
+			c.call(ctx, "ZADD", o.orderingKey(record.OrderingKey), "NX", record.CreatedAt.UnixMilli(), member)
+		}
+		return
+	}
+
+	// the record is finished, the next record of its ordering key is published
+	if record.OrderingKey != "" {
+		c.run(ctx, "stream_unorder",
+			[]string{o.orderingKey(record.OrderingKey), o.parkedKey(), o.delayedKey(), o.recordsKey(), o.prioritiesKey()},
+			member, streamIDField, time.Now().UnixMilli(), o.streamKey(0),
+		)
+	}
+
+	c.call(ctx, "ZADD", o.finishedKey(record.State), record.FinishedAt().UnixMilli(), member)
+}
@@ -0,0 +1,461 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// newOutboxRedisStreamRepoInstance returns a migrated instance of OutboxRedisStreamRepository.
+func newOutboxRedisStreamRepoInstance(tb testing.TB, nodeID string) IRepository {
+	repo := NewOutboxRedisStreamRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    nodeID,
+	}, redisClient)
+
+	if err := Migrate(context.Background(), repo); err != nil {
+		tb.Fatalf("failed to create the consumer group: %v", err)
+	}
+	return repo
+}
+
+// TestOutboxRedisStreamRepository_Migrate tests that creating an existing consumer group succeeds.
+func TestOutboxRedisStreamRepository_Migrate(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	repo := newOutboxRedisStreamRepoInstance(t, "")
+	assert.NoError(t, Migrate(context.Background(), repo))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages tests the method FetchMessages of OutboxRedisStreamRepository.
+func TestOutboxRedisStreamRepository_FetchMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := newOutboxRedisStreamRepoInstance(t, "node-1")
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxRedisStreamRepository_FetchMessages_OrderingKey(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesOrderingKey(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxRedisStreamRepository_FetchMessages_Scheduled(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesScheduled(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxRedisStreamRepository_MarkAsExpired(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMarkAsExpired(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_MessageMetadata tests that the key, event type and headers of the records are kept.
+func TestOutboxRedisStreamRepository_MessageMetadata(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMessageMetadata(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxRedisStreamRepository_FetchMessages_Priority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesPriority(t, NewOutboxRedisStreamRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, redisClient))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxRedisStreamRepository_FetchMessages_Starvation(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvation(t, NewOutboxRedisStreamRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, redisClient))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxRedisStreamRepository_FetchMessages_StarvedPriority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvedPriority(t, NewOutboxRedisStreamRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, redisClient))
+}
+
+// TestOutboxRedisStreamRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxRedisStreamRepository_FinishedMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFinishedMessages(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxRedisStreamRepository_AdminQueries(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAdminQueries(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxRedisStreamRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testRequeueDeadLettersExpiry(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxRedisStreamRepository_AcknowledgeClaimLost(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeClaimLost(t, newOutboxRedisStreamRepoInstance(t, "node-1"), newOutboxRedisStreamRepoInstance(t, "node-2"))
+}
+
+// TestOutboxRedisStreamRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxRedisStreamRepository_NewRecords_Deduplication(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplication(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_NewRecords_DeduplicationWindow tests that expired deduplication keys are taken over.
+func TestOutboxRedisStreamRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplicationWindow(t, NewOutboxRedisStreamRepository(RepoSetting{TableName: "outbox", DeduplicationWindow: time.Hour}, redisClient))
+}
+
+// TestOutboxRedisStreamRepository_FetchMessages_Concurrent tests that the consumer group never delivers a record to two nodes.
+func TestOutboxRedisStreamRepository_FetchMessages_Concurrent(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	seeder := newOutboxRedisStreamRepoInstance(t, "")
+	_, err := seeder.NewRecords(ctx, newPendingRecords(100))
+	assert.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[int64]int)
+	)
+
+	for node := 0; node < 4; node++ {
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			repo := newOutboxRedisStreamRepoInstance(t, fmt.Sprintf("node-%d", node))
+			for {
+				records, err := repo.FetchMessages(ctx, 7)
+				if !assert.NoError(t, err) || len(records) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, record := range records {
+					claimed[record.ID]++
+				}
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 100)
+	for id, count := range claimed {
+		assert.Equalf(t, 1, count, "record %d claimed more than once", id)
+	}
+}
+
+// TestOutboxRedisStreamRepository_Acknowledge tests that acknowledged records leave the pending entries of the group.
+func TestOutboxRedisStreamRepository_Acknowledge(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := newOutboxRedisStreamRepoInstance(t, "node-1")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	pending, err := redisClient.XPending(ctx, "outbox:stream", streamGroup).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), pending.Count)
+
+	// only the released record is delivered again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxRedisStreamRepository_AcknowledgeConcurrent tests that concurrent acknowledgements of different records all succeed.
+func TestOutboxRedisStreamRepository_AcknowledgeConcurrent(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeConcurrent(t, newOutboxRedisStreamRepoInstance(t, ""))
+}
+
+// TestOutboxRedisStreamRepository_NewRecordsTx tests that records are published with the caller's pipeline.
+func TestOutboxRedisStreamRepository_NewRecordsTx(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := newOutboxRedisStreamRepoInstance(t, "")
+
+	_, err := redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		_, err := repo.NewRecordsTx(ctx, pipe, newPendingRecords(2))
+		return err
+	})
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	_, err = repo.NewRecordsTx(ctx, redisClient, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxRedisStreamRepository_MarkAsRetry tests that retried records join the stream once their next attempt is due.
+func TestOutboxRedisStreamRepository_MarkAsRetry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := newOutboxRedisStreamRepoInstance(t, "")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second)))
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+		if assert.NotNil(t, claimed[0].NumberOfAttempts) {
+			assert.Equal(t, int64(1), *claimed[0].NumberOfAttempts)
+		}
+	}
+
+	delayed, err := redisClient.ZCard(ctx, "outbox:delayed").Result()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), delayed)
+}
+
+// TestOutboxRedisStreamRepository_DeadLetters tests listing, requeueing and purging dead letters of OutboxRedisStreamRepository.
+func TestOutboxRedisStreamRepository_DeadLetters(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := newOutboxRedisStreamRepoInstance(t, "")
+
+	records := newPendingRecords(3)
+	records[2].DriverName = "http"
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	for _, record := range claimed {
+		assert.NoError(t, repo.MarkAsDeadLettered(ctx, record.ID, "poison"))
+	}
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetter, err := repo.DeadLetter(ctx, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "http", deadLetter.DriverName)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+}
+
+// TestOutboxRedisStreamRepository_ReleaseStale tests that the entries of a crashed node are claimed back with XAUTOCLAIM.
+func TestOutboxRedisStreamRepository_ReleaseStale(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	crashed := newOutboxRedisStreamRepoInstance(t, "crashed-node")
+	reaper := newOutboxRedisStreamRepoInstance(t, "reaper-node")
+
+	_, err := crashed.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := crashed.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	released, err := reaper.ReleaseStale(ctx, time.Now().Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), released)
+
+	released, err = reaper.ReleaseStale(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	claimed, err = reaper.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		if assert.NotNil(t, claimed[0].NumberOfAttempts) {
+			assert.Equal(t, int64(1), *claimed[0].NumberOfAttempts)
+		}
+		if assert.NotNil(t, claimed[0].LockedBy) {
+			assert.Equal(t, "reaper-node", *claimed[0].LockedBy)
+		}
+	}
+}
+
+// TestOutboxRedisStreamRepository_ReleaseStaleUnclaimed tests that an entry read by a node that crashed
+// before claiming its record is published again instead of being dropped.
+func TestOutboxRedisStreamRepository_ReleaseStaleUnclaimed(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	reaper := newOutboxRedisStreamRepoInstance(t, "reaper-node")
+
+	_, err := reaper.NewRecords(ctx, newPendingRecords(1))
+	assert.NoError(t, err)
+
+	// the crashed node read the entry but never marked its record as in progress
+	_, err = redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    streamGroup,
+		Consumer: "crashed-node",
+		Streams:  []string{"outbox:stream", ">"},
+		Block:    -1,
+	}).Result()
+	assert.NoError(t, err)
+
+	released, err := reaper.ReleaseStale(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+
+	claimed, err := reaper.FetchMessages(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+}
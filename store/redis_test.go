@@ -1,9 +1,13 @@
+//go:build integration
+
 package store
 
 import (
 	"context"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -23,7 +27,7 @@ func TestOutboxRedisRepository_NewRecords(t *testing.T) {
 		return
 	}
 
-	err = repo.NewRecords(context.Background(), []dto.Outbox{
+	_, err = repo.NewRecords(context.Background(), []dto.Outbox{
 		{ID: 1, Payload: "Hello, World!"},
 		{ID: 2, Payload: "Hello, Universe!"},
 	})
@@ -56,3 +60,458 @@ func TestNewOutboxRedisRepository(t *testing.T) {
 
 	assert.NotNil(t, repo)
 }
+
+// TestOutboxRedisRepository_FetchMessages tests the method FetchMessages of OutboxRedisRepository.
+func TestOutboxRedisRepository_FetchMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, redisClient)
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+
+	_, err := repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(context.Background(), 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxRedisRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxRedisRepository_FetchMessages_OrderingKey(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesOrderingKey(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxRedisRepository_FetchMessages_Scheduled(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesScheduled(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxRedisRepository_MarkAsExpired(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMarkAsExpired(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_MessageMetadata tests that the key, event type and headers of the records are kept.
+func TestOutboxRedisRepository_MessageMetadata(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMessageMetadata(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxRedisRepository_FetchMessages_Priority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesPriority(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, redisClient))
+}
+
+// TestOutboxRedisRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxRedisRepository_FetchMessages_Starvation(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvation(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, redisClient))
+}
+
+// TestOutboxRedisRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxRedisRepository_FetchMessages_StarvedPriority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvedPriority(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, redisClient))
+}
+
+// TestOutboxRedisRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxRedisRepository_FinishedMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFinishedMessages(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxRedisRepository_AdminQueries(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAdminQueries(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxRedisRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testRequeueDeadLettersExpiry(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxRedisRepository_AcknowledgeClaimLost(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeClaimLost(t,
+		NewOutboxRedisRepository(RepoSetting{TableName: "outbox", NodeID: "node-1"}, redisClient),
+		NewOutboxRedisRepository(RepoSetting{TableName: "outbox", NodeID: "node-2"}, redisClient),
+	)
+}
+
+// TestOutboxRedisRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxRedisRepository_NewRecords_Deduplication(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplication(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_NewRecords_DeduplicationWindow tests that expired deduplication keys are taken over.
+func TestOutboxRedisRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplicationWindow(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox", DeduplicationWindow: time.Hour}, redisClient))
+}
+
+// TestOutboxRedisRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed and Release of OutboxRedisRepository.
+func TestOutboxRedisRepository_Acknowledge(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, redisClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	succeed := findRedisRecord(t, 1)
+	assert.Equal(t, dto.OutboxStateSucceed, succeed.State)
+	assert.Nil(t, succeed.LockedBy)
+	assert.NotNil(t, succeed.LastAttemptedAt)
+	if assert.NotNil(t, succeed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *succeed.NumberOfAttempts)
+	}
+
+	failed := findRedisRecord(t, 2)
+	assert.Equal(t, dto.OutboxStateFailed, failed.State)
+	assert.Nil(t, failed.LockedBy)
+	assert.NotNil(t, failed.LastAttemptedAt)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, "connection refused", *failed.Error)
+	}
+	if assert.NotNil(t, failed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *failed.NumberOfAttempts)
+	}
+
+	released := findRedisRecord(t, 3)
+	assert.Equal(t, dto.OutboxStatePending, released.State)
+	assert.Nil(t, released.LockedAt)
+	assert.Nil(t, released.LockedBy)
+	assert.Nil(t, released.NumberOfAttempts)
+
+	// only the released record can be claimed again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxRedisRepository_AcknowledgeConcurrent tests that concurrent acknowledgements of different records all succeed.
+func TestOutboxRedisRepository_AcknowledgeConcurrent(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeConcurrent(t, NewOutboxRedisRepository(RepoSetting{TableName: "outbox"}, redisClient))
+}
+
+// TestOutboxRedisRepository_NewRecordsTx tests that records are only written when the caller's pipeline executes.
+func TestOutboxRedisRepository_NewRecordsTx(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo, err := newOutboxRedisRepoInstance()
+	if err != nil {
+		assert.FailNowf(t, "failed to create new instance of OutboxRedisRepository", "%v", err)
+		return
+	}
+
+	pipe := redisClient.TxPipeline()
+	_, err = repo.NewRecordsTx(ctx, pipe, newPendingRecords(2))
+	assert.NoError(t, err)
+	pipe.Discard()
+	assert.Equal(t, int64(0), redisClient.HLen(ctx, "outbox").Val())
+
+	pipe = redisClient.TxPipeline()
+	_, err = repo.NewRecordsTx(ctx, pipe, newPendingRecords(2))
+	assert.NoError(t, err)
+	_, err = pipe.Exec(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), redisClient.HLen(ctx, "outbox").Val())
+
+	_, err = repo.NewRecordsTx(ctx, redisClient, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxRedisRepository_MarkAsRetry tests that retried records are only fetched once their next attempt is due.
+func TestOutboxRedisRepository_MarkAsRetry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+	}, redisClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second)))
+
+	retried := findRedisRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, retried.State)
+	assert.Nil(t, retried.LockedBy)
+	assert.NotNil(t, retried.NextAttemptAt)
+	assert.NotNil(t, retried.LastAttemptedAt)
+	if assert.NotNil(t, retried.Error) {
+		assert.Equal(t, "timeout", *retried.Error)
+	}
+	if assert.NotNil(t, retried.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *retried.NumberOfAttempts)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+	}
+}
+
+// TestOutboxRedisRepository_DeadLetters tests listing, inspecting, requeueing and purging dead letters of OutboxRedisRepository.
+func TestOutboxRedisRepository_DeadLetters(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+	}, redisClient)
+
+	records := newPendingRecords(4)
+	records[3].DriverName = "http"
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 4)
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 1, "poison"))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 2, "poison"))
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 4, "poison"))
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 2, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, int64(2), deadLetters[0].ID)
+		assert.Equal(t, int64(4), deadLetters[1].ID)
+	}
+
+	deadLetter, err := repo.DeadLetter(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.OutboxStateDeadLettered, deadLetter.State)
+	if assert.NotNil(t, deadLetter.Error) {
+		assert.Equal(t, "poison", *deadLetter.Error)
+	}
+	if assert.NotNil(t, deadLetter.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *deadLetter.NumberOfAttempts)
+	}
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	claimed, err = repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+// TestOutboxRedisRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxRedisRepository_ReleaseStale(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+	}, redisClient)
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		crashedBy = "crashed-node"
+		lockedBy  = "live-node"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &crashedBy
+	}
+	records[2].LockedAt, records[2].LockedBy = &lockedAt, &lockedBy
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	recovered := findRedisRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, recovered.State)
+	assert.Nil(t, recovered.LockedBy)
+	if assert.NotNil(t, recovered.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *recovered.NumberOfAttempts)
+	}
+
+	assert.Equal(t, dto.OutboxStateInProgress, findRedisRecord(t, 3).State)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+}
+
+// TestOutboxRedisRepository_ReleaseStaleUnsaved tests that an id claimed by a node that crashed before
+// saving its record as in progress is released instead of being stuck in the in-progress index.
+func TestOutboxRedisRepository_ReleaseStaleUnsaved(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxRedisRepository(RepoSetting{
+		TableName: "outbox",
+	}, redisClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(1))
+	assert.NoError(t, err)
+
+	// the crashed node ran the claim script but never saved the record
+	err = claimScript.Run(ctx, redisClient,
+		[]string{"outbox:priorities", "outbox:in_progress", "outbox"},
+		time.Now().UnixMilli(), 1, "", "outbox:pending",
+	).Err()
+	assert.NoError(t, err)
+	assert.Empty(t, claimIDs(t, repo, 1))
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+
+	claimed, err := repo.FetchMessages(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+}
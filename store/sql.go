@@ -4,7 +4,23 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// outboxColumns is the column list used when selecting outbox records
+	outboxColumns = "id, driver_name, payload, state, created_at, locked_at, locked_by, last_attempted_at, number_of_attempts, error, next_attempt_at, message_key, event_type, headers, content_type, ordering_key, deduplication_key, expires_at, priority"
+	// insertColumns is the column list used when inserting outbox records, a record holds its
+	// deduplication key until the deduplication window passed
+	insertColumns = "id, payload, driver_name, state, created_at, locked_at, locked_by, last_attempted_at, number_of_attempts, error, next_attempt_at, message_key, event_type, headers, content_type, ordering_key, deduplication_key, expires_at, priority, held_deduplication_key"
+	// statsColumns is the column list used when counting outbox records by driver and state
+	statsColumns = "driver_name, state, COUNT(*) AS count"
 )
 
 type outboxSqlRepository struct {
@@ -24,24 +40,78 @@ func (o outboxSqlRepository) GetTableName() string {
 	return o.setting.TableName
 }
 
-// NewRecords insert new records to outbox table
-func (o outboxSqlRepository) NewRecords(ctx context.Context, records []dto.Outbox) error {
+// dialect get the sql dialect of the repository
+func (o outboxSqlRepository) dialect() IDialect {
+	return dialectOrDefault(o.setting.Dialect)
+}
+
+// table get the quoted table name
+func (o outboxSqlRepository) table() string {
+	return o.dialect().Quote(o.GetTableName())
+}
+
+// rebind replaces the ? bind parameters of the query with the placeholders of the dialect
+func (o outboxSqlRepository) rebind(query string) string {
+	return rebind(o.dialect(), query)
+}
+
+// args converts the bind arguments with the dialect
+func (o outboxSqlRepository) args(args ...any) []any {
+	return bindArgs(o.dialect(), args)
+}
+
+// Migrate creates or upgrades the outbox table
+func (o outboxSqlRepository) Migrate(ctx context.Context) error {
+	return migrateSQL(ctx, o.instance, o.dialect(), o.GetTableName())
+}
+
+// NewRecords insert new records to outbox table, it returns the ids of the records dropped
+// because another record holds their deduplication key
+func (o outboxSqlRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
 
-	tx, err := o.instance.Begin()
+	tx, err := o.instance.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	dropped, err := o.insertRecords(ctx, tx, records)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
 	}
 
-	statement := fmt.Sprintf("INSERT INTO %s (id, payload, driver_name, state, created_at, locked_at, locked_by, last_attempted_at, number_of_attempts, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)", o.GetTableName())
-	stmt, err := tx.PrepareContext(ctx, statement)
+	return dropped, tx.Commit()
+}
+
+// NewRecordsTx insert new records to outbox table inside the caller's *sql.Tx or *sqlx.Tx
+func (o outboxSqlRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	switch t := tx.(type) {
+	case *sql.Tx:
+		return o.insertRecords(ctx, t, records)
+	case *sqlx.Tx:
+		return o.insertRecords(ctx, t.Tx, records)
+	}
+	return nil, constant.ErrUnsupportedTx
+}
+
+// insertRecords insert records using the given transaction, records whose id already
+// exists are skipped so that retrying a batch after a lost commit acknowledgement is safe.
+// It returns the ids of the records dropped because another record holds their deduplication key.
+func (o outboxSqlRepository) insertRecords(ctx context.Context, tx *sql.Tx, records []dto.Outbox) ([]int64, error) {
 
+	if err := releaseDeduplicationKeys(ctx, tx, o.dialect(), o.table(), o.setting.DeduplicationWindow, records); err != nil {
+		return nil, err
+	}
+
+	statement := o.dialect().InsertIgnore(o.table(), insertColumns, "(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	stmt, err := tx.PrepareContext(ctx, o.rebind(statement))
 	if err != nil {
-		return tx.Rollback()
+		return nil, err
 	}
+	defer stmt.Close()
 
 	for _, record := range records {
-		if _, err = stmt.ExecContext(
-			ctx,
+		if _, err = stmt.ExecContext(ctx, o.args(
 			record.ID,
 			record.Payload,
 			record.DriverName,
@@ -51,10 +121,495 @@ func (o outboxSqlRepository) NewRecords(ctx context.Context, records []dto.Outbo
 			record.LockedBy,
 			record.LastAttemptedAt,
 			record.NumberOfAttempts,
-			record.Error); err != nil {
-			return tx.Rollback()
+			record.Error,
+			record.NextAttemptAt,
+			record.Key,
+			record.EventType,
+			record.Headers,
+			record.ContentType,
+			record.OrderingKey,
+			record.DeduplicationKey,
+			record.ExpiresAt,
+			record.Priority,
+			record.DeduplicationKey)...); err != nil {
+			return nil, err
+		}
+	}
+
+	return droppedRecords(ctx, tx, o.dialect(), o.table(), records)
+}
+
+// FetchMessages claims up to limit pending records whose next attempt is due, highest
+// priority first, and marks them as in progress for this node. Rows locked by other nodes are skipped.
+// Records of an ordering key wait until the earlier records of the key are finished.
+func (o outboxSqlRepository) FetchMessages(ctx context.Context, limit int) (_ []dto.Outbox, err error) {
+
+	tx, err := o.instance.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := time.Now()
+	fetchArgs := sqlArgs{dto.OutboxStatePending, now}
+	query := fmt.Sprintf("SELECT %s FROM %s candidate WHERE state = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?) AND %s ORDER BY %s LIMIT %s %s", outboxColumns, o.table(), orderingFilter(o.table()), fetchOrder(&fetchArgs, o.setting.starvedBefore(now)), fetchArgs.add(limit), o.dialect().LockClause())
+	rows, err := tx.QueryContext(ctx, o.rebind(query), o.args(fetchArgs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := scanOutboxRows(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return records, tx.Commit()
+	}
+
+	var (
+		lockedAt = time.Now()
+		lockedBy = o.setting.lockedBy()
+		args     = sqlArgs{dto.OutboxStateInProgress, lockedAt, lockedBy}
+		ids      = make([]int64, 0, len(records))
+	)
+
+	for i := range records {
+		ids = append(ids, records[i].ID)
+
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt = &lockedAt
+		records[i].LockedBy = &lockedBy
+	}
+
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = ?, locked_by = ? WHERE id IN (%s)", o.table(), args.addIDs(ids))
+	if _, err = tx.ExecContext(ctx, o.rebind(statement), o.args(args...)...); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and releases its claim
+func (o outboxSqlRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateSucceed, time.Now(), id)
+}
+
+// MarkAsFailed marks the record as failed with the given reason and releases its claim
+func (o outboxSqlRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateFailed, time.Now(), reason, id)
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and releases its claim
+func (o outboxSqlRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateDeadLettered, time.Now(), reason, id)
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and releases its claim
+func (o outboxSqlRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateExpired, id)
+}
+
+// MarkAsRetry records a failed attempt and returns the record to the pending state,
+// it is not fetched again before nextAttemptAt
+func (o outboxSqlRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ?, next_attempt_at = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStatePending, time.Now(), reason, nextAttemptAt, id)
+}
+
+// Release returns the record to the pending state and clears its claim
+func (o outboxSqlRepository) Release(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStatePending, id)
+}
+
+// ReleaseStale returns records locked before lockedBefore to the pending state, counting the
+// interrupted attempt. It reports how many records were released.
+func (o outboxSqlRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, number_of_attempts = COALESCE(number_of_attempts, 0) + 1 WHERE state = ? AND locked_at < ?", o.table())
+	return o.execCount(ctx, statement, dto.OutboxStatePending, dto.OutboxStateInProgress, lockedBefore)
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o outboxSqlRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	args := sqlArgs{}
+	where := deadLetterFilter(&args, driverName, nil)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT %s OFFSET %s", outboxColumns, o.table(), where, args.add(limit), args.add(offset))
+
+	rows, err := o.instance.QueryContext(ctx, o.rebind(query), o.args(args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxRows(rows)
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o outboxSqlRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ? AND state = ?", outboxColumns, o.table())
+
+	rows, err := o.instance.QueryContext(ctx, o.rebind(query), o.args(id, dto.OutboxStateDeadLettered)...)
+	if err != nil {
+		return dto.Outbox{}, err
+	}
+
+	records, err := scanOutboxRows(rows)
+	if err != nil {
+		return dto.Outbox{}, err
+	}
+
+	if len(records) == 0 {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return records[0], nil
+}
+
+// RequeueDeadLetters returns dead-lettered records of the driver to the pending state with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o outboxSqlRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	args := sqlArgs{dto.OutboxStatePending}
+	where := deadLetterFilter(&args, driverName, ids)
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, number_of_attempts = NULL, next_attempt_at = NULL, expires_at = NULL WHERE %s", o.table(), where)
+	return o.execCount(ctx, statement, args...)
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o outboxSqlRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	args := sqlArgs{}
+	where := deadLetterFilter(&args, driverName, ids)
+	statement := fmt.Sprintf("DELETE FROM %s WHERE %s", o.table(), where)
+	return o.execCount(ctx, statement, args...)
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o outboxSqlRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	args := sqlArgs{}
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE state IN (%s) AND COALESCE(last_attempted_at, created_at) < %s ORDER BY id LIMIT %s", outboxColumns, o.table(), args.addStates(states), args.add(finishedBefore), args.add(limit))
+
+	rows, err := o.instance.QueryContext(ctx, o.rebind(query), o.args(args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxRows(rows)
+}
+
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o outboxSqlRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	args := sqlArgs{}
+	statement := fmt.Sprintf("DELETE FROM %s WHERE state IN (%s) AND id IN (%s)", o.table(), args.addStates(states), args.addIDs(ids))
+	return o.execCount(ctx, statement, args...)
+}
+
+// ListMessages lists the records selected by the filter ordered by id
+func (o outboxSqlRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	args := sqlArgs{}
+	where := messageFilter(&args, filter)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT %s OFFSET %s", outboxColumns, o.table(), where, args.add(filter.Limit), args.add(filter.Offset))
+
+	rows, err := o.instance.QueryContext(ctx, o.rebind(query), o.args(args...)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanOutboxRows(rows)
+}
+
+// Stats counts the records of every driver and state
+func (o outboxSqlRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	rows, err := o.instance.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s GROUP BY driver_name, state ORDER BY driver_name, state", statsColumns, o.table()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := make([]dto.MessageStats, 0)
+	for rows.Next() {
+		var stat dto.MessageStats
+		if err := rows.Scan(&stat.DriverName, &stat.State, &stat.Count); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+// RetryMessages makes the pending records of the ids waiting for their next attempt due right away.
+// It reports how many records were rescheduled.
+func (o outboxSqlRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	args := sqlArgs{dto.OutboxStatePending, time.Now()}
+	statement := fmt.Sprintf("UPDATE %s SET next_attempt_at = NULL WHERE state = ? AND next_attempt_at > ? AND id IN (%s)", o.table(), args.addIDs(ids))
+	return o.execCount(ctx, statement, args...)
+}
+
+// RequeueMessages returns the records of the ids that are in one of the states to the pending state
+// with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o outboxSqlRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	args := sqlArgs{dto.OutboxStatePending}
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, number_of_attempts = NULL, next_attempt_at = NULL, expires_at = NULL WHERE state IN (%s) AND id IN (%s)", o.table(), args.addStates(states), args.addIDs(ids))
+	return o.execCount(ctx, statement, args...)
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, so they are never delivered.
+// It reports how many records were canceled.
+func (o outboxSqlRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	args := sqlArgs{dto.OutboxStateCanceled, dto.OutboxStatePending}
+	statement := fmt.Sprintf("UPDATE %s SET state = ? WHERE state = ? AND id IN (%s)", o.table(), args.addIDs(ids))
+	return o.execCount(ctx, statement, args...)
+}
+
+// execCount rebinds and runs a statement and returns the number of affected records
+func (o outboxSqlRepository) execCount(ctx context.Context, statement string, args ...any) (int64, error) {
+	result, err := o.instance.ExecContext(ctx, o.rebind(statement), o.args(args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ack rebinds and runs a statement acknowledging a claimed record, it only applies while the
+// record is still claimed by this node, so that a record reclaimed and fetched by another node
+// meanwhile is left to its new owner
+func (o outboxSqlRepository) ack(ctx context.Context, statement string, args ...any) error {
+	statement += " AND state = ? AND locked_by = ?"
+	args = append(args, dto.OutboxStateInProgress, o.setting.lockedBy())
+
+	result, err := o.instance.ExecContext(ctx, o.rebind(statement), o.args(args...)...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return constant.ErrClaimLost
+	}
+
+	return nil
+}
+
+// sqlQueryer runs queries on a database or transaction of database/sql or sqlx
+type sqlQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// sqlArgs collects the arguments of a statement built with ? bind parameters
+type sqlArgs []any
+
+// add appends the value and returns its bind parameter
+func (a *sqlArgs) add(value any) string {
+	*a = append(*a, value)
+	return "?"
+}
+
+// addIDs appends the ids and returns their comma separated bind parameters
+func (a *sqlArgs) addIDs(ids []int64) string {
+	holders := make([]string, 0, len(ids))
+	for _, id := range ids {
+		holders = append(holders, a.add(id))
+	}
+	return strings.Join(holders, ", ")
+}
+
+// addKeys appends the keys and returns their comma separated bind parameters
+func (a *sqlArgs) addKeys(keys []string) string {
+	holders := make([]string, 0, len(keys))
+	for _, key := range keys {
+		holders = append(holders, a.add(key))
+	}
+	return strings.Join(holders, ", ")
+}
+
+// addStates appends the states and returns their comma separated bind parameters
+func (a *sqlArgs) addStates(states []dto.OutboxStateEnum) string {
+	holders := make([]string, 0, len(states))
+	for _, state := range states {
+		holders = append(holders, a.add(state))
+	}
+	return strings.Join(holders, ", ")
+}
+
+// deadLetterFilter returns the where clause matching dead letters of the driver and ids
+func deadLetterFilter(args *sqlArgs, driverName string, ids []int64) string {
+	where := "state = " + args.add(dto.OutboxStateDeadLettered)
+	if driverName != "" {
+		where += " AND driver_name = " + args.add(driverName)
+	}
+	if len(ids) > 0 {
+		where += " AND id IN (" + args.addIDs(ids) + ")"
+	}
+	return where
+}
+
+// messageFilter returns the where clause matching the records selected by the filter
+func messageFilter(args *sqlArgs, filter dto.MessageFilter) string {
+	conditions := []string{"1 = 1"}
+	if len(filter.IDs) > 0 {
+		conditions = append(conditions, "id IN ("+args.addIDs(filter.IDs)+")")
+	}
+	if len(filter.States) > 0 {
+		conditions = append(conditions, "state IN ("+args.addStates(filter.States)+")")
+	}
+	if filter.DriverName != "" {
+		conditions = append(conditions, "driver_name = "+args.add(filter.DriverName))
+	}
+	if filter.AfterID > 0 {
+		conditions = append(conditions, "id > "+args.add(filter.AfterID))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// deduplicated returns the deduplication keys of the records and the ids of the records carrying one
+func deduplicated(records []dto.Outbox) ([]string, []int64) {
+	var (
+		keys []string
+		ids  []int64
+	)
+	for _, record := range records {
+		if record.DeduplicationKey != nil {
+			keys = append(keys, *record.DeduplicationKey)
+			ids = append(ids, record.ID)
+		}
+	}
+	return keys, ids
+}
+
+// releaseDeduplicationKeys releases the deduplication keys of the records that are held by records
+// created before the window, so that the records take them over. The holders keep their deduplication
+// key, only its hold is cleared. Keys are never released without a window.
+func releaseDeduplicationKeys(ctx context.Context, execer sqlx.ExecerContext, d IDialect, table string, window time.Duration, records []dto.Outbox) error {
+	keys, _ := deduplicated(records)
+	if window <= 0 || len(keys) == 0 {
+		return nil
+	}
+
+	args := sqlArgs{time.Now().Add(-window)}
+	statement := fmt.Sprintf("UPDATE %s SET held_deduplication_key = NULL WHERE created_at < ? AND held_deduplication_key IN (%s)", table, args.addKeys(keys))
+	_, err := execer.ExecContext(ctx, rebind(d, statement), bindArgs(d, args)...)
+	return err
+}
+
+// droppedRecords returns the ids of the inserted records carrying a deduplication key that were
+// not stored, the unique index skipped them because another record holds their key
+func droppedRecords(ctx context.Context, queryer sqlQueryer, d IDialect, table string, records []dto.Outbox) ([]int64, error) {
+	_, ids := deduplicated(records)
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := sqlArgs{}
+	query := fmt.Sprintf("SELECT id FROM %s WHERE id IN (%s)", table, args.addIDs(ids))
+	rows, err := queryer.QueryContext(ctx, rebind(d, query), bindArgs(d, args)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stored := make([]int64, 0, len(ids))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		stored = append(stored, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return missingIDs(ids, stored), nil
+}
+
+// missingIDs returns the ids that are not stored
+func missingIDs(ids, stored []int64) []int64 {
+	found := make(map[int64]struct{}, len(stored))
+	for _, id := range stored {
+		found[id] = struct{}{}
+	}
+
+	var missing []int64
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// orderingFilter keeps the candidate records without an ordering key and those no earlier
+// record of their ordering key is pending or in progress before, so a key is delivered one
+// record at a time in creation order
+func orderingFilter(table string) string {
+	return fmt.Sprintf(`(candidate.ordering_key = '' OR NOT EXISTS (
+		SELECT 1 FROM %s earlier
+		WHERE earlier.ordering_key = candidate.ordering_key
+			AND earlier.state IN ('%s', '%s')
+			AND (earlier.created_at < candidate.created_at OR (earlier.created_at = candidate.created_at AND earlier.id < candidate.id))
+	))`, table, dto.OutboxStatePending, dto.OutboxStateInProgress)
+}
+
+// fetchOrder returns the ORDER BY of the claimed records, higher priorities first in creation
+// order. Records due before starvedBefore come first, longest waiting first whatever their
+// priority, so that lower priorities still progress. The zero time orders by priority only.
+func fetchOrder(args *sqlArgs, starvedBefore time.Time) string {
+	order := "priority DESC, created_at, id"
+	if starvedBefore.IsZero() {
+		return order
+	}
+	starved := func() string {
+		return fmt.Sprintf("COALESCE(next_attempt_at, created_at) <= %s", args.add(starvedBefore))
+	}
+	return fmt.Sprintf("CASE WHEN %s THEN 0 ELSE 1 END, CASE WHEN %s THEN COALESCE(next_attempt_at, created_at) END, CASE WHEN %s THEN 0 ELSE priority END DESC, created_at, id",
+		starved(), starved(), starved())
+}
+
+// scanOutboxRows scans rows selected with outboxColumns and closes them
+func scanOutboxRows(rows *sql.Rows) ([]dto.Outbox, error) {
+	defer rows.Close()
+
+	records := make([]dto.Outbox, 0)
+	for rows.Next() {
+		var record dto.Outbox
+		if err := rows.Scan(
+			&record.ID,
+			&record.DriverName,
+			&record.Payload,
+			&record.State,
+			&record.CreatedAt,
+			&record.LockedAt,
+			&record.LockedBy,
+			&record.LastAttemptedAt,
+			&record.NumberOfAttempts,
+			&record.Error,
+			&record.NextAttemptAt,
+			&record.Key,
+			&record.EventType,
+			&record.Headers,
+			&record.ContentType,
+			&record.OrderingKey,
+			&record.DeduplicationKey,
+			&record.ExpiresAt,
+			&record.Priority); err != nil {
+			return nil, err
 		}
+		records = append(records, record)
 	}
 
-	return tx.Commit()
+	return records, rows.Err()
 }
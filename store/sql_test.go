@@ -1,8 +1,13 @@
+//go:build integration
+
 package store
 
 import (
 	"context"
+	"fmt"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
+	"sync"
 	"testing"
 	"time"
 
@@ -60,6 +65,459 @@ func TestOutboxSqlRepository_NewRecords(t *testing.T) {
 		},
 	}
 
-	err = repo.NewRecords(context.Background(), records)
+	_, err = repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+}
+
+// TestOutboxSqlRepository_FetchMessages tests the method FetchMessages of OutboxSqlRepository.
+func TestOutboxSqlRepository_FetchMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	repo := NewOutboxSqlRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, sqlClient)
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+
+	_, err := repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(context.Background(), 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxSqlRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxSqlRepository_FetchMessages_OrderingKey(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesOrderingKey(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxSqlRepository_FetchMessages_Scheduled(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesScheduled(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxSqlRepository_MarkAsExpired(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMarkAsExpired(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxSqlRepository_FetchMessages_Priority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesPriority(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxSqlRepository_FetchMessages_Starvation(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvation(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxSqlRepository_FetchMessages_StarvedPriority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvedPriority(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxSqlRepository_FinishedMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFinishedMessages(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxSqlRepository_AdminQueries(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAdminQueries(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxSqlRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testRequeueDeadLettersExpiry(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxSqlRepository_AcknowledgeClaimLost(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeClaimLost(t,
+		NewOutboxSqlRepository(RepoSetting{TableName: "outbox", NodeID: "node-1"}, sqlClient),
+		NewOutboxSqlRepository(RepoSetting{TableName: "outbox", NodeID: "node-2"}, sqlClient),
+	)
+}
+
+// TestOutboxSqlRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxSqlRepository_NewRecords_Deduplication(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplication(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox"}, sqlClient))
+}
+
+// TestOutboxSqlRepository_NewRecords_DeduplicationWindow tests that expired deduplication keys are taken over.
+func TestOutboxSqlRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplicationWindow(t, NewOutboxSqlRepository(RepoSetting{TableName: "outbox", DeduplicationWindow: time.Hour}, sqlClient))
+}
+
+// TestOutboxSqlRepository_FetchMessages_Concurrent tests that concurrent nodes never claim the same record.
+func TestOutboxSqlRepository_FetchMessages_Concurrent(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	seeder, err := newDBSqlInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of OutboxSqlRepository")
+		return
+	}
+
+	_, err = seeder.NewRecords(context.Background(), newPendingRecords(100))
+	assert.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[int64]int)
+	)
+
+	for node := 0; node < 4; node++ {
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			repo := NewOutboxSqlRepository(RepoSetting{
+				TableName: "outbox",
+				NodeID:    fmt.Sprintf("node-%d", node),
+			}, sqlClient)
+			for {
+				records, err := repo.FetchMessages(context.Background(), 7)
+				if !assert.NoError(t, err) || len(records) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, record := range records {
+					claimed[record.ID]++
+				}
+				mu.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 100)
+	for id, count := range claimed {
+		assert.Equalf(t, 1, count, "record %d claimed more than once", id)
+	}
+}
+
+// TestOutboxSqlRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed and Release of OutboxSqlRepository.
+func TestOutboxSqlRepository_Acknowledge(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, sqlClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	succeed := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStateSucceed, succeed.State)
+	assert.Nil(t, succeed.LockedBy)
+	assert.NotNil(t, succeed.LastAttemptedAt)
+	if assert.NotNil(t, succeed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *succeed.NumberOfAttempts)
+	}
+
+	failed := findSqlRecord(t, 2)
+	assert.Equal(t, dto.OutboxStateFailed, failed.State)
+	assert.Nil(t, failed.LockedBy)
+	assert.NotNil(t, failed.LastAttemptedAt)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, "connection refused", *failed.Error)
+	}
+	if assert.NotNil(t, failed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *failed.NumberOfAttempts)
+	}
+
+	released := findSqlRecord(t, 3)
+	assert.Equal(t, dto.OutboxStatePending, released.State)
+	assert.Nil(t, released.LockedAt)
+	assert.Nil(t, released.LockedBy)
+	assert.Nil(t, released.NumberOfAttempts)
+
+	// only the released record can be claimed again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxSqlRepository_NewRecordsTx tests that records follow the outcome of the caller's transaction.
+func TestOutboxSqlRepository_NewRecordsTx(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo, err := newDBSqlInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of OutboxSqlRepository")
+		return
+	}
+
+	tx, err := sqlClient.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, 0, countSqlRecords(t))
+
+	tx, err = sqlClient.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, 2, countSqlRecords(t))
+
+	_, err = repo.NewRecordsTx(ctx, sqlClient, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxSqlRepository_MarkAsRetry tests that retried records are only fetched once their next attempt is due.
+func TestOutboxSqlRepository_MarkAsRetry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second)))
+
+	retried := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, retried.State)
+	assert.Nil(t, retried.LockedBy)
+	assert.NotNil(t, retried.NextAttemptAt)
+	assert.NotNil(t, retried.LastAttemptedAt)
+	if assert.NotNil(t, retried.Error) {
+		assert.Equal(t, "timeout", *retried.Error)
+	}
+	if assert.NotNil(t, retried.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *retried.NumberOfAttempts)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+	}
+}
+
+// TestOutboxSqlRepository_DeadLetters tests listing, inspecting, requeueing and purging dead letters of OutboxSqlRepository.
+func TestOutboxSqlRepository_DeadLetters(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlClient)
+
+	records := newPendingRecords(4)
+	records[3].DriverName = "http"
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 4)
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 1, "poison"))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 2, "poison"))
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 4, "poison"))
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 2, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, int64(2), deadLetters[0].ID)
+		assert.Equal(t, int64(4), deadLetters[1].ID)
+	}
+
+	deadLetter, err := repo.DeadLetter(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.OutboxStateDeadLettered, deadLetter.State)
+	if assert.NotNil(t, deadLetter.Error) {
+		assert.Equal(t, "poison", *deadLetter.Error)
+	}
+	if assert.NotNil(t, deadLetter.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *deadLetter.NumberOfAttempts)
+	}
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	claimed, err = repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+// TestOutboxSqlRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxSqlRepository_ReleaseStale(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlClient)
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		crashedBy = "crashed-node"
+		lockedBy  = "live-node"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &crashedBy
+	}
+	records[2].LockedAt, records[2].LockedBy = &lockedAt, &lockedBy
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	recovered := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, recovered.State)
+	assert.Nil(t, recovered.LockedBy)
+	if assert.NotNil(t, recovered.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *recovered.NumberOfAttempts)
+	}
+
+	assert.Equal(t, dto.OutboxStateInProgress, findSqlRecord(t, 3).State)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
 	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
 }
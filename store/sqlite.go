@@ -0,0 +1,52 @@
+package store
+
+import (
+	"database/sql"
+	"net/url"
+	"strings"
+
+	_ "modernc.org/sqlite" // SQLite driver
+)
+
+const (
+	// sqliteDriverName is the database/sql driver name of the pure-go sqlite driver
+	sqliteDriverName = "sqlite"
+	// sqliteBusyTimeout is how long a connection waits for the write lock held by another one
+	sqliteBusyTimeout = "busy_timeout(5000)"
+)
+
+// OpenSqlite opens the sqlite database file at path with the pure-go driver. The connections
+// begin their transactions immediately, so concurrent claims queue up for the write lock
+// instead of failing to upgrade a read lock, and the journal is in WAL mode so readers do
+// not block the writer.
+func OpenSqlite(path string) (*sql.DB, error) {
+	query := url.Values{}
+	query.Add("_pragma", sqliteBusyTimeout)
+	query.Add("_pragma", "journal_mode(WAL)")
+	query.Add("_pragma", "synchronous(NORMAL)")
+	query.Set("_txlock", "immediate")
+
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open(sqliteDriverName, "file:"+path+separator+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// NewOutboxSqliteRepository returns the repository of an outbox table in a sqlite database,
+// it suits single node services and tests. The database should be opened with OpenSqlite.
+func NewOutboxSqliteRepository(setting RepoSetting, instance *sql.DB) IRepository {
+	setting.Dialect = SQLite
+	return NewOutboxSqlRepository(setting, instance)
+}
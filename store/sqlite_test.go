@@ -0,0 +1,423 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
+	"github.com/ghaninia/gbox/dto"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newSqliteInstance returns a migrated OutboxSqliteRepository on a fresh database file.
+func newSqliteInstance(tb testing.TB, nodeID string) (IRepository, *sql.DB) {
+	db, err := OpenSqlite(filepath.Join(tb.TempDir(), "outbox.db"))
+	if err != nil {
+		tb.Fatalf("failed to open the sqlite database: %v", err)
+	}
+	tb.Cleanup(func() {
+		_ = db.Close()
+	})
+
+	repo := NewOutboxSqliteRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    nodeID,
+	}, db)
+
+	if err := Migrate(context.Background(), repo); err != nil {
+		tb.Fatalf("failed to migrate the sqlite database: %v", err)
+	}
+	return repo, db
+}
+
+// findSqliteRecord returns the record with the given id straight from the outbox table
+func findSqliteRecord(tb testing.TB, db *sql.DB, id int64) dto.Outbox {
+	rows, err := db.Query(fmt.Sprintf("SELECT %s FROM outbox WHERE id = ?", outboxColumns), id)
+	if err != nil {
+		tb.Fatalf("failed to query the record: %v", err)
+	}
+
+	records, err := scanOutboxRows(rows)
+	if err != nil || len(records) != 1 {
+		tb.Fatalf("failed to find the record %d: %v", id, err)
+	}
+	return records[0]
+}
+
+// TestOutboxSqliteRepository_Migrate tests that migrating an up to date database changes nothing.
+func TestOutboxSqliteRepository_Migrate(t *testing.T) {
+	repo, db := newSqliteInstance(t, "")
+
+	assert.NoError(t, Migrate(context.Background(), repo))
+
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM outbox_migrations").Scan(&count)
+	assert.NoError(t, err)
+	assert.Equal(t, len(migrations), count)
+}
+
+// TestOutboxSqliteRepository_Migrate_InvalidTableName tests that a table name the migrations would use unquoted is refused.
+func TestOutboxSqliteRepository_Migrate_InvalidTableName(t *testing.T) {
+	_, db := newSqliteInstance(t, "")
+
+	for _, table := range []string{"", "Outbox", "outbox-events", "app.outbox", "1outbox"} {
+		err := Migrate(context.Background(), NewOutboxSqliteRepository(RepoSetting{TableName: table}, db))
+		assert.ErrorIs(t, err, constant.ErrInvalidTableName, table)
+	}
+}
+
+// TestOutboxSqliteRepository_NewRecords tests that stored records keep their values and duplicated ids are skipped.
+func TestOutboxSqliteRepository_NewRecords(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newSqliteInstance(t, "")
+
+	records := newPendingRecords(2)
+	records[0].Key = "customer-42"
+	records[0].EventType = "customer.created"
+	records[0].Headers = dto.Headers{"correlation_id": "c-1", "content_type": "application/json"}
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+	_, err = repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count))
+	assert.Equal(t, 2, count)
+
+	stored := findSqliteRecord(t, db, 1)
+	assert.Equal(t, records[0].Payload, stored.Payload)
+	assert.Equal(t, records[0].DriverName, stored.DriverName)
+	assert.Equal(t, dto.OutboxStatePending, stored.State)
+	assert.True(t, records[0].CreatedAt.Equal(stored.CreatedAt))
+	assert.Nil(t, stored.LockedAt)
+	assert.Equal(t, "customer-42", stored.Key)
+	assert.Equal(t, "customer.created", stored.EventType)
+	assert.Equal(t, records[0].Headers, stored.Headers)
+
+	// records without metadata keep empty headers
+	assert.Nil(t, findSqliteRecord(t, db, 2).Headers)
+}
+
+// TestOutboxSqliteRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxSqliteRepository_FetchMessages_Scheduled(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testFetchMessagesScheduled(t, repo)
+}
+
+// TestOutboxSqliteRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxSqliteRepository_MarkAsExpired(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testMarkAsExpired(t, repo)
+}
+
+// TestOutboxSqliteRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxSqliteRepository_FetchMessages_Priority(t *testing.T) {
+	_, db := newSqliteInstance(t, "")
+	testFetchMessagesPriority(t, NewOutboxSqliteRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, db))
+}
+
+// TestOutboxSqliteRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxSqliteRepository_FetchMessages_Starvation(t *testing.T) {
+	_, db := newSqliteInstance(t, "")
+	testFetchMessagesStarvation(t, NewOutboxSqliteRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, db))
+}
+
+// TestOutboxSqliteRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxSqliteRepository_FetchMessages_StarvedPriority(t *testing.T) {
+	_, db := newSqliteInstance(t, "")
+	testFetchMessagesStarvedPriority(t, NewOutboxSqliteRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, db))
+}
+
+// TestOutboxSqliteRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxSqliteRepository_FinishedMessages(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testFinishedMessages(t, repo)
+}
+
+// TestOutboxSqliteRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxSqliteRepository_AdminQueries(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testAdminQueries(t, repo)
+}
+
+// TestOutboxSqliteRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxSqliteRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testRequeueDeadLettersExpiry(t, repo)
+}
+
+// TestOutboxSqliteRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxSqliteRepository_AcknowledgeClaimLost(t *testing.T) {
+	repo, db := newSqliteInstance(t, "node-1")
+	testAcknowledgeClaimLost(t, repo, NewOutboxSqliteRepository(RepoSetting{TableName: "outbox", NodeID: "node-2"}, db))
+}
+
+// TestOutboxSqliteRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxSqliteRepository_NewRecords_Deduplication(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testNewRecordsDeduplication(t, repo)
+
+	_, db := newSqliteInstance(t, "")
+	testNewRecordsDeduplicationWindow(t, NewOutboxSqliteRepository(RepoSetting{
+		TableName:           "outbox",
+		DeduplicationWindow: time.Hour,
+	}, db))
+}
+
+// TestOutboxSqliteRepository_FetchMessages tests the method FetchMessages of OutboxSqliteRepository.
+func TestOutboxSqliteRepository_FetchMessages(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newSqliteInstance(t, "node-1")
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxSqliteRepository_FetchMessages_Concurrent tests that concurrent pollers never claim the same record.
+func TestOutboxSqliteRepository_FetchMessages_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newSqliteInstance(t, "")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(100))
+	assert.NoError(t, err)
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		claimed = make(map[int64]int)
+	)
+
+	for poller := 0; poller < 4; poller++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				records, err := repo.FetchMessages(ctx, 7)
+				if !assert.NoError(t, err) || len(records) == 0 {
+					return
+				}
+				mu.Lock()
+				for _, record := range records {
+					claimed[record.ID]++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Len(t, claimed, 100)
+	for id, count := range claimed {
+		assert.Equalf(t, 1, count, "record %d claimed more than once", id)
+	}
+}
+
+// TestOutboxSqliteRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxSqliteRepository_FetchMessages_OrderingKey(t *testing.T) {
+	repo, _ := newSqliteInstance(t, "")
+	testFetchMessagesOrderingKey(t, repo)
+}
+
+// TestOutboxSqliteRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed and Release of OutboxSqliteRepository.
+func TestOutboxSqliteRepository_Acknowledge(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newSqliteInstance(t, "node-1")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	succeed := findSqliteRecord(t, db, 1)
+	assert.Equal(t, dto.OutboxStateSucceed, succeed.State)
+	assert.Nil(t, succeed.LockedBy)
+	assert.NotNil(t, succeed.LastAttemptedAt)
+	if assert.NotNil(t, succeed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *succeed.NumberOfAttempts)
+	}
+
+	failed := findSqliteRecord(t, db, 2)
+	assert.Equal(t, dto.OutboxStateFailed, failed.State)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, "connection refused", *failed.Error)
+	}
+
+	released := findSqliteRecord(t, db, 3)
+	assert.Equal(t, dto.OutboxStatePending, released.State)
+	assert.Nil(t, released.LockedAt)
+	assert.Nil(t, released.NumberOfAttempts)
+
+	// only the released record can be claimed again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxSqliteRepository_NewRecordsTx tests that records follow the outcome of the caller's transaction.
+func TestOutboxSqliteRepository_NewRecordsTx(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newSqliteInstance(t, "")
+
+	tx, err := db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+
+	tx, err = db.BeginTx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(1))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+
+	var count int
+	assert.NoError(t, db.QueryRow("SELECT COUNT(*) FROM outbox").Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+// TestOutboxSqliteRepository_MarkAsRetry tests that retried records are only fetched once their next attempt is due.
+func TestOutboxSqliteRepository_MarkAsRetry(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newSqliteInstance(t, "")
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	// the due time is compared across zones, sqlite stores timestamps as text
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour).In(time.FixedZone("", -12*60*60))))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second).In(time.FixedZone("", 14*60*60))))
+
+	retried := findSqliteRecord(t, db, 1)
+	assert.Equal(t, dto.OutboxStatePending, retried.State)
+	assert.NotNil(t, retried.NextAttemptAt)
+	if assert.NotNil(t, retried.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *retried.NumberOfAttempts)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+	}
+}
+
+// TestOutboxSqliteRepository_DeadLetters tests listing, requeueing and purging dead letters of OutboxSqliteRepository.
+func TestOutboxSqliteRepository_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	repo, _ := newSqliteInstance(t, "")
+
+	records := newPendingRecords(3)
+	records[2].DriverName = "http"
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	for _, record := range claimed {
+		assert.NoError(t, repo.MarkAsDeadLettered(ctx, record.ID, "poison"))
+	}
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetter, err := repo.DeadLetter(ctx, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "http", deadLetter.DriverName)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+	}
+}
+
+// TestOutboxSqliteRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxSqliteRepository_ReleaseStale(t *testing.T) {
+	ctx := context.Background()
+	repo, db := newSqliteInstance(t, "")
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		lockedBy  = "node-1"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &lockedBy
+	}
+	records[2].LockedAt = &lockedAt
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	assert.Equal(t, dto.OutboxStatePending, findSqliteRecord(t, db, 1).State)
+	assert.Equal(t, dto.OutboxStateInProgress, findSqliteRecord(t, db, 3).State)
+}
@@ -2,7 +2,12 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
+
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 
 	"github.com/jmoiron/sqlx"
@@ -25,14 +30,366 @@ func (o outboxSqlxRepository) GetTableName() string {
 	return o.setting.TableName
 }
 
-// NewRecords insert new records to outbox table
-func (o outboxSqlxRepository) NewRecords(ctx context.Context, records []dto.Outbox) error {
+// dialect get the sql dialect of the repository, detected from the driver name when not set
+func (o outboxSqlxRepository) dialect() IDialect {
+	if o.setting.Dialect != nil {
+		return o.setting.Dialect
+	}
+	return dialectByDriverName(o.instance.DriverName())
+}
+
+// table get the quoted table name
+func (o outboxSqlxRepository) table() string {
+	return o.dialect().Quote(o.GetTableName())
+}
+
+// rebind replaces the ? bind parameters of the query with the placeholders of the dialect
+func (o outboxSqlxRepository) rebind(query string) string {
+	return rebind(o.dialect(), query)
+}
+
+// args converts the bind arguments with the dialect
+func (o outboxSqlxRepository) args(args ...any) []any {
+	return bindArgs(o.dialect(), args)
+}
+
+// Migrate creates or upgrades the outbox table
+func (o outboxSqlxRepository) Migrate(ctx context.Context) error {
+	return migrateSQL(ctx, o.instance.DB, o.dialect(), o.GetTableName())
+}
+
+// NewRecords insert new records to outbox table, it returns the ids of the records dropped
+// because another record holds their deduplication key
+func (o outboxSqlxRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
+	tx, err := o.instance.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	dropped, err := o.insertRecords(ctx, tx, records)
+	if err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+
+	return dropped, tx.Commit()
+}
+
+// NewRecordsTx insert new records to outbox table inside the caller's *sqlx.Tx
+func (o outboxSqlxRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	t, ok := tx.(*sqlx.Tx)
+	if !ok {
+		return nil, constant.ErrUnsupportedTx
+	}
+	return o.insertRecords(ctx, t, records)
+}
+
+// insertRecords insert records using the given transaction, it returns the ids of the records
+// dropped because another record holds their deduplication key
+func (o outboxSqlxRepository) insertRecords(ctx context.Context, tx *sqlx.Tx, records []dto.Outbox) ([]int64, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	if err := releaseDeduplicationKeys(ctx, tx, o.dialect(), o.table(), o.setting.DeduplicationWindow, records); err != nil {
+		return nil, err
+	}
+
+	query := o.dialect().InsertIgnore(o.table(), insertColumns, "(:id, :payload, :driver_name, :state, :created_at, :locked_at, :locked_by, :last_attempted_at, :number_of_attempts, :error, :next_attempt_at, :message_key, :event_type, :headers, :content_type, :ordering_key, :deduplication_key, :expires_at, :priority, :deduplication_key)")
+
+	statement, args, err := sqlx.Named(query, records)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, o.rebind(statement), o.args(args...)...); err != nil {
+		return nil, err
+	}
+
+	return droppedRecords(ctx, tx, o.dialect(), o.table(), records)
+}
+
+// FetchMessages claims up to limit pending records whose next attempt is due, highest
+// priority first, and marks them as in progress for this node. Rows locked by other nodes are skipped.
+// Records of an ordering key wait until the earlier records of the key are finished.
+func (o outboxSqlxRepository) FetchMessages(ctx context.Context, limit int) (_ []dto.Outbox, err error) {
+
+	tx, err := o.instance.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	records := make([]dto.Outbox, 0)
+	now := time.Now()
+	fetchArgs := sqlArgs{dto.OutboxStatePending, now}
+	query := o.rebind(fmt.Sprintf("SELECT %s FROM %s candidate WHERE state = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?) AND %s ORDER BY %s LIMIT %s %s", outboxColumns, o.table(), orderingFilter(o.table()), fetchOrder(&fetchArgs, o.setting.starvedBefore(now)), fetchArgs.add(limit), o.dialect().LockClause()))
+	if err = tx.SelectContext(ctx, &records, query, o.args(fetchArgs...)...); err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return records, tx.Commit()
+	}
+
+	var (
+		lockedAt = time.Now()
+		lockedBy = o.setting.lockedBy()
+		ids      = make([]int64, 0, len(records))
+	)
+
+	for i := range records {
+		ids = append(ids, records[i].ID)
+
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt = &lockedAt
+		records[i].LockedBy = &lockedBy
+	}
+
+	statement, args, err := sqlx.In(fmt.Sprintf("UPDATE %s SET state = ?, locked_at = ?, locked_by = ? WHERE id IN (?)", o.table()), dto.OutboxStateInProgress, lockedAt, lockedBy, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, o.rebind(statement), o.args(args...)...); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// MarkAsProcessed marks the record as succeeded and releases its claim
+func (o outboxSqlxRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateSucceed, time.Now(), id)
+}
+
+// MarkAsFailed marks the record as failed with the given reason and releases its claim
+func (o outboxSqlxRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateFailed, time.Now(), reason, id)
+}
+
+// MarkAsDeadLettered moves the record to the dead letters with the given reason and releases its claim
+func (o outboxSqlxRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateDeadLettered, time.Now(), reason, id)
+}
+
+// MarkAsExpired moves the record to the expired state without counting an attempt and releases its claim
+func (o outboxSqlxRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStateExpired, id)
+}
+
+// MarkAsRetry records a failed attempt and returns the record to the pending state,
+// it is not fetched again before nextAttemptAt
+func (o outboxSqlxRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, last_attempted_at = ?, number_of_attempts = COALESCE(number_of_attempts, 0) + 1, error = ?, next_attempt_at = ? WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStatePending, time.Now(), reason, nextAttemptAt, id)
+}
+
+// Release returns the record to the pending state and clears its claim
+func (o outboxSqlxRepository) Release(ctx context.Context, id int64) error {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL WHERE id = ?", o.table())
+	return o.ack(ctx, statement, dto.OutboxStatePending, id)
+}
+
+// ReleaseStale returns records locked before lockedBefore to the pending state, counting the
+// interrupted attempt. It reports how many records were released.
+func (o outboxSqlxRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, number_of_attempts = COALESCE(number_of_attempts, 0) + 1 WHERE state = ? AND locked_at < ?", o.table())
+	return o.execCount(ctx, statement, dto.OutboxStatePending, dto.OutboxStateInProgress, lockedBefore)
+}
+
+// DeadLetters lists dead-lettered records of the driver, or of every driver when empty
+func (o outboxSqlxRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	where, args := sqlxDeadLetterFilter(driverName, nil)
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT ? OFFSET ?", outboxColumns, o.table(), where)
+
+	records := make([]dto.Outbox, 0)
+	if err := o.instance.SelectContext(ctx, &records, o.rebind(query), o.args(append(args, limit, offset)...)...); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// DeadLetter returns the dead-lettered record with the given id
+func (o outboxSqlxRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE id = ? AND state = ?", outboxColumns, o.table())
+
+	var record dto.Outbox
+	err := o.instance.GetContext(ctx, &record, o.rebind(query), o.args(id, dto.OutboxStateDeadLettered)...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return record, err
+}
+
+// RequeueDeadLetters returns dead-lettered records of the driver to the pending state with a
+// fresh retry budget and no expiry, limited to ids when given. It reports how many records were requeued.
+func (o outboxSqlxRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	where, args := sqlxDeadLetterFilter(driverName, ids)
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, number_of_attempts = NULL, next_attempt_at = NULL, expires_at = NULL WHERE %s", o.table(), where)
+	return o.execCount(ctx, statement, append([]any{dto.OutboxStatePending}, args...)...)
+}
+
+// PurgeDeadLetters deletes dead-lettered records of the driver, limited to ids when given.
+// It reports how many records were deleted.
+func (o outboxSqlxRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	where, args := sqlxDeadLetterFilter(driverName, ids)
+	statement := fmt.Sprintf("DELETE FROM %s WHERE %s", o.table(), where)
+	return o.execCount(ctx, statement, args...)
+}
+
+// FinishedMessages lists up to limit records in one of the states that finished before
+// finishedBefore ordered by id
+func (o outboxSqlxRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	query, args, err := sqlx.In(fmt.Sprintf("SELECT %s FROM %s WHERE state IN (?) AND COALESCE(last_attempted_at, created_at) < ? ORDER BY id LIMIT ?", outboxColumns, o.table()), states, finishedBefore, limit)
+	if err != nil {
+		return nil, err
+	}
 
-	query := fmt.Sprintf(`INSERT INTO %s (payload, driver_name, state,created_at , locked_at, locked_by, last_attempted_at, number_of_attempts, error) VALUES (:payload, :driver_name, :state, :created_at, :locked_at, :locked_by, :last_attempted_at, :number_of_attempts, :error)`, o.GetTableName())
+	records := make([]dto.Outbox, 0)
+	if err := o.instance.SelectContext(ctx, &records, o.rebind(query), o.args(args...)...); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
 
-	if _, err := o.instance.NamedExecContext(ctx, query, records); err != nil {
+// DeleteMessages deletes the records of the ids that are still in one of the states.
+// It reports how many records were deleted.
+func (o outboxSqlxRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	statement := fmt.Sprintf("DELETE FROM %s WHERE state IN (?) AND id IN (?)", o.table())
+	return o.execCount(ctx, statement, states, ids)
+}
+
+// ListMessages lists the records selected by the filter ordered by id
+func (o outboxSqlxRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	where, args := sqlxMessageFilter(filter)
+	query, args, err := sqlx.In(fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT ? OFFSET ?", outboxColumns, o.table(), where), append(args, filter.Limit, filter.Offset)...)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]dto.Outbox, 0)
+	if err := o.instance.SelectContext(ctx, &records, o.rebind(query), o.args(args...)...); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// Stats counts the records of every driver and state
+func (o outboxSqlxRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	stats := make([]dto.MessageStats, 0)
+	if err := o.instance.SelectContext(ctx, &stats, fmt.Sprintf("SELECT %s FROM %s GROUP BY driver_name, state ORDER BY driver_name, state", statsColumns, o.table())); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// RetryMessages makes the pending records of the ids waiting for their next attempt due right away.
+// It reports how many records were rescheduled.
+func (o outboxSqlxRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	statement := fmt.Sprintf("UPDATE %s SET next_attempt_at = NULL WHERE state = ? AND next_attempt_at > ? AND id IN (?)", o.table())
+	return o.execCount(ctx, statement, dto.OutboxStatePending, time.Now(), ids)
+}
+
+// RequeueMessages returns the records of the ids that are in one of the states to the pending state
+// with a fresh retry budget and no expiry. It reports how many records were requeued.
+func (o outboxSqlxRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	statement := fmt.Sprintf("UPDATE %s SET state = ?, locked_at = NULL, locked_by = NULL, number_of_attempts = NULL, next_attempt_at = NULL, expires_at = NULL WHERE state IN (?) AND id IN (?)", o.table())
+	return o.execCount(ctx, statement, dto.OutboxStatePending, states, ids)
+}
+
+// CancelMessages moves the pending records of the ids to the canceled state, so they are never delivered.
+// It reports how many records were canceled.
+func (o outboxSqlxRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	statement := fmt.Sprintf("UPDATE %s SET state = ? WHERE state = ? AND id IN (?)", o.table())
+	return o.execCount(ctx, statement, dto.OutboxStateCanceled, dto.OutboxStatePending, ids)
+}
+
+// execCount expands, rebinds and runs a statement and returns the number of affected records
+func (o outboxSqlxRepository) execCount(ctx context.Context, statement string, args ...any) (int64, error) {
+	statement, args, err := sqlx.In(statement, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := o.instance.ExecContext(ctx, o.rebind(statement), o.args(args...)...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// ack rebinds and runs a statement acknowledging a claimed record, it only applies while the
+// record is still claimed by this node, so that a record reclaimed and fetched by another node
+// meanwhile is left to its new owner
+func (o outboxSqlxRepository) ack(ctx context.Context, statement string, args ...any) error {
+	statement += " AND state = ? AND locked_by = ?"
+	args = append(args, dto.OutboxStateInProgress, o.setting.lockedBy())
+
+	result, err := o.instance.ExecContext(ctx, o.rebind(statement), o.args(args...)...)
+	if err != nil {
 		return err
 	}
 
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return constant.ErrClaimLost
+	}
+
 	return nil
 }
+
+// sqlxDeadLetterFilter returns the where clause matching dead letters of the driver and ids,
+// the ids are bound as a single slice argument to be expanded by sqlx.In
+func sqlxDeadLetterFilter(driverName string, ids []int64) (string, []any) {
+	where, args := "state = ?", []any{dto.OutboxStateDeadLettered}
+	if driverName != "" {
+		where += " AND driver_name = ?"
+		args = append(args, driverName)
+	}
+	if len(ids) > 0 {
+		where += " AND id IN (?)"
+		args = append(args, ids)
+	}
+	return where, args
+}
+
+// sqlxMessageFilter returns the where clause matching the records selected by the filter,
+// the ids and states are bound as slice arguments to be expanded by sqlx.In
+func sqlxMessageFilter(filter dto.MessageFilter) (string, []any) {
+	where, args := "1 = 1", []any{}
+	if len(filter.IDs) > 0 {
+		where += " AND id IN (?)"
+		args = append(args, filter.IDs)
+	}
+	if len(filter.States) > 0 {
+		where += " AND state IN (?)"
+		args = append(args, filter.States)
+	}
+	if filter.DriverName != "" {
+		where += " AND driver_name = ?"
+		args = append(args, filter.DriverName)
+	}
+	if filter.AfterID > 0 {
+		where += " AND id > ?"
+		args = append(args, filter.AfterID)
+	}
+	return where, args
+}
@@ -1,7 +1,10 @@
+//go:build integration
+
 package store
 
 import (
 	"context"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
 	"testing"
 	"time"
@@ -60,6 +63,418 @@ func TestOutboxSqlxRepository_NewRecords(t *testing.T) {
 		},
 	}
 
-	err = repo.NewRecords(context.Background(), records)
+	_, err = repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+}
+
+// TestOutboxSqlxRepository_FetchMessages tests the method FetchMessages of OutboxSqlxRepository.
+func TestOutboxSqlxRepository_FetchMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, sqlxClient)
+
+	records := newPendingRecords(5)
+	records = append(records, dto.Outbox{
+		ID:         6,
+		Payload:    `{"name": "Jane Doe"}`,
+		DriverName: "grpc",
+		State:      dto.OutboxStateSucceed,
+		CreatedAt:  time.Now(),
+	})
+
+	_, err := repo.NewRecords(context.Background(), records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(context.Background(), 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 3) {
+		for i, record := range claimed {
+			assert.Equal(t, int64(i+1), record.ID)
+			assert.Equal(t, dto.OutboxStateInProgress, record.State)
+			assert.NotNil(t, record.LockedAt)
+			if assert.NotNil(t, record.LockedBy) {
+				assert.Equal(t, "node-1", *record.LockedBy)
+			}
+		}
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, int64(4), claimed[0].ID)
+		assert.Equal(t, int64(5), claimed[1].ID)
+	}
+
+	claimed, err = repo.FetchMessages(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Empty(t, claimed)
+}
+
+// TestOutboxSqlxRepository_FetchMessages_OrderingKey tests that records of an ordering key are delivered one at a time.
+func TestOutboxSqlxRepository_FetchMessages_OrderingKey(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesOrderingKey(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_FetchMessages_Scheduled tests that records are not delivered before their scheduled time.
+func TestOutboxSqlxRepository_FetchMessages_Scheduled(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesScheduled(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_MarkAsExpired tests that expired records are finished without being delivered.
+func TestOutboxSqlxRepository_MarkAsExpired(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMarkAsExpired(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_MessageMetadata tests that the key, event type and headers of the records are kept.
+func TestOutboxSqlxRepository_MessageMetadata(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testMessageMetadata(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_FetchMessages_Priority tests that records of a higher priority are fetched first.
+func TestOutboxSqlxRepository_FetchMessages_Priority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesPriority(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", StarvationTimeout: -1}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_FetchMessages_Starvation tests that records waiting too long are fetched ahead of higher priorities.
+func TestOutboxSqlxRepository_FetchMessages_Starvation(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvation(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_FetchMessages_StarvedPriority tests that starved records are fetched longest waiting first whatever their priority.
+func TestOutboxSqlxRepository_FetchMessages_StarvedPriority(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFetchMessagesStarvedPriority(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", StarvationTimeout: 100 * time.Millisecond}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_FinishedMessages tests that records finished before the retention are listed and deleted.
+func TestOutboxSqlxRepository_FinishedMessages(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testFinishedMessages(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_AdminQueries tests that records are listed, counted, retried, canceled and requeued.
+func TestOutboxSqlxRepository_AdminQueries(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAdminQueries(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_RequeueDeadLetters_Expiry tests that requeued dead letters drop their expiry.
+func TestOutboxSqlxRepository_RequeueDeadLetters_Expiry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testRequeueDeadLettersExpiry(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_AcknowledgeClaimLost tests that records reclaimed by another node are left to it.
+func TestOutboxSqlxRepository_AcknowledgeClaimLost(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testAcknowledgeClaimLost(t,
+		NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", NodeID: "node-1"}, sqlxClient),
+		NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", NodeID: "node-2"}, sqlxClient),
+	)
+}
+
+// TestOutboxSqlxRepository_NewRecords_Deduplication tests that records holding a stored deduplication key are dropped.
+func TestOutboxSqlxRepository_NewRecords_Deduplication(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplication(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox"}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_NewRecords_DeduplicationWindow tests that expired deduplication keys are taken over.
+func TestOutboxSqlxRepository_NewRecords_DeduplicationWindow(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	testNewRecordsDeduplicationWindow(t, NewOutboxSqlxRepository(RepoSetting{TableName: "outbox", DeduplicationWindow: time.Hour}, sqlxClient))
+}
+
+// TestOutboxSqlxRepository_Acknowledge tests the methods MarkAsProcessed, MarkAsFailed and Release of OutboxSqlxRepository.
+func TestOutboxSqlxRepository_Acknowledge(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox",
+		NodeID:    "node-1",
+	}, sqlxClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(3))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.NoError(t, repo.MarkAsFailed(ctx, 2, "connection refused"))
+	assert.NoError(t, repo.Release(ctx, 3))
+
+	succeed := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStateSucceed, succeed.State)
+	assert.Nil(t, succeed.LockedBy)
+	assert.NotNil(t, succeed.LastAttemptedAt)
+	if assert.NotNil(t, succeed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *succeed.NumberOfAttempts)
+	}
+
+	failed := findSqlRecord(t, 2)
+	assert.Equal(t, dto.OutboxStateFailed, failed.State)
+	assert.Nil(t, failed.LockedBy)
+	assert.NotNil(t, failed.LastAttemptedAt)
+	if assert.NotNil(t, failed.Error) {
+		assert.Equal(t, "connection refused", *failed.Error)
+	}
+	if assert.NotNil(t, failed.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *failed.NumberOfAttempts)
+	}
+
+	released := findSqlRecord(t, 3)
+	assert.Equal(t, dto.OutboxStatePending, released.State)
+	assert.Nil(t, released.LockedAt)
+	assert.Nil(t, released.LockedBy)
+	assert.Nil(t, released.NumberOfAttempts)
+
+	// only the released record can be claimed again
+	claimed, err = repo.FetchMessages(ctx, 3)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(3), claimed[0].ID)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 404), constant.ErrMessageNotFound)
+}
+
+// TestOutboxSqlxRepository_NewRecordsTx tests that records follow the outcome of the caller's transaction.
+func TestOutboxSqlxRepository_NewRecordsTx(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo, err := newDBSqlxInstance()
+	if err != nil {
+		assert.NoErrorf(t, err, "error creating new instance of OutboxSqlxRepository")
+		return
+	}
+
+	tx, err := sqlxClient.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Rollback())
+	assert.Equal(t, 0, countSqlRecords(t))
+
+	tx, err = sqlxClient.BeginTxx(ctx, nil)
+	assert.NoError(t, err)
+	_, err = repo.NewRecordsTx(ctx, tx, newPendingRecords(2))
+	assert.NoError(t, err)
+	assert.NoError(t, tx.Commit())
+	assert.Equal(t, 2, countSqlRecords(t))
+
+	_, err = repo.NewRecordsTx(ctx, sqlxClient, newPendingRecords(1))
+	assert.ErrorIs(t, err, constant.ErrUnsupportedTx)
+}
+
+// TestOutboxSqlxRepository_MarkAsRetry tests that retried records are only fetched once their next attempt is due.
+func TestOutboxSqlxRepository_MarkAsRetry(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlxClient)
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(2))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
+
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(time.Hour)))
+	assert.NoError(t, repo.MarkAsRetry(ctx, 2, "timeout", time.Now().Add(-time.Second)))
+
+	retried := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, retried.State)
+	assert.Nil(t, retried.LockedBy)
+	assert.NotNil(t, retried.NextAttemptAt)
+	assert.NotNil(t, retried.LastAttemptedAt)
+	if assert.NotNil(t, retried.Error) {
+		assert.Equal(t, "timeout", *retried.Error)
+	}
+	if assert.NotNil(t, retried.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *retried.NumberOfAttempts)
+	}
+
+	claimed, err = repo.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+	}
+}
+
+// TestOutboxSqlxRepository_DeadLetters tests listing, inspecting, requeueing and purging dead letters of OutboxSqlxRepository.
+func TestOutboxSqlxRepository_DeadLetters(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlxClient)
+
+	records := newPendingRecords(4)
+	records[3].DriverName = "http"
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 4)
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 1, "poison"))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 2, "poison"))
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 4, "poison"))
+
+	deadLetters, err := repo.DeadLetters(ctx, "grpc", 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, deadLetters, 2)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 2, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, deadLetters, 2) {
+		assert.Equal(t, int64(2), deadLetters[0].ID)
+		assert.Equal(t, int64(4), deadLetters[1].ID)
+	}
+
+	deadLetter, err := repo.DeadLetter(ctx, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, dto.OutboxStateDeadLettered, deadLetter.State)
+	if assert.NotNil(t, deadLetter.Error) {
+		assert.Equal(t, "poison", *deadLetter.Error)
+	}
+	if assert.NotNil(t, deadLetter.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *deadLetter.NumberOfAttempts)
+	}
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", []int64{1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	claimed, err = repo.FetchMessages(ctx, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(1), claimed[0].ID)
+		assert.Nil(t, claimed[0].NumberOfAttempts)
+	}
+
+	purged, err := repo.PurgeDeadLetters(ctx, "", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+
+	deadLetters, err = repo.DeadLetters(ctx, "", 10, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, deadLetters)
+}
+
+// TestOutboxSqlxRepository_ReleaseStale tests that only claims older than the cutoff are released.
+func TestOutboxSqlxRepository_ReleaseStale(t *testing.T) {
+
+	tearDownSuite := setupSuite(t)
+	defer tearDownSuite(t)
+
+	ctx := context.Background()
+	repo := NewOutboxSqlxRepository(RepoSetting{
+		TableName: "outbox",
+	}, sqlxClient)
+
+	var (
+		crashedAt = time.Now().Add(-time.Hour)
+		lockedAt  = time.Now()
+		crashedBy = "crashed-node"
+		lockedBy  = "live-node"
+		records   = newPendingRecords(3)
+	)
+	for i := range records {
+		records[i].State = dto.OutboxStateInProgress
+		records[i].LockedAt, records[i].LockedBy = &crashedAt, &crashedBy
+	}
+	records[2].LockedAt, records[2].LockedBy = &lockedAt, &lockedBy
+
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(-10*time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), released)
+
+	recovered := findSqlRecord(t, 1)
+	assert.Equal(t, dto.OutboxStatePending, recovered.State)
+	assert.Nil(t, recovered.LockedBy)
+	if assert.NotNil(t, recovered.NumberOfAttempts) {
+		assert.Equal(t, int64(1), *recovered.NumberOfAttempts)
+	}
+
+	assert.Equal(t, dto.OutboxStateInProgress, findSqlRecord(t, 3).State)
+
+	claimed, err := repo.FetchMessages(ctx, 3)
 	assert.NoError(t, err)
+	assert.Len(t, claimed, 2)
 }
@@ -2,11 +2,20 @@ package store
 
 import (
 	"context"
-	"math/rand"
+	"fmt"
+	"log/slog"
+	"os"
 	"sync"
 	"time"
 
+	"github.com/ghaninia/gbox/codec"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -15,8 +24,53 @@ var (
 	}
 )
 
+// defaultListLimit is the page size of a message listing without a limit.
+const defaultListLimit = 100
+
+// defaultStarvationTimeout is the StarvationTimeout of a repository setting left zero.
+const defaultStarvationTimeout = time.Minute
+
 type RepoSetting struct {
+	// TableName of the outbox records. The sql repositories need a plain lowercase identifier of
+	// letters, digits and underscores, since the first migrations use it unquoted.
 	TableName string
+	// NodeID identifies this node in the locked_by column of claimed messages,
+	// it falls back to "<hostname>-<pid>" when empty.
+	NodeID string
+	// Dialect of the sql and sqlx repositories, Postgres is used when nil. The sqlx
+	// repository detects it from the driver name instead.
+	Dialect IDialect
+	// DeduplicationWindow is how long the deduplication key of a message drops later messages
+	// carrying it, the keys never expire when zero.
+	DeduplicationWindow time.Duration
+	// StarvationTimeout is how long a due message may wait before it is fetched ahead of
+	// messages of a higher priority, a minute when zero. Starved messages are fetched longest
+	// waiting first whatever their priority. Priorities are strict when negative.
+	StarvationTimeout time.Duration
+}
+
+// lockedBy returns the identity written into locked_by when messages are claimed.
+func (r RepoSetting) lockedBy() string {
+	if r.NodeID != "" {
+		return r.NodeID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "gbox"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
+// starvedBefore returns the time before which due messages are starved, the zero time when
+// priorities are strict.
+func (r RepoSetting) starvedBefore(now time.Time) time.Time {
+	switch {
+	case r.StarvationTimeout < 0:
+		return time.Time{}
+	case r.StarvationTimeout == 0:
+		return now.Add(-defaultStarvationTimeout)
+	}
+	return now.Add(-r.StarvationTimeout)
 }
 
 type Setting struct {
@@ -28,27 +82,109 @@ type Setting struct {
 	BackoffEnabled     bool
 	BackoffMaxRetries  int
 	BackoffDelay       time.Duration
+	// IDGenerator generates the message ids, a snowflake generator for NodeID is used when nil.
+	IDGenerator IIDGenerator
+	// Codecs maps driver names to the codec encoding their payloads, drivers without
+	// a codec use codec.JSON.
+	Codecs map[string]codec.ICodec
+	// Metrics observes the messages going through the store, nothing is recorded when nil.
+	Metrics IMetrics
+	// TracerProvider creates the spans around the repository calls, the global provider is used when nil.
+	TracerProvider trace.TracerProvider
+	// Propagator writes the trace context of Add into the message headers, the global
+	// propagator is used when nil.
+	Propagator propagation.TextMapPropagator
+	// Logger receives the retries and failures of the batch writes, the default slog logger
+	// is used when nil.
+	Logger *slog.Logger
 }
 
+// IMetrics observes the store, the metrics package implements it with prometheus.
+type IMetrics interface {
+	// MessagesAdded counts the messages of the driver accepted or buffered by Add and AddTx.
+	MessagesAdded(driverName string, count int)
+	// BatchSaved observes a batch written to the repository and how long it took with its retries.
+	BatchSaved(size int, duration time.Duration)
+	// SaveRetried counts a retry of a failed batch write.
+	SaveRetried()
+	// SaveFailed counts a batch that could not be written.
+	SaveFailed()
+	// BufferSize reports the messages waiting for the next batch insert.
+	BufferSize(size int)
+}
+
+// noopMetrics is the IMetrics of a store without metrics.
+type noopMetrics struct{}
+
+func (noopMetrics) MessagesAdded(string, int)     {}
+func (noopMetrics) BatchSaved(int, time.Duration) {}
+func (noopMetrics) SaveRetried()                  {}
+func (noopMetrics) SaveFailed()                   {}
+func (noopMetrics) BufferSize(int)                {}
+
 type IRepository interface {
 	GetTableName() string
-	NewRecords(ctx context.Context, records []dto.Outbox) error
+	NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error)
+	NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error)
 	FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error)
+	MarkAsProcessed(ctx context.Context, id int64) error
+	MarkAsFailed(ctx context.Context, id int64, reason string) error
+	MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error
+	MarkAsDeadLettered(ctx context.Context, id int64, reason string) error
+	MarkAsExpired(ctx context.Context, id int64) error
+	Release(ctx context.Context, id int64) error
+	ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error)
+	DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error)
+	DeadLetter(ctx context.Context, id int64) (dto.Outbox, error)
+	RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error)
+	PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error)
+	FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error)
+	DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error)
+	ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error)
+	Stats(ctx context.Context) ([]dto.MessageStats, error)
+	RetryMessages(ctx context.Context, ids []int64) (int64, error)
+	RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error)
+	CancelMessages(ctx context.Context, ids []int64) (int64, error)
 }
 
 type IStore interface {
-	Add(ctx context.Context, driverName string, messages ...dto.NewMessage) error
+	Add(ctx context.Context, driverName string, messages ...dto.NewMessage) (AddResult, error)
+	AddTx(ctx context.Context, tx any, driverName string, messages ...dto.NewMessage) (AddResult, error)
 	AutoCommit(ctx context.Context) error
 	SetBeforeSaveBatch(f func(ctx context.Context, messages []dto.Outbox) error)
 	SetAfterSaveBatch(f func(ctx context.Context, messages []dto.Outbox) error)
 	Messages() []dto.Outbox
 	FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error)
 	MarkAsProcessed(ctx context.Context, id int64) error
+	MarkAsFailed(ctx context.Context, id int64, err error) error
+	MarkAsRetry(ctx context.Context, id int64, err error, nextAttemptAt time.Time) error
+	MarkAsDeadLettered(ctx context.Context, id int64, err error) error
+	MarkAsExpired(ctx context.Context, id int64) error
+	Release(ctx context.Context, id int64) error
+	ReleaseStale(ctx context.Context, visibilityTimeout time.Duration) (int64, error)
+	DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error)
+	DeadLetter(ctx context.Context, id int64) (dto.Outbox, error)
+	RequeueDeadLetters(ctx context.Context, driverName string, ids ...int64) (int64, error)
+	PurgeDeadLetters(ctx context.Context, driverName string, ids ...int64) (int64, error)
+	FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, olderThan time.Duration, limit int) ([]dto.Outbox, error)
+	DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids ...int64) (int64, error)
+	ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error)
+	GetMessage(ctx context.Context, id int64) (dto.Outbox, error)
+	Stats(ctx context.Context) ([]dto.MessageStats, error)
+	RetryMessages(ctx context.Context, ids ...int64) (int64, error)
+	RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids ...int64) (int64, error)
+	CancelMessages(ctx context.Context, ids ...int64) (int64, error)
+	Codec(driverName string) codec.ICodec
 }
 
 type Store struct {
 	setting         Setting
 	repo            IRepository
+	idGenerator     IIDGenerator
+	metrics         IMetrics
+	logger          *slog.Logger
+	tracer          trace.Tracer
+	propagator      propagation.TextMapPropagator
 	muMessages      sync.Mutex
 	ticker          *time.Ticker
 	messages        []dto.Outbox
@@ -57,80 +193,211 @@ type Store struct {
 }
 
 // NewStore creates a new store instance with the provided repository and settings.
-func NewStore(repo IRepository, settings ...Setting) IStore {
+// It fails when no IDGenerator is set and NodeID is not a valid snowflake node id.
+func NewStore(repo IRepository, settings ...Setting) (IStore, error) {
 	var s = func() Setting {
 		if len(settings) > 0 {
 			return settings[0]
 		}
 		return defaultSetting
 	}()
-	return &Store{
-		repo:    repo,
-		setting: s,
+
+	idGenerator := s.IDGenerator
+	if idGenerator == nil {
+		var err error
+		if idGenerator, err = NewSnowflakeGenerator(s.NodeID); err != nil {
+			return nil, err
+		}
+	}
+
+	metrics := s.Metrics
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	tracerProvider := s.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+	propagator := s.Propagator
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
 	}
+
+	return &Store{
+		repo:        repo,
+		setting:     s,
+		idGenerator: idGenerator,
+		metrics:     metrics,
+		logger:      logger.With("component", "store"),
+		tracer:      tracerProvider.Tracer(tracerName),
+		propagator:  propagator,
+	}, nil
+}
+
+// AddResult reports which of the added messages were stored.
+type AddResult struct {
+	// Accepted are the messages stored in the outbox. A batch insert completed by the call
+	// reports the messages buffered by earlier calls as well.
+	Accepted []dto.Outbox
+	// Dropped are the messages discarded because a stored message holds their deduplication key.
+	Dropped []dto.Outbox
+	// Buffered are the messages left waiting for the next batch insert, their duplicates are
+	// dropped when the batch is saved.
+	Buffered []dto.Outbox
 }
 
 // Add adds new messages to the outbox store.
-func (s *Store) Add(ctx context.Context, driverName string, messages ...dto.NewMessage) error {
+func (s *Store) Add(ctx context.Context, driverName string, messages ...dto.NewMessage) (AddResult, error) {
 	s.muMessages.Lock()
 	defer s.muMessages.Unlock()
 
-	for _, msg := range messages {
+	var (
+		result AddResult
+		added  int
+	)
+	defer func() {
+		s.metrics.MessagesAdded(driverName, added)
+		s.metrics.BufferSize(len(s.messages))
+	}()
 
-		// TODO: added snowflakeID generation logic
-		var snowflakeID int64 = rand.Int63()
+	for _, msg := range messages {
 
-		outboxMessage := msg.ToOutBox(snowflakeID, driverName)
+		outboxMessage := msg.ToOutBox(s.nextID(), driverName)
+		s.injectTraceContext(ctx, &outboxMessage)
 
+		// without batching every message is saved right away
 		if !s.setting.BatchInsertEnabled {
-			if err := s.saveMessages(ctx, s.messages); err != nil {
-				return err
+			accepted, dropped, err := s.saveMessages(ctx, []dto.Outbox{outboxMessage})
+			if err != nil {
+				return result, err
 			}
-			break
+			result.Accepted = append(result.Accepted, accepted...)
+			result.Dropped = append(result.Dropped, dropped...)
+			added += len(accepted)
+			continue
 		}
 
 		s.messages = append(s.messages, outboxMessage)
+		result.Buffered = append(result.Buffered, outboxMessage)
+		added++
 
 		// check if the number of messages has reached the bulk size
 		if len(s.messages) >= s.setting.MaxBatchSize {
-			if err := s.saveMessages(ctx, s.messages); err != nil {
-				return err
+			accepted, dropped, err := s.saveMessages(ctx, s.messages)
+			if err != nil {
+				return result, err
 			}
+			result.Accepted = append(result.Accepted, accepted...)
+			result.Dropped = append(result.Dropped, dropped...)
+			result.Buffered = nil
 			// reset messages after saving
 			s.messages = []dto.Outbox{}
 		}
 	}
 
-	return nil
+	return result, nil
 }
 
-// saveMessages saves the messages in the outbox store.
-func (s *Store) saveMessages(ctx context.Context, messages []dto.Outbox) (err error) {
+// AddTx writes new messages to the outbox inside the caller's transaction, so that they
+// are committed or rolled back together with the business change. The in-memory buffer,
+// the save hooks and the backoff retries are bypassed. The accepted transaction depends
+// on the repository: *sql.Tx, *sqlx.Tx, a *gorm.DB opened by Begin/Transaction or a
+// redis.Pipeliner opened by TxPipeline.
+func (s *Store) AddTx(ctx context.Context, tx any, driverName string, messages ...dto.NewMessage) (AddResult, error) {
+	records := make([]dto.Outbox, 0, len(messages))
+	for _, msg := range messages {
+		record := msg.ToOutBox(s.nextID(), driverName)
+		s.injectTraceContext(ctx, &record)
+		records = append(records, record)
+	}
+
+	spanCtx, span := s.startSpan(ctx, "NewRecordsTx", len(records))
+	dropped, err := s.repo.NewRecordsTx(spanCtx, tx, records)
+	endSpan(span, err)
+	if err != nil {
+		return AddResult{}, err
+	}
+
+	var result AddResult
+	result.Accepted, result.Dropped = splitDropped(records, dropped)
+	s.metrics.MessagesAdded(driverName, len(result.Accepted))
+	return result, nil
+}
+
+// nextID returns the identifier of the next outbox message.
+func (s *Store) nextID() int64 {
+	return s.idGenerator.NextID()
+}
+
+// saveMessages saves the messages in the outbox store, it returns the messages stored and
+// those dropped as duplicates.
+func (s *Store) saveMessages(ctx context.Context, messages []dto.Outbox) (accepted, dropped []dto.Outbox, err error) {
 	if s.beforeSaveBatch != nil {
 		if err = s.beforeSaveBatch(ctx, messages); err != nil {
-			return err
+			return nil, nil, err
 		}
 	}
-	if err = s.repo.NewRecords(ctx, messages); err != nil {
+	startedAt := time.Now()
+	droppedIDs, err := s.newRecords(ctx, messages)
+	if err != nil {
 		if !s.setting.BackoffEnabled {
-			return err
+			s.metrics.SaveFailed()
+			s.logger.ErrorContext(ctx, "failed to save batch", "batch_size", len(messages), "error", err)
+			return nil, nil, err
 		}
 		for i := 0; i < s.setting.BackoffMaxRetries; i++ {
+			s.logger.WarnContext(ctx, "retrying batch save", "batch_size", len(messages), "attempt", i+1, "error", err)
 			time.Sleep(s.setting.BackoffDelay)
-			if err = s.repo.NewRecords(ctx, messages); err == nil {
+			s.metrics.SaveRetried()
+			if droppedIDs, err = s.newRecords(ctx, messages); err == nil {
 				break
 			}
 		}
 		if err != nil {
-			return err
+			s.metrics.SaveFailed()
+			s.logger.ErrorContext(ctx, "failed to save batch", "batch_size", len(messages), "error", err)
+			return nil, nil, err
 		}
 	}
+	s.metrics.BatchSaved(len(messages), time.Since(startedAt))
+	accepted, dropped = splitDropped(messages, droppedIDs)
 	if s.afterSaveBatch != nil {
-		if err = s.afterSaveBatch(ctx, messages); err != nil {
-			return err
+		if err = s.afterSaveBatch(ctx, accepted); err != nil {
+			return nil, nil, err
+		}
+	}
+	return accepted, dropped, nil
+}
+
+// newRecords writes the messages to the repository inside a span.
+func (s *Store) newRecords(ctx context.Context, messages []dto.Outbox) ([]int64, error) {
+	ctx, span := s.startSpan(ctx, "NewRecords", len(messages))
+	droppedIDs, err := s.repo.NewRecords(ctx, messages)
+	endSpan(span, err)
+	return droppedIDs, err
+}
+
+// splitDropped splits the messages into the stored ones and those dropped by the repository.
+func splitDropped(messages []dto.Outbox, droppedIDs []int64) (accepted, dropped []dto.Outbox) {
+	ids := make(map[int64]struct{}, len(droppedIDs))
+	for _, id := range droppedIDs {
+		ids[id] = struct{}{}
+	}
+
+	for _, message := range messages {
+		if _, ok := ids[message.ID]; ok {
+			dropped = append(dropped, message)
+			continue
 		}
+		accepted = append(accepted, message)
 	}
-	return nil
+	return accepted, dropped
 }
 
 // AutoCommit starts a ticker that periodically saves the messages in the outbox store.
@@ -141,13 +408,14 @@ func (s *Store) AutoCommit(ctx context.Context) error {
 		case _ = <-ctx.Done():
 			{
 				s.muMessages.Lock()
-				if err := s.saveMessages(ctx, s.messages); err != nil {
+				if _, _, err := s.saveMessages(ctx, s.messages); err != nil {
 					s.muMessages.Unlock()
 					return err
 				}
 
 				// reset messages after saving
 				s.messages = []dto.Outbox{}
+				s.metrics.BufferSize(0)
 				s.muMessages.Unlock()
 				s.ticker.Stop()
 				return nil
@@ -160,13 +428,14 @@ func (s *Store) AutoCommit(ctx context.Context) error {
 					continue
 				}
 
-				if err := s.saveMessages(ctx, s.messages); err != nil {
+				if _, _, err := s.saveMessages(ctx, s.messages); err != nil {
 					s.muMessages.Unlock()
 					return err
 				}
 
 				// reset messages after saving
 				s.messages = []dto.Outbox{}
+				s.metrics.BufferSize(0)
 				s.muMessages.Unlock()
 			}
 		}
@@ -192,9 +461,167 @@ func (s *Store) Messages() []dto.Outbox {
 
 // FetchMessages fetches messages from the repository with a limit.
 func (s *Store) FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error) {
-	return s.repo.FetchMessages(ctx, limit)
+	ctx, span := s.startSpan(ctx, "FetchMessages", 0)
+	messages, err := s.repo.FetchMessages(ctx, limit)
+	span.SetAttributes(attribute.Int("messaging.batch.message_count", len(messages)))
+	endSpan(span, err)
+	return messages, err
 }
 
+// MarkAsProcessed marks a fetched message as succeeded and releases its claim.
 func (s *Store) MarkAsProcessed(ctx context.Context, id int64) error {
-	panic("implement me")
+	return s.repo.MarkAsProcessed(ctx, id)
+}
+
+// MarkAsFailed marks a fetched message as failed, records the error and counts the attempt.
+// A nil error records an empty reason.
+func (s *Store) MarkAsFailed(ctx context.Context, id int64, err error) error {
+	return s.repo.MarkAsFailed(ctx, id, errorReason(err))
+}
+
+// MarkAsRetry records a failed attempt of a fetched message and returns it to the pending
+// state, it is not fetched again before nextAttemptAt. A nil error records an empty reason.
+func (s *Store) MarkAsRetry(ctx context.Context, id int64, err error, nextAttemptAt time.Time) error {
+	return s.repo.MarkAsRetry(ctx, id, errorReason(err), nextAttemptAt)
+}
+
+// MarkAsDeadLettered moves a fetched message that exhausted its retries to the dead letters,
+// keeping the error and the attempt history. A nil error records an empty reason.
+func (s *Store) MarkAsDeadLettered(ctx context.Context, id int64, err error) error {
+	return s.repo.MarkAsDeadLettered(ctx, id, errorReason(err))
+}
+
+// errorReason returns the text of the error recorded for a message, empty for a nil error.
+func errorReason(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// MarkAsExpired moves a fetched message that expired before it was delivered to the expired
+// state, without counting an attempt.
+func (s *Store) MarkAsExpired(ctx context.Context, id int64) error {
+	return s.repo.MarkAsExpired(ctx, id)
+}
+
+// Release returns a fetched message to the pending state without counting an attempt,
+// so that any node can claim it again.
+func (s *Store) Release(ctx context.Context, id int64) error {
+	return s.repo.Release(ctx, id)
+}
+
+// ReleaseStale returns messages claimed longer than visibilityTimeout ago to the pending state,
+// counting the interrupted attempt. It recovers the claims of crashed nodes and reports how
+// many messages were released.
+func (s *Store) ReleaseStale(ctx context.Context, visibilityTimeout time.Duration) (int64, error) {
+	return s.repo.ReleaseStale(ctx, time.Now().Add(-visibilityTimeout))
+}
+
+// DeadLetters lists the dead-lettered messages of the driver ordered by id, an empty
+// driver name lists the dead letters of every driver.
+func (s *Store) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	return s.repo.DeadLetters(ctx, driverName, limit, offset)
+}
+
+// DeadLetter returns a single dead-lettered message for inspection.
+func (s *Store) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	return s.repo.DeadLetter(ctx, id)
+}
+
+// RequeueDeadLetters returns dead-lettered messages of the driver to the pending state with a
+// fresh retry budget and no expiry, every dead letter of the driver is requeued when no ids are given.
+// It reports how many messages were requeued.
+func (s *Store) RequeueDeadLetters(ctx context.Context, driverName string, ids ...int64) (int64, error) {
+	return s.repo.RequeueDeadLetters(ctx, driverName, ids)
+}
+
+// PurgeDeadLetters deletes dead-lettered messages of the driver, every dead letter of the
+// driver is deleted when no ids are given. It reports how many messages were deleted.
+func (s *Store) PurgeDeadLetters(ctx context.Context, driverName string, ids ...int64) (int64, error) {
+	return s.repo.PurgeDeadLetters(ctx, driverName, ids)
+}
+
+// FinishedMessages lists up to limit messages in one of the states that finished longer than
+// olderThan ago ordered by id, a message finishes with its last attempt or its creation when
+// it was never attempted.
+func (s *Store) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, olderThan time.Duration, limit int) ([]dto.Outbox, error) {
+	if len(states) == 0 || limit <= 0 {
+		return nil, nil
+	}
+	return s.repo.FinishedMessages(ctx, states, time.Now().Add(-olderThan), limit)
+}
+
+// DeleteMessages deletes the messages of the ids that are still in one of the states, so a message
+// requeued meanwhile is kept. It reports how many messages were deleted.
+func (s *Store) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids ...int64) (int64, error) {
+	if len(states) == 0 || len(ids) == 0 {
+		return 0, nil
+	}
+	return s.repo.DeleteMessages(ctx, states, ids)
+}
+
+// ListMessages lists the messages selected by the filter ordered by id, a page of a hundred
+// messages is listed when the filter has no limit.
+func (s *Store) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Offset < 0 {
+		filter.Offset = 0
+	}
+	return s.repo.ListMessages(ctx, filter)
+}
+
+// GetMessage returns the message with the given id whatever its state.
+func (s *Store) GetMessage(ctx context.Context, id int64) (dto.Outbox, error) {
+	messages, err := s.repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{id}, Limit: 1})
+	if err != nil {
+		return dto.Outbox{}, err
+	}
+	if len(messages) == 0 {
+		return dto.Outbox{}, constant.ErrMessageNotFound
+	}
+	return messages[0], nil
+}
+
+// Stats counts the messages of every driver and state.
+func (s *Store) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	return s.repo.Stats(ctx)
+}
+
+// RetryMessages makes the pending messages of the ids waiting for a retry or their delivery time
+// due right away, their attempts are kept. It reports how many messages were rescheduled.
+func (s *Store) RetryMessages(ctx context.Context, ids ...int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return s.repo.RetryMessages(ctx, ids)
+}
+
+// RequeueMessages returns the messages of the ids that are in one of the states to the pending
+// state with a fresh retry budget and no expiry, such as dead letters or succeeded messages to
+// replay. It reports how many messages were requeued.
+func (s *Store) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids ...int64) (int64, error) {
+	if len(states) == 0 || len(ids) == 0 {
+		return 0, nil
+	}
+	return s.repo.RequeueMessages(ctx, states, ids)
+}
+
+// CancelMessages moves the pending messages of the ids to the canceled state, so that they are
+// never delivered. It reports how many messages were canceled.
+func (s *Store) CancelMessages(ctx context.Context, ids ...int64) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	return s.repo.CancelMessages(ctx, ids)
+}
+
+// Codec returns the codec encoding the payloads of the driver, codec.JSON when none is configured.
+func (s *Store) Codec(driverName string) codec.ICodec {
+	if c, ok := s.setting.Codecs[driverName]; ok && c != nil {
+		return c
+	}
+	return codec.JSON
 }
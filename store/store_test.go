@@ -2,7 +2,11 @@ package store
 
 import (
 	"context"
+	"errors"
+	"github.com/ghaninia/gbox/codec"
+	"github.com/ghaninia/gbox/constant"
 	"github.com/ghaninia/gbox/dto"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,8 +24,116 @@ func (m *MockRepository) GetTableName() string {
 	return args.String(0)
 }
 
-func (m *MockRepository) NewRecords(ctx context.Context, records []dto.Outbox) error {
+func (m *MockRepository) NewRecords(ctx context.Context, records []dto.Outbox) ([]int64, error) {
 	args := m.Called(ctx, records)
+	dropped, _ := args.Get(0).([]int64)
+	return dropped, args.Error(1)
+}
+
+func (m *MockRepository) NewRecordsTx(ctx context.Context, tx any, records []dto.Outbox) ([]int64, error) {
+	args := m.Called(ctx, tx, records)
+	dropped, _ := args.Get(0).([]int64)
+	return dropped, args.Error(1)
+}
+
+func (m *MockRepository) FetchMessages(ctx context.Context, limit int) ([]dto.Outbox, error) {
+	args := m.Called(ctx, limit)
+	records, _ := args.Get(0).([]dto.Outbox)
+	return records, args.Error(1)
+}
+
+func (m *MockRepository) MarkAsProcessed(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkAsFailed(ctx context.Context, id int64, reason string) error {
+	args := m.Called(ctx, id, reason)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkAsRetry(ctx context.Context, id int64, reason string, nextAttemptAt time.Time) error {
+	args := m.Called(ctx, id, reason, nextAttemptAt)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkAsDeadLettered(ctx context.Context, id int64, reason string) error {
+	args := m.Called(ctx, id, reason)
+	return args.Error(0)
+}
+
+func (m *MockRepository) MarkAsExpired(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeadLetters(ctx context.Context, driverName string, limit, offset int) ([]dto.Outbox, error) {
+	args := m.Called(ctx, driverName, limit, offset)
+	records, _ := args.Get(0).([]dto.Outbox)
+	return records, args.Error(1)
+}
+
+func (m *MockRepository) DeadLetter(ctx context.Context, id int64) (dto.Outbox, error) {
+	args := m.Called(ctx, id)
+	record, _ := args.Get(0).(dto.Outbox)
+	return record, args.Error(1)
+}
+
+func (m *MockRepository) RequeueDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	args := m.Called(ctx, driverName, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) PurgeDeadLetters(ctx context.Context, driverName string, ids []int64) (int64, error) {
+	args := m.Called(ctx, driverName, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) FinishedMessages(ctx context.Context, states []dto.OutboxStateEnum, finishedBefore time.Time, limit int) ([]dto.Outbox, error) {
+	args := m.Called(ctx, states, finishedBefore, limit)
+	records, _ := args.Get(0).([]dto.Outbox)
+	return records, args.Error(1)
+}
+
+func (m *MockRepository) DeleteMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	args := m.Called(ctx, states, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) ListMessages(ctx context.Context, filter dto.MessageFilter) ([]dto.Outbox, error) {
+	args := m.Called(ctx, filter)
+	records, _ := args.Get(0).([]dto.Outbox)
+	return records, args.Error(1)
+}
+
+func (m *MockRepository) Stats(ctx context.Context) ([]dto.MessageStats, error) {
+	args := m.Called(ctx)
+	stats, _ := args.Get(0).([]dto.MessageStats)
+	return stats, args.Error(1)
+}
+
+func (m *MockRepository) RetryMessages(ctx context.Context, ids []int64) (int64, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) RequeueMessages(ctx context.Context, states []dto.OutboxStateEnum, ids []int64) (int64, error) {
+	args := m.Called(ctx, states, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) CancelMessages(ctx context.Context, ids []int64) (int64, error) {
+	args := m.Called(ctx, ids)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) ReleaseStale(ctx context.Context, lockedBefore time.Time) (int64, error) {
+	args := m.Called(ctx, lockedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepository) Release(ctx context.Context, id int64) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
@@ -33,18 +145,22 @@ func newTestMessage(payload string) dto.NewMessage {
 
 func setupStoreWithMockRepo(t *testing.T, maxBatchSize int, interval time.Duration) (*Store, *MockRepository) {
 	mockRepo := &MockRepository{}
-	s := NewStore(mockRepo, Setting{
+	s, err := NewStore(mockRepo, Setting{
 		MaxBatchSize:   maxBatchSize,
 		IntervalTicker: interval,
-	}).(*Store)
-	return s, mockRepo
+	})
+	if err != nil {
+		t.Fatalf("failed to create the store: %v", err)
+	}
+	return s.(*Store), mockRepo
 }
 
 func TestAdd_UnderBatchSize_NoSave(t *testing.T) {
 	s, mockRepo := setupStoreWithMockRepo(t, 3, time.Second)
+	s.setting.BatchInsertEnabled = true
 	mockRepo.AssertNotCalled(t, "NewRecords")
 
-	err := s.Add(context.TODO(), "test-driver", newTestMessage("msg1"))
+	_, err := s.Add(context.TODO(), "test-driver", newTestMessage("msg1"))
 	assert.NoError(t, err)
 
 	assert.Len(t, s.Messages(), 1)
@@ -52,12 +168,792 @@ func TestAdd_UnderBatchSize_NoSave(t *testing.T) {
 
 func TestAdd_ExactBatchSize_ShouldSave(t *testing.T) {
 	s, mockRepo := setupStoreWithMockRepo(t, 2, time.Second)
+	s.setting.BatchInsertEnabled = true
+
+	mockRepo.On("NewRecords", mock.Anything, mock.Anything).Return(nil, nil).Once()
+
+	_, err := s.Add(context.TODO(), "test-driver", newTestMessage("msg1"), newTestMessage("msg2"))
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	assert.Len(t, s.Messages(), 0)
+}
+
+func TestAdd_BatchDisabled_SavesEachMessage(t *testing.T) {
+	mockRepo := &MockRepository{}
+	s, err := NewStore(mockRepo, Setting{})
+	assert.NoError(t, err)
 
-	mockRepo.On("NewRecords", mock.Anything, mock.Anything).Return(nil).Once()
+	mockRepo.On("NewRecords", mock.Anything, mock.MatchedBy(func(records []dto.Outbox) bool {
+		return len(records) == 1
+	})).Return(nil, nil).Twice()
 
-	err := s.Add(context.TODO(), "test-driver", newTestMessage("msg1"), newTestMessage("msg2"))
+	_, err = s.Add(context.TODO(), "test-driver", newTestMessage("msg1"), newTestMessage("msg2"))
 	assert.NoError(t, err)
 
 	mockRepo.AssertExpectations(t)
 	assert.Len(t, s.Messages(), 0)
 }
+
+// countingMetrics counts the observations of the store
+type countingMetrics struct {
+	noopMetrics
+	added, saved, retried, failed int
+}
+
+func (m *countingMetrics) MessagesAdded(_ string, count int)    { m.added += count }
+func (m *countingMetrics) BatchSaved(size int, _ time.Duration) { m.saved += size }
+func (m *countingMetrics) SaveRetried()                         { m.retried++ }
+func (m *countingMetrics) SaveFailed()                          { m.failed++ }
+
+func TestAdd_RecordsSaveRetriesAndFailures(t *testing.T) {
+	var (
+		mockRepo = &MockRepository{}
+		metrics  = &countingMetrics{}
+	)
+	s, err := NewStore(mockRepo, Setting{BackoffEnabled: true, BackoffMaxRetries: 2, BackoffDelay: time.Millisecond, Metrics: metrics})
+	assert.NoError(t, err)
+
+	mockRepo.On("NewRecords", mock.Anything, mock.Anything).Return(nil, errors.New("connection refused")).Once()
+	mockRepo.On("NewRecords", mock.Anything, mock.Anything).Return(nil, nil).Once()
+	_, err = s.Add(context.TODO(), "test-driver", newTestMessage("msg1"))
+	assert.NoError(t, err)
+
+	mockRepo.On("NewRecords", mock.Anything, mock.Anything).Return(nil, errors.New("connection refused")).Times(3)
+	_, err = s.Add(context.TODO(), "test-driver", newTestMessage("msg2"))
+	assert.Error(t, err)
+
+	mockRepo.AssertExpectations(t)
+	assert.Equal(t, 1, metrics.added)
+	assert.Equal(t, 1, metrics.saved)
+	assert.Equal(t, 3, metrics.retried)
+	assert.Equal(t, 1, metrics.failed)
+}
+
+func TestMarkAsFailed_PassesErrorText(t *testing.T) {
+	s, mockRepo := setupStoreWithMockRepo(t, 2, time.Second)
+
+	mockRepo.On("MarkAsFailed", mock.Anything, int64(7), "connection refused").Return(nil).Once()
+
+	err := s.MarkAsFailed(context.TODO(), 7, errors.New("connection refused"))
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestMarkAs_NilErrorRecordsEmptyReason(t *testing.T) {
+	s, mockRepo := setupStoreWithMockRepo(t, 2, time.Second)
+	nextAttemptAt := time.Now()
+
+	mockRepo.On("MarkAsFailed", mock.Anything, int64(7), "").Return(nil).Once()
+	mockRepo.On("MarkAsRetry", mock.Anything, int64(8), "", nextAttemptAt).Return(nil).Once()
+	mockRepo.On("MarkAsDeadLettered", mock.Anything, int64(9), "").Return(nil).Once()
+
+	assert.NoError(t, s.MarkAsFailed(context.TODO(), 7, nil))
+	assert.NoError(t, s.MarkAsRetry(context.TODO(), 8, nil, nextAttemptAt))
+	assert.NoError(t, s.MarkAsDeadLettered(context.TODO(), 9, nil))
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestAddTx_BypassesBuffer(t *testing.T) {
+	s, mockRepo := setupStoreWithMockRepo(t, 10, time.Second)
+
+	tx := struct{}{}
+	mockRepo.On("NewRecordsTx", mock.Anything, tx, mock.MatchedBy(func(records []dto.Outbox) bool {
+		return len(records) == 2 && records[0].DriverName == "test-driver"
+	})).Return(nil, nil).Once()
+
+	_, err := s.AddTx(context.TODO(), tx, "test-driver", newTestMessage("msg1"), newTestMessage("msg2"))
+	assert.NoError(t, err)
+
+	mockRepo.AssertExpectations(t)
+	assert.Len(t, s.Messages(), 0)
+}
+
+func TestAdd_KeepsMessageMetadata(t *testing.T) {
+	ctx := context.TODO()
+	s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{})
+	assert.NoError(t, err)
+
+	_, err = s.Add(ctx, "test-driver", dto.NewMessage{
+		Payload:   "msg1",
+		Key:       "customer-42",
+		EventType: "customer.created",
+		Headers:   dto.Headers{"correlation_id": "c-1"},
+	})
+	assert.NoError(t, err)
+
+	records, err := s.FetchMessages(ctx, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "msg1", records[0].Payload)
+		assert.Equal(t, "customer-42", records[0].Key)
+		assert.Equal(t, "customer.created", records[0].EventType)
+		assert.Equal(t, dto.Headers{"correlation_id": "c-1"}, records[0].Headers)
+	}
+}
+
+func TestAdd_SchedulesDelivery(t *testing.T) {
+	ctx := context.TODO()
+	s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{})
+	assert.NoError(t, err)
+
+	result, err := s.Add(ctx, "test-driver",
+		dto.NewMessage{Payload: "now"},
+		dto.NewMessage{Payload: "delayed", Delay: 100 * time.Millisecond},
+		dto.NewMessage{Payload: "tomorrow", DeliverAt: time.Now().Add(24 * time.Hour), Delay: time.Millisecond},
+	)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Accepted, 3) {
+		assert.Nil(t, result.Accepted[0].NextAttemptAt)
+		assert.NotNil(t, result.Accepted[1].NextAttemptAt)
+		assert.NotNil(t, result.Accepted[2].NextAttemptAt)
+	}
+
+	records, err := s.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 1) {
+		assert.Equal(t, "now", records[0].Payload)
+	}
+
+	assert.Eventually(t, func() bool {
+		records, err = s.FetchMessages(ctx, 10)
+		return err == nil && len(records) == 1 && records[0].Payload == "delayed"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestAddValues_EncodesWithDriverCodec(t *testing.T) {
+	type order struct {
+		ID    int    `json:"id" msgpack:"id"`
+		Total string `json:"total" msgpack:"total"`
+	}
+
+	ctx := context.TODO()
+	s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{
+		Codecs: map[string]codec.ICodec{"kafka": codec.Msgpack},
+	})
+	assert.NoError(t, err)
+
+	_, err = AddValues(ctx, s, "grpc", order{ID: 1, Total: "9.90"})
+	assert.NoError(t, err)
+	_, err = AddValues(ctx, s, "kafka", order{ID: 2, Total: "19.90"})
+	assert.NoError(t, err)
+
+	records, err := s.FetchMessages(ctx, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, records, 2) {
+		assert.Equal(t, `{"id":1,"total":"9.90"}`, records[0].Payload)
+		assert.Equal(t, "application/json", records[0].ContentType)
+		assert.Equal(t, "application/msgpack", records[1].ContentType)
+
+		for i, record := range records {
+			decoded, err := codec.Decode[order](record)
+			assert.NoError(t, err)
+			assert.Equal(t, i+1, decoded.ID)
+		}
+	}
+}
+
+func TestNewStore_InvalidNodeID(t *testing.T) {
+	_, err := NewStore(&MockRepository{}, Setting{NodeID: 1024})
+	assert.ErrorIs(t, err, constant.ErrInvalidNodeID)
+
+	_, err = NewStore(&MockRepository{}, Setting{NodeID: -1})
+	assert.ErrorIs(t, err, constant.ErrInvalidNodeID)
+}
+
+func TestNewSnowflakeGenerator_UniqueAndOrdered(t *testing.T) {
+	generator, err := NewSnowflakeGenerator(1)
+	assert.NoError(t, err)
+
+	var (
+		previous int64
+		seen     = make(map[int64]struct{})
+	)
+	for i := 0; i < 10000; i++ {
+		id := generator.NextID()
+		assert.Greater(t, id, previous)
+		seen[id] = struct{}{}
+		previous = id
+	}
+	assert.Len(t, seen, 10000)
+}
+
+// TestNewStore_SharedNodeID tests that stores of the same node id never generate an id twice.
+func TestNewStore_SharedNodeID(t *testing.T) {
+	ctx := context.Background()
+
+	var stores []IStore
+	for _, driverName := range []string{"grpc", "kafka"} {
+		s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{DriverName: driverName})
+		assert.NoError(t, err)
+		stores = append(stores, s)
+	}
+
+	var (
+		wg  sync.WaitGroup
+		ids = make([][]int64, len(stores))
+	)
+	for i, s := range stores {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				result, err := AddValues(ctx, s, "grpc", j)
+				assert.NoError(t, err)
+				for _, message := range result.Accepted {
+					ids[i] = append(ids[i], message.ID)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[int64]struct{})
+	for _, storeIDs := range ids {
+		assert.Len(t, storeIDs, 1000)
+		for _, id := range storeIDs {
+			seen[id] = struct{}{}
+		}
+	}
+	assert.Len(t, seen, 2000)
+}
+
+// TestMigrate_Unsupported tests that repositories without migrations are rejected.
+func TestMigrate_Unsupported(t *testing.T) {
+	assert.ErrorIs(t, Migrate(context.Background(), &MockRepository{}), constant.ErrMigrationUnsupported)
+}
+
+// newPendingRecords returns count pending records created one millisecond apart
+func newPendingRecords(count int) []dto.Outbox {
+	var (
+		records   = make([]dto.Outbox, 0, count)
+		createdAt = time.Now().Add(-time.Duration(count) * time.Millisecond)
+	)
+	for i := 1; i <= count; i++ {
+		records = append(records, dto.Outbox{
+			ID:         int64(i),
+			Payload:    `{"name": "John Doe"}`,
+			DriverName: "grpc",
+			State:      dto.OutboxStatePending,
+			CreatedAt:  createdAt.Add(time.Duration(i) * time.Millisecond),
+		})
+	}
+	return records
+}
+
+// testFetchMessagesOrderingKey tests that a repository delivers the records of an ordering key one at a time in creation order.
+func testFetchMessagesOrderingKey(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(5)
+	for i, key := range []string{"order-1", "order-2", "order-1", "", "order-1"} {
+		records[i].OrderingKey = key
+	}
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	fetchIDs := func() []int64 {
+		claimed, err := repo.FetchMessages(ctx, 10)
+		assert.NoError(t, err)
+		ids := make([]int64, 0, len(claimed))
+		for _, record := range claimed {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{1, 2, 4}, fetchIDs())
+	assert.Empty(t, fetchIDs())
+
+	// a retried record keeps holding back the later records of its key
+	assert.NoError(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now().Add(-time.Second)))
+	assert.Equal(t, []int64{1}, fetchIDs())
+
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 1))
+	assert.Equal(t, []int64{3}, fetchIDs())
+
+	assert.NoError(t, repo.MarkAsDeadLettered(ctx, 3, "poison"))
+	assert.Equal(t, []int64{5}, fetchIDs())
+}
+
+// testFetchMessagesScheduled tests that a repository holds back the records scheduled for a later delivery.
+func testFetchMessagesScheduled(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	deliverAt := time.Now().Add(200 * time.Millisecond)
+	records := newPendingRecords(4)
+	records[0].NextAttemptAt = &deliverAt
+	records[2].OrderingKey = "order-1"
+	records[3].OrderingKey = "order-1"
+	records[3].NextAttemptAt = &deliverAt
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	fetchIDs := func() []int64 {
+		claimed, err := repo.FetchMessages(ctx, 10)
+		assert.NoError(t, err)
+		ids := make([]int64, 0, len(claimed))
+		for _, record := range claimed {
+			ids = append(ids, record.ID)
+		}
+		return ids
+	}
+
+	assert.Equal(t, []int64{2, 3}, fetchIDs())
+
+	// the next record of an ordering key keeps its delivery time
+	assert.NoError(t, repo.MarkAsProcessed(ctx, 3))
+	assert.Empty(t, fetchIDs())
+
+	var delivered []int64
+	assert.Eventually(t, func() bool {
+		delivered = append(delivered, fetchIDs()...)
+		return len(delivered) == 2
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.ElementsMatch(t, []int64{1, 4}, delivered)
+	assert.False(t, time.Now().Before(deliverAt))
+}
+
+// testMarkAsExpired tests that a repository keeps the expiry time and finishes expired records without delivering them.
+func testMarkAsExpired(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(time.Hour)
+	records := newPendingRecords(2)
+	records[0].ExpiresAt = &expiresAt
+	records[0].OrderingKey = "order-1"
+	records[1].OrderingKey = "order-1"
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) && assert.NotNil(t, claimed[0].ExpiresAt) {
+		assert.WithinDuration(t, expiresAt, *claimed[0].ExpiresAt, time.Millisecond)
+	}
+
+	// an expired record releases the next record of its ordering key
+	assert.NoError(t, repo.MarkAsExpired(ctx, 1))
+	claimed, err = repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 1) {
+		assert.Equal(t, int64(2), claimed[0].ID)
+		assert.Nil(t, claimed[0].ExpiresAt)
+	}
+
+	assert.ErrorIs(t, repo.MarkAsExpired(ctx, 999), constant.ErrMessageNotFound)
+}
+
+// testMessageMetadata tests that a repository keeps the key, event type and headers of its records.
+func testMessageMetadata(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(2)
+	records[0].Key = "customer-42"
+	records[0].EventType = "customer.created"
+	records[0].Headers = dto.Headers{"correlation_id": "c-1", "content_type": "application/json"}
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, claimed, 2) {
+		assert.Equal(t, "customer-42", claimed[0].Key)
+		assert.Equal(t, "customer.created", claimed[0].EventType)
+		assert.Equal(t, records[0].Headers, claimed[0].Headers)
+
+		// records without metadata keep empty headers
+		assert.Empty(t, claimed[1].Key)
+		assert.Empty(t, claimed[1].EventType)
+		assert.Empty(t, claimed[1].Headers)
+	}
+}
+
+// testAcknowledgeClaimLost tests that a node cannot acknowledge a record it lost the claim of to the peer,
+// a repository of another node on the same storage
+func testAcknowledgeClaimLost(t *testing.T, repo, peer IRepository) {
+	ctx := context.Background()
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(1))
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, claimIDs(t, repo, 10))
+
+	// the reaper takes the record back from the slow node and the peer claims it
+	released, err := repo.ReleaseStale(ctx, time.Now().Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), released)
+	assert.Equal(t, []int64{1}, claimIDs(t, peer, 10))
+
+	assert.ErrorIs(t, repo.MarkAsProcessed(ctx, 1), constant.ErrClaimLost)
+	assert.ErrorIs(t, repo.MarkAsFailed(ctx, 1, "timeout"), constant.ErrClaimLost)
+	assert.ErrorIs(t, repo.MarkAsDeadLettered(ctx, 1, "timeout"), constant.ErrClaimLost)
+	assert.ErrorIs(t, repo.MarkAsRetry(ctx, 1, "timeout", time.Now()), constant.ErrClaimLost)
+	assert.ErrorIs(t, repo.MarkAsExpired(ctx, 1), constant.ErrClaimLost)
+	assert.ErrorIs(t, repo.Release(ctx, 1), constant.ErrClaimLost)
+
+	assert.NoError(t, peer.MarkAsProcessed(ctx, 1))
+	assert.ErrorIs(t, peer.MarkAsProcessed(ctx, 1), constant.ErrClaimLost)
+
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{1}, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 1) && assert.NotNil(t, listed[0].NumberOfAttempts) {
+		assert.Equal(t, dto.OutboxStateSucceed, listed[0].State)
+		assert.Equal(t, int64(2), *listed[0].NumberOfAttempts)
+	}
+}
+
+// testAcknowledgeConcurrent tests that a repository acknowledges every claimed record when the nodes acknowledge them concurrently.
+func testAcknowledgeConcurrent(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	_, err := repo.NewRecords(ctx, newPendingRecords(50))
+	assert.NoError(t, err)
+
+	claimed, err := repo.FetchMessages(ctx, 50)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 50)
+
+	var wg sync.WaitGroup
+	for _, record := range claimed {
+		wg.Add(1)
+		go func(id int64) {
+			defer wg.Done()
+			assert.NoError(t, repo.MarkAsProcessed(ctx, id))
+		}(record.ID)
+	}
+	wg.Wait()
+
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{States: []dto.OutboxStateEnum{dto.OutboxStateSucceed}, Limit: 100})
+	assert.NoError(t, err)
+	assert.Len(t, listed, 50)
+}
+
+// claimIDs fetches up to limit records from the repository and returns their ids.
+func claimIDs(t *testing.T, repo IRepository, limit int) []int64 {
+	claimed, err := repo.FetchMessages(context.Background(), limit)
+	assert.NoError(t, err)
+	return outboxIDs(claimed)
+}
+
+// testFetchMessagesPriority tests that a repository with strict priorities fetches the higher priorities first in creation order.
+func testFetchMessagesPriority(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(5)
+	for i, priority := range []int{0, 5, -1, 5, 1} {
+		records[i].Priority = priority
+	}
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int64{2, 4, 5}, claimIDs(t, repo, 3))
+	assert.Equal(t, []int64{1, 3}, claimIDs(t, repo, 10))
+}
+
+// testFetchMessagesStarvation tests that a repository fetches the records waiting longer than its starvation timeout of 100ms ahead of higher priorities.
+func testFetchMessagesStarvation(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(3)
+	_, err := repo.NewRecords(ctx, records[:1])
+	assert.NoError(t, err)
+
+	time.Sleep(150 * time.Millisecond)
+	createdAt := time.Now().Add(-time.Duration(len(records)) * time.Millisecond)
+	for i := 1; i < len(records); i++ {
+		records[i].Priority = 5
+		records[i].CreatedAt = createdAt.Add(time.Duration(i) * time.Millisecond)
+	}
+	_, err = repo.NewRecords(ctx, records[1:])
+	assert.NoError(t, err)
+
+	assert.Equal(t, []int64{1, 2}, claimIDs(t, repo, 2))
+	assert.Equal(t, []int64{3}, claimIDs(t, repo, 10))
+}
+
+// testFetchMessagesStarvedPriority tests that a repository fetches its starved records longest waiting first,
+// even when higher priorities are starved as well.
+func testFetchMessagesStarvedPriority(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(3)
+	records[0].CreatedAt = time.Now().Add(-time.Hour)
+	_, err := repo.NewRecords(ctx, records[:1])
+	assert.NoError(t, err)
+
+	for i := 1; i < len(records); i++ {
+		records[i].Priority = 5
+		records[i].CreatedAt = time.Now().Add(-time.Minute)
+	}
+	_, err = repo.NewRecords(ctx, records[1:])
+	assert.NoError(t, err)
+
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, []int64{1}, claimIDs(t, repo, 1))
+	assert.Equal(t, []int64{2, 3}, claimIDs(t, repo, 10))
+}
+
+// testFinishedMessages tests that a repository lists and deletes the records finished before the retention.
+func testFinishedMessages(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	var (
+		finishedAt     = time.Now().Add(-2 * time.Hour)
+		finishedBefore = time.Now().Add(-time.Hour)
+		records        = newPendingRecords(5)
+	)
+	for i, state := range []dto.OutboxStateEnum{dto.OutboxStateSucceed, dto.OutboxStateSucceed, dto.OutboxStateDeadLettered, dto.OutboxStateExpired, dto.OutboxStatePending} {
+		records[i].State = state
+		records[i].CreatedAt = finishedAt
+	}
+	records[0].LastAttemptedAt = &finishedAt
+	records[2].LastAttemptedAt = &finishedAt
+	lastAttemptedAt := time.Now()
+	records[1].LastAttemptedAt = &lastAttemptedAt
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	finished, err := repo.FinishedMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateSucceed, dto.OutboxStateExpired}, finishedBefore, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 4}, outboxIDs(finished))
+
+	finished, err = repo.FinishedMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateSucceed, dto.OutboxStateExpired}, finishedBefore, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1}, outboxIDs(finished))
+
+	// the records are deleted only while they are in one of the states
+	deleted, err := repo.DeleteMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateSucceed}, []int64{1, 2, 3, 4, 5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	finished, err = repo.FinishedMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateSucceed, dto.OutboxStateDeadLettered, dto.OutboxStateExpired}, finishedBefore, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 4}, outboxIDs(finished))
+
+	deleted, err = repo.DeleteMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateDeadLettered}, []int64{3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	_, err = repo.DeadLetter(ctx, 3)
+	assert.ErrorIs(t, err, constant.ErrMessageNotFound)
+	assert.Equal(t, []int64{5}, claimIDs(t, repo, 10))
+}
+
+// testAdminQueries tests that a repository lists, counts, retries, cancels and requeues records for an operator.
+func testAdminQueries(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	var (
+		now       = time.Now()
+		nextRetry = now.Add(time.Hour)
+		expiredAt = now.Add(-time.Minute)
+		attempts  = int64(3)
+		records   = newPendingRecords(6)
+	)
+	for i, state := range []dto.OutboxStateEnum{dto.OutboxStatePending, dto.OutboxStatePending, dto.OutboxStateDeadLettered, dto.OutboxStateSucceed, dto.OutboxStateExpired, dto.OutboxStatePending} {
+		records[i].State = state
+	}
+	records[1].DriverName, records[1].NextAttemptAt = "kafka", &nextRetry
+	records[2].NumberOfAttempts, records[2].LastAttemptedAt = &attempts, &now
+	records[3].LastAttemptedAt = &now
+	records[4].DriverName, records[4].ExpiresAt = "kafka", &expiredAt
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 2, 3, 4, 5, 6}, outboxIDs(listed))
+
+	listed, err = repo.ListMessages(ctx, dto.MessageFilter{States: []dto.OutboxStateEnum{dto.OutboxStatePending}, DriverName: "grpc", Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1, 6}, outboxIDs(listed))
+
+	listed, err = repo.ListMessages(ctx, dto.MessageFilter{Limit: 2, Offset: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{2, 3}, outboxIDs(listed))
+
+	listed, err = repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{5, 3, 99}, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3, 5}, outboxIDs(listed))
+
+	listed, err = repo.ListMessages(ctx, dto.MessageFilter{DriverName: "kafka", AfterID: 2, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, outboxIDs(listed))
+
+	stats, err := repo.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []dto.MessageStats{
+		{DriverName: "grpc", State: dto.OutboxStateDeadLettered, Count: 1},
+		{DriverName: "grpc", State: dto.OutboxStatePending, Count: 2},
+		{DriverName: "grpc", State: dto.OutboxStateSucceed, Count: 1},
+		{DriverName: "kafka", State: dto.OutboxStateExpired, Count: 1},
+		{DriverName: "kafka", State: dto.OutboxStatePending, Count: 1},
+	}, stats)
+
+	// only the pending record waiting for its retry is rescheduled
+	retried, err := repo.RetryMessages(ctx, []int64{1, 2, 3})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), retried)
+
+	// only pending records are canceled
+	canceled, err := repo.CancelMessages(ctx, []int64{1, 4})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), canceled)
+
+	requeued, err := repo.RequeueMessages(ctx, []dto.OutboxStateEnum{dto.OutboxStateDeadLettered, dto.OutboxStateExpired, dto.OutboxStateCanceled}, []int64{1, 3, 4, 5})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), requeued)
+
+	listed, err = repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{3, 5}, Limit: 10})
+	assert.NoError(t, err)
+	for _, record := range listed {
+		assert.Equal(t, dto.OutboxStatePending, record.State)
+		assert.Nil(t, record.NumberOfAttempts)
+		assert.Nil(t, record.ExpiresAt)
+	}
+
+	assert.ElementsMatch(t, []int64{1, 2, 3, 5, 6}, claimIDs(t, repo, 10))
+}
+
+// testRequeueDeadLettersExpiry tests that a repository drops the expiry of the dead letters it requeues.
+func testRequeueDeadLettersExpiry(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	expiredAt := time.Now().Add(-time.Minute)
+	records := newPendingRecords(1)
+	records[0].State, records[0].ExpiresAt = dto.OutboxStateDeadLettered, &expiredAt
+	_, err := repo.NewRecords(ctx, records)
+	assert.NoError(t, err)
+
+	requeued, err := repo.RequeueDeadLetters(ctx, "grpc", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), requeued)
+
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{1}, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, dto.OutboxStatePending, listed[0].State)
+		assert.Nil(t, listed[0].ExpiresAt)
+	}
+}
+
+// outboxIDs returns the ids of the records.
+func outboxIDs(records []dto.Outbox) []int64 {
+	ids := make([]int64, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.ID)
+	}
+	return ids
+}
+
+// testNewRecordsDeduplication tests that a repository drops the records whose deduplication key is held by a stored record.
+func testNewRecordsDeduplication(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	records := newPendingRecords(5)
+	for i, key := range []string{"request-1", "request-2", "request-1", "", "request-2"} {
+		if key != "" {
+			records[i].DeduplicationKey = &key
+		}
+	}
+
+	dropped, err := repo.NewRecords(ctx, records[:4])
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{3}, dropped)
+
+	dropped, err = repo.NewRecords(ctx, records[4:])
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{5}, dropped)
+
+	// a record retried after a lost acknowledgement is not a duplicate of itself
+	dropped, err = repo.NewRecords(ctx, records[:1])
+	assert.NoError(t, err)
+	assert.Empty(t, dropped)
+
+	claimed, err := repo.FetchMessages(ctx, 10)
+	assert.NoError(t, err)
+	assert.Len(t, claimed, 3)
+}
+
+// testNewRecordsDeduplicationWindow tests that the deduplication key of a record created before the window is taken over.
+func testNewRecordsDeduplicationWindow(t *testing.T, repo IRepository) {
+	ctx := context.Background()
+
+	var (
+		expired = "request-1"
+		held    = "request-2"
+		records = newPendingRecords(4)
+	)
+	records[0].DeduplicationKey, records[0].CreatedAt = &expired, time.Now().Add(-2*time.Hour)
+	records[1].DeduplicationKey = &held
+	records[2].DeduplicationKey = &expired
+	records[3].DeduplicationKey = &held
+
+	dropped, err := repo.NewRecords(ctx, records[:2])
+	assert.NoError(t, err)
+	assert.Empty(t, dropped)
+
+	dropped, err = repo.NewRecords(ctx, records[2:])
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{4}, dropped)
+
+	// the record that released its key keeps it
+	listed, err := repo.ListMessages(ctx, dto.MessageFilter{IDs: []int64{1}, Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, listed, 1) && assert.NotNil(t, listed[0].DeduplicationKey) {
+		assert.Equal(t, expired, *listed[0].DeduplicationKey)
+	}
+}
+
+func TestAdd_ReportsDroppedDuplicates(t *testing.T) {
+	ctx := context.TODO()
+	s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{})
+	assert.NoError(t, err)
+
+	result, err := s.Add(ctx, "test-driver",
+		dto.NewMessage{Payload: "msg1", DeduplicationKey: "request-1"},
+		dto.NewMessage{Payload: "msg2"},
+	)
+	assert.NoError(t, err)
+	assert.Len(t, result.Accepted, 2)
+	assert.Empty(t, result.Dropped)
+
+	result, err = s.Add(ctx, "test-driver",
+		dto.NewMessage{Payload: "msg1 retried", DeduplicationKey: "request-1"},
+		dto.NewMessage{Payload: "msg3", DeduplicationKey: "request-3"},
+	)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Dropped, 1) {
+		assert.Equal(t, "msg1 retried", result.Dropped[0].Payload)
+	}
+	if assert.Len(t, result.Accepted, 1) {
+		assert.Equal(t, "msg3", result.Accepted[0].Payload)
+	}
+}
+
+func TestAdd_ReportsDroppedDuplicatesOfBatch(t *testing.T) {
+	ctx := context.TODO()
+	s, err := NewStore(NewOutboxMemoryRepository(RepoSetting{TableName: "outbox"}), Setting{BatchInsertEnabled: true, MaxBatchSize: 3})
+	assert.NoError(t, err)
+
+	result, err := s.Add(ctx, "test-driver", dto.NewMessage{Payload: "msg1", DeduplicationKey: "request-1"})
+	assert.NoError(t, err)
+	assert.Empty(t, result.Accepted)
+	assert.Len(t, result.Buffered, 1)
+
+	result, err = s.Add(ctx, "test-driver",
+		dto.NewMessage{Payload: "msg1 retried", DeduplicationKey: "request-1"},
+		dto.NewMessage{Payload: "msg2"},
+	)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Buffered)
+	if assert.Len(t, result.Dropped, 1) {
+		assert.Equal(t, "msg1 retried", result.Dropped[0].Payload)
+	}
+	if assert.Len(t, result.Accepted, 2) {
+		assert.Equal(t, "msg1", result.Accepted[0].Payload)
+		assert.Equal(t, "msg2", result.Accepted[1].Payload)
+	}
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+
+	"github.com/ghaninia/gbox/dto"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// tracerName names the tracer of the store spans
+	tracerName = "github.com/ghaninia/gbox/store"
+	// spanNamePrefix prefixes the names of the spans around repository calls
+	spanNamePrefix = "gbox.store."
+)
+
+// injectTraceContext writes the trace context of ctx into the headers of the message, the
+// headers set by the caller are kept.
+func (s *Store) injectTraceContext(ctx context.Context, message *dto.Outbox) {
+	carrier := propagation.MapCarrier{}
+	s.propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return
+	}
+
+	if message.Headers == nil {
+		message.Headers = make(dto.Headers, len(carrier))
+	}
+	for key, value := range carrier {
+		if _, ok := message.Headers[key]; !ok {
+			message.Headers[key] = value
+		}
+	}
+}
+
+// startSpan starts a client span around the repository operation on count messages, the
+// repository is only asked for its table when the span is recorded.
+func (s *Store) startSpan(ctx context.Context, operation string, count int) (context.Context, trace.Span) {
+	ctx, span := s.tracer.Start(ctx, spanNamePrefix+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.Int("messaging.batch.message_count", count)),
+	)
+	if span.IsRecording() {
+		span.SetAttributes(attribute.String("gbox.table", s.repo.GetTableName()))
+	}
+	return ctx, span
+}
+
+// endSpan records the error of the operation on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}